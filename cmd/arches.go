@@ -0,0 +1,153 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/diva/pkginfo"
+	"github.com/spf13/cobra"
+)
+
+type archesCmdFlags struct {
+	mixName   string
+	version   string
+	latest    bool
+	against   string
+	allowlist string
+}
+
+var archesFlags archesCmdFlags
+
+func init() {
+	archesCmd.Flags().StringVarP(&archesFlags.mixName, "name", "n", "clear", "name of data group")
+	archesCmd.Flags().StringVarP(&archesFlags.version, "version", "v", "0", "version to check")
+	archesCmd.Flags().BoolVar(&archesFlags.latest, "latest", false, "get the latest version from upstreamURL")
+	archesCmd.Flags().StringVar(&archesFlags.against, "against", "", "architecture to compare against")
+	archesCmd.Flags().StringVar(&archesFlags.allowlist, "allowlist", "", "file listing packages that are only built for one architecture")
+}
+
+var archesCmd = &cobra.Command{
+	Use:   "arches --against <arch> [--arch <arch>]",
+	Short: "Compare the binary repos of two architectures",
+	Long: `Compare the binary repo of the architecture passed with --arch against the
+binary repo of the same version built for the architecture passed with
+--against. Reports packages that are only built for one of them, and packages
+whose version differs between them.
+
+Packages that are intentionally only built for one architecture can be listed
+in an allowlist file passed with --allowlist, one package name or shell
+pattern per line. Empty lines and lines starting with '#' are ignored.`,
+	Run: runCheckArches,
+}
+
+func runCheckArches(cmd *cobra.Command, args []string) {
+	if archesFlags.against == "" {
+		helpers.FailIfErr(fmt.Errorf("--against is required"))
+	}
+
+	var allowlist []string
+	var err error
+	if archesFlags.allowlist != "" {
+		allowlist, err = readAllowlist(archesFlags.allowlist)
+		helpers.FailIfErr(err)
+	}
+
+	u := config.UInfo{
+		MixName: archesFlags.mixName,
+		Ver:     archesFlags.version,
+		Latest:  archesFlags.latest,
+		Arch:    checkArch,
+	}
+	repo, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	// the other repo must be the same version, even if it was the latest
+	u.Ver = repo.Version
+	u.Latest = false
+	u.Arch = archesFlags.against
+	other, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Populating %s and %s repos", repo.Arch, other.Arch)
+	err = pkginfo.PopulateRepo(&repo)
+	helpers.FailIfErr(err)
+	err = pkginfo.PopulateRepo(&other)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("Repos populated successfully")
+
+	r := diva.NewSuite("arches", fmt.Sprintf("compare %s and %s repos", repo.Arch, other.Arch))
+	r.AddSource(repo.Dataset(), repo.Provenance)
+	r.AddSource(other.Dataset(), other.Provenance)
+
+	checkArches(r, &repo, &other, allowlist)
+
+	if r.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// onlyIn returns the sorted names in a that are not in b or the allowlist
+func onlyIn(a, b map[string]*pkginfo.RPM, allowlist []string) []string {
+	names := []string{}
+	for name := range a {
+		if _, ok := b[name]; !ok && !isAllowed(name, allowlist) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkArches compares the packages of repo against the packages of other,
+// which is the same repo built for another architecture
+func checkArches(r *diva.Results, repo, other *pkginfo.Repo, allowlist []string) {
+	rpms := pkginfo.BestRPMs(repo)
+	otherRPMs := pkginfo.BestRPMs(other)
+
+	missing := onlyIn(rpms, otherRPMs, allowlist)
+	r.Ok(len(missing) == 0, fmt.Sprintf("all %s packages are built for %s", repo.Arch, other.Arch))
+	if len(missing) > 0 {
+		r.Diagnostic(fmt.Sprintf("packages only built for %s:\n%s", repo.Arch, strings.Join(missing, "\n")))
+	}
+
+	extra := onlyIn(otherRPMs, rpms, allowlist)
+	r.Ok(len(extra) == 0, fmt.Sprintf("all %s packages are built for %s", other.Arch, repo.Arch))
+	if len(extra) > 0 {
+		r.Diagnostic(fmt.Sprintf("packages only built for %s:\n%s", other.Arch, strings.Join(extra, "\n")))
+	}
+
+	var mismatched []string
+	for name, rpm := range rpms {
+		o, ok := otherRPMs[name]
+		if !ok || rpm.EVR().Compare(o.EVR()) == 0 {
+			continue
+		}
+		mismatched = append(mismatched, fmt.Sprintf("%s is %s for %s but %s for %s",
+			name, rpm.EVR(), repo.Arch, o.EVR(), other.Arch))
+	}
+	sort.Strings(mismatched)
+
+	r.Ok(len(mismatched) == 0, "package versions match across architectures")
+	if len(mismatched) > 0 {
+		r.Diagnostic("version mismatches:\n" + strings.Join(mismatched, "\n"))
+	}
+}
@@ -73,12 +73,17 @@ of every bundle from one build only`,
 		// Set maxMInfo to be the larger of the two versions, and minMInfo to be
 		// the smaller of the two versions if more than one arg is passed
 		if len(args) == 2 {
+			older, newer := args[0], args[1]
+			if pkginfo.Vercmp(older, newer) > 0 {
+				older, newer = newer, older
+			}
+
 			manifests.maxMInfo, err = pkginfo.NewManifestInfo(conf, &u)
 			helpers.FailIfErr(err)
-			manifests.maxMInfo.Version = helpers.Max(args[0], args[1])
+			manifests.maxMInfo.Version = newer
 
 			// set u.Ver to be the min of the two args passed
-			u.Ver = helpers.Min(args[0], args[1])
+			u.Ver = older
 		}
 
 		manifests.minMInfo, err = pkginfo.NewManifestInfo(conf, &u)
@@ -120,11 +125,15 @@ func runBloatCheck(r *diva.Results, manifests *manifestsInfo, args []string) err
 	if err != nil {
 		return err
 	}
+	// the manifests share the provenance field with the bundles, record it
+	// before it is replaced
+	r.AddSource(manifests.minMInfo.BundleInfo.Dataset(), manifests.minMInfo.Provenance)
 
 	err = pkginfo.PopulateManifests(&manifests.minMInfo)
 	if err != nil {
 		return err
 	}
+	r.AddSource(manifests.minMInfo.Dataset(), manifests.minMInfo.Provenance)
 	helpers.PrintComplete("Finished populating data from database")
 
 	fromBundleSizes, err := bloatcheck.GetBundleSize(manifests.minMInfo)
@@ -147,11 +156,15 @@ func runBloatCheck(r *diva.Results, manifests *manifestsInfo, args []string) err
 	if err != nil {
 		return err
 	}
+	// the manifests share the provenance field with the bundles, record it
+	// before it is replaced
+	r.AddSource(manifests.maxMInfo.BundleInfo.Dataset(), manifests.maxMInfo.Provenance)
 
 	err = pkginfo.PopulateManifests(&manifests.maxMInfo)
 	if err != nil {
 		return err
 	}
+	r.AddSource(manifests.maxMInfo.Dataset(), manifests.maxMInfo.Provenance)
 	helpers.PrintComplete("Finished populating data from database")
 
 	toBundleSizes, err := bloatcheck.GetBundleSize(manifests.maxMInfo)
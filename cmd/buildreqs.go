@@ -0,0 +1,110 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/diva/pkginfo"
+	"github.com/spf13/cobra"
+)
+
+type buildreqsCmdFlags struct {
+	mixName string
+	version string
+	latest  bool
+}
+
+var buildreqsFlags buildreqsCmdFlags
+
+func init() {
+	buildreqsCmd.Flags().StringVarP(&buildreqsFlags.mixName, "name", "n", "clear", "name of data group")
+	buildreqsCmd.Flags().StringVarP(&buildreqsFlags.version, "version", "v", "0", "version to check")
+	buildreqsCmd.Flags().BoolVar(&buildreqsFlags.latest, "latest", false, "get the latest version from upstreamURL")
+}
+
+var buildreqsCmd = &cobra.Command{
+	Use:   "buildreqs",
+	Short: "Check that the build requirements of all source RPMs are available",
+	Long: `Check that every build requirement of every source RPM in the SRPM repo is
+satisfied by a provide or a file of some RPM in the binary repo of the same
+version. Build requirements are read from the headers of the source RPMs in
+the RPM cache. If no source RPMs are cached they are read from the database,
+where SRPM repos imported by older versions of diva lack them.`,
+	Run: runCheckBuildreqs,
+}
+
+func runCheckBuildreqs(cmd *cobra.Command, args []string) {
+	u := config.UInfo{
+		MixName: buildreqsFlags.mixName,
+		Ver:     buildreqsFlags.version,
+		Latest:  buildreqsFlags.latest,
+		RPMType: "SRPM",
+		Arch:    checkArch,
+	}
+
+	srpms, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	// the binary repo must be the same version, even if it was the latest
+	u.RPMType = "B"
+	u.Ver = srpms.Version
+	u.Latest = false
+	rpms, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Reading SRPM headers from %s", srpms.RPMCache)
+	err = pkginfo.PopulateRepoFromCache(&srpms)
+	if os.IsNotExist(err) {
+		helpers.PrintComplete("WARNING: no SRPMs cached under %s, populating SRPM repo from the database instead", srpms.RPMCache)
+		err = pkginfo.PopulateRepo(&srpms)
+	}
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Populating binary repo")
+	err = pkginfo.PopulateRepo(&rpms)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("Repos populated successfully")
+
+	r := diva.NewSuite("buildreqs", "check build requirements are available")
+	r.AddSource(srpms.Dataset(), srpms.Provenance)
+	r.AddSource(rpms.Dataset(), rpms.Provenance)
+
+	checkBuildreqs(r, &srpms, &rpms)
+
+	if r.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkBuildreqs checks that the build requirements of the source RPMs in
+// srpms are satisfied by the RPMs in rpms
+func checkBuildreqs(r *diva.Results, srpms, rpms *pkginfo.Repo) {
+	unresolved := pkginfo.NewResolver(rpms.Packages).Unresolved(srpms.Packages, pkginfo.BuildRequirements)
+
+	r.Ok(len(unresolved) == 0, "all build requirements satisfied")
+	if len(unresolved) > 0 {
+		failures := make([]string, 0, len(unresolved))
+		for _, u := range unresolved {
+			failures = append(failures, fmt.Sprintf("%s build requires %s", u.RPM.Name, u.Dependency))
+		}
+		r.Diagnostic(fmt.Sprintf("Unsatisfied build requirements: %d\n%s", len(unresolved), strings.Join(failures, "\n")))
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/pkginfo"
+)
+
+func TestCheckBuildreqs(t *testing.T) {
+	rpms := &pkginfo.Repo{Packages: []*pkginfo.RPM{
+		{
+			Name: "gcc", Version: "9.2.1", Release: "1", Architecture: "x86_64",
+			Provides: []pkginfo.Dependency{{Name: "gcc", Flags: pkginfo.DepFlagEqual, EVR: "9.2.1-1"}},
+			Files:    []*pkginfo.File{{Name: "/usr/bin/gcc"}},
+		},
+		{
+			Name: "python3-dev", Version: "3.7.4", Release: "1", Architecture: "x86_64",
+			Provides: []pkginfo.Dependency{{Name: "pkgconfig(python3)", Flags: pkginfo.DepFlagEqual, EVR: "3.7"}},
+		},
+	}}
+
+	tests := []struct {
+		name     string
+		requires []pkginfo.Dependency
+		failures []string
+	}{
+		{"provided", []pkginfo.Dependency{{Name: "gcc"}}, nil},
+		{"file provided", []pkginfo.Dependency{{Name: "/usr/bin/gcc"}}, nil},
+		{"versioned", []pkginfo.Dependency{
+			{Name: "gcc", Flags: pkginfo.DepFlagGreater | pkginfo.DepFlagEqual, EVR: "9"},
+			{Name: "pkgconfig(python3)", Flags: pkginfo.DepFlagGreater | pkginfo.DepFlagEqual, EVR: "3.7"},
+		}, nil},
+		{"unsatisfied", []pkginfo.Dependency{{Name: "clang"}, {Name: "/usr/bin/clang"}},
+			[]string{"pkg build requires clang", "pkg build requires /usr/bin/clang"}},
+		{"version unsatisfied", []pkginfo.Dependency{{Name: "gcc", Flags: pkginfo.DepFlagGreater, EVR: "10"}},
+			[]string{"pkg build requires gcc > 10"}},
+	}
+
+	for _, tc := range tests {
+		srpms := &pkginfo.Repo{Packages: []*pkginfo.RPM{
+			{Name: "pkg", Version: "1", Release: "1", Architecture: "src", BuildRequires: tc.requires},
+		}}
+		out := &bytes.Buffer{}
+		r := diva.NewSuite("buildreqs", "test")
+		r.Writer = out
+
+		checkBuildreqs(r, srpms, rpms)
+
+		if failed := len(tc.failures) > 0; (r.Failed == 1) != failed {
+			t.Errorf("%s: expected failure %v but got %d failed", tc.name, failed, r.Failed)
+		}
+		for _, f := range tc.failures {
+			if !strings.Contains(out.String(), f) {
+				t.Errorf("%s: expected %q to be reported in\n%s", tc.name, f, out.String())
+			}
+		}
+	}
+}
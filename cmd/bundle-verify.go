@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/clearlinux/diva/diva"
@@ -33,6 +34,7 @@ type bundleCmdFlags struct {
 	version string
 	latest  bool
 	bundle  string
+	deps    bool
 }
 
 // flags passed in as args
@@ -43,6 +45,7 @@ func init() {
 	verifyBundlesCmd.Flags().StringVarP(&bundleFlags.version, "version", "v", "0", "version to check")
 	verifyBundlesCmd.Flags().BoolVar(&bundleFlags.latest, "latest", false, "get the latest version from upstreamURL")
 	verifyBundlesCmd.Flags().StringVarP(&bundleFlags.bundle, "bundle", "b", "", "bundle to check")
+	verifyBundlesCmd.Flags().BoolVar(&bundleFlags.deps, "deps", false, "check that bundles satisfy the runtime requirements of their packages")
 }
 
 var verifyBundlesCmd = &cobra.Command{
@@ -53,7 +56,12 @@ bundle and package bundle files can be found in the configured repo. It also
 ensures no include loops exist, and that the bundle filename matches the bundle
 definition header TITLE. For a <bundle> or the default of all bundles. An
 optional <name> and <version> may be used to specify a repo the bundle
-packages completeness will run against with "clear" and "0" as the defaults.`,
+packages completeness will run against with "clear" and "0" as the defaults.
+
+With --deps, it also checks that the runtime requirements of the packages
+each bundle directly ships are satisfied by the packages of the bundle and its
+includes. For each unsatisfied requirement, the bundles shipping a package
+that satisfies it are listed, one of which should be included.`,
 	Run: runVerifyBundle,
 }
 
@@ -62,6 +70,7 @@ func runVerifyBundle(cmd *cobra.Command, args []string) {
 		MixName: bundleFlags.mixName,
 		Ver:     bundleFlags.version,
 		Latest:  bundleFlags.latest,
+		Arch:    checkArch,
 	}
 
 	repo, err := pkginfo.NewRepo(conf, &u)
@@ -76,9 +85,11 @@ func runVerifyBundle(cmd *cobra.Command, args []string) {
 	helpers.FailIfErr(err)
 
 	result := diva.NewSuite("bundle-verify", "validate bundle correctness")
+	result.AddSource(repo.Dataset(), repo.Provenance)
 
 	err = pkginfo.PopulateBundles(&bundleInfo, bundleFlags.bundle)
 	helpers.FailIfErr(err)
+	result.AddSource(bundleInfo.Dataset(), bundleInfo.Provenance)
 
 	checkIncludeLoops(result, &bundleInfo)
 	checkBundleDefinitionsComplete(result, &bundleInfo)
@@ -86,6 +97,21 @@ func runVerifyBundle(cmd *cobra.Command, args []string) {
 	checkBundleHeaderTitleMatchesFile(result, &bundleInfo)
 	checkBundleRPMs(result, &bundleInfo, &repo)
 
+	if bundleFlags.deps {
+		// bundles satisfying missing requirements are looked up among all
+		// bundles, not only the one being checked
+		allBundles := &bundleInfo
+		if bundleFlags.bundle != "" {
+			var all pkginfo.BundleInfo
+			all, err = pkginfo.NewBundleInfo(conf, &u)
+			helpers.FailIfErr(err)
+			err = pkginfo.PopulateBundles(&all, "")
+			helpers.FailIfErr(err)
+			allBundles = &all
+		}
+		checkBundleDeps(result, &bundleInfo, allBundles, &repo)
+	}
+
 	err = checkIfPundleDeletesExist(result, bundleInfo.Tag)
 	helpers.FailIfErr(err)
 
@@ -203,6 +229,62 @@ func checkBundleRPMs(result *diva.Results, bundleInfo *pkginfo.BundleInfo, repo
 	}
 }
 
+// checkBundleDeps checks that the runtime requirements of the packages each
+// bundle directly ships are satisfied within the bundle and its includes.
+// Each unsatisfied requirement is listed once for the bundle shipping the
+// package, not for the bundles including it or every version of the package,
+// with the bundles in allBundles that directly ship a package satisfying it.
+func checkBundleDeps(result *diva.Results, bundleInfo, allBundles *pkginfo.BundleInfo, repo *pkginfo.Repo) {
+	resolver := pkginfo.NewResolver(repo.Packages)
+
+	shippedBy := make(map[string][]string)
+	for _, bundle := range allBundles.BundleDefinitions {
+		for pkg := range bundle.DirectPackages {
+			shippedBy[pkg] = append(shippedBy[pkg], bundle.Name)
+		}
+	}
+
+	var failures []string
+	reported := make(map[string]bool)
+	for _, bundle := range bundleInfo.BundleDefinitions {
+		for _, rpm := range filterRPMs(repo.Packages, bundle.DirectPackages) {
+			for _, d := range rpm.Requires {
+				failure := fmt.Sprintf("%s: %s requires %s", bundle.Name, rpm.Name, d)
+				if reported[failure] || resolver.SatisfiedWithin(d, bundle.AllPackages) {
+					continue
+				}
+				reported[failure] = true
+
+				if candidates := satisfyingBundles(resolver.WhatProvides(d), shippedBy); len(candidates) > 0 {
+					failure += ", provided by bundles " + strings.Join(candidates, ", ")
+				} else {
+					failure += ", not provided by any bundle"
+				}
+				failures = append(failures, failure)
+			}
+		}
+	}
+
+	sort.Strings(failures)
+	result.Ok(len(failures) == 0, "all bundle runtime requirements satisfied")
+	if len(failures) > 0 {
+		result.Diagnostic("unsatisfied runtime requirements:\n" + strings.Join(failures, "\n"))
+	}
+}
+
+// satisfyingBundles returns the sorted names of the bundles shipping one of
+// the providers
+func satisfyingBundles(providers []*pkginfo.RPM, shippedBy map[string][]string) []string {
+	set := make(map[string]bool)
+	for _, rpm := range providers {
+		for _, b := range shippedBy[rpm.Name] {
+			set[b] = true
+		}
+	}
+	names, _ := helpers.HashmapToSortedSlice(set)
+	return names
+}
+
 // checkIfPundleDeletesExist determines whether a package bundle was removed
 // since the latest bundle tag.
 func checkIfPundleDeletesExist(result *diva.Results, tag string) error {
@@ -0,0 +1,76 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/pkginfo"
+)
+
+func TestCheckBundleDeps(t *testing.T) {
+	repo := &pkginfo.Repo{Packages: []*pkginfo.RPM{
+		{Name: "vim", Version: "8.1", Release: "1", Architecture: "x86_64",
+			Requires: []pkginfo.Dependency{{Name: "libncurses.so.6"}}},
+		{Name: "vim", Version: "8.1", Release: "1", Architecture: "i686",
+			Requires: []pkginfo.Dependency{{Name: "libncurses.so.6"}}},
+		{Name: "ncurses", Version: "6.1", Release: "1", Architecture: "x86_64",
+			Provides: []pkginfo.Dependency{{Name: "libncurses.so.6"}}},
+		{Name: "bash", Version: "5.0", Release: "1", Architecture: "x86_64",
+			Requires: []pkginfo.Dependency{{Name: "libncurses.so.6"}}},
+	}}
+	bundles := bundle.DefinitionsSet{
+		"editors": {
+			Name:           "editors",
+			DirectPackages: map[string]bool{"vim": true},
+			AllPackages:    map[string]bool{"vim": true},
+		},
+		"desktop": {
+			Name:           "desktop",
+			Includes:       map[string]bool{"editors": true},
+			DirectPackages: map[string]bool{},
+			AllPackages:    map[string]bool{"vim": true},
+		},
+		"shells": {
+			Name:           "shells",
+			Includes:       map[string]bool{"lib-ncurses": true},
+			DirectPackages: map[string]bool{"bash": true},
+			AllPackages:    map[string]bool{"bash": true, "ncurses": true},
+		},
+		"lib-ncurses": {
+			Name:           "lib-ncurses",
+			DirectPackages: map[string]bool{"ncurses": true},
+			AllPackages:    map[string]bool{"ncurses": true},
+		},
+	}
+	info := &pkginfo.BundleInfo{BundleDefinitions: bundles}
+
+	out := &bytes.Buffer{}
+	r := diva.NewSuite("bundles", "test")
+	r.Writer = out
+	checkBundleDeps(r, info, info, repo)
+
+	if r.Failed != 1 {
+		t.Errorf("expected 1 failure, got %d\n%s", r.Failed, out.String())
+	}
+	failure := "editors: vim requires libncurses.so.6, provided by bundles lib-ncurses"
+	if n := strings.Count(out.String(), "requires"); n != 1 || !strings.Contains(out.String(), failure) {
+		t.Errorf("expected only %q in\n%s", failure, out.String())
+	}
+}
@@ -16,20 +16,30 @@ package cmd
 
 import "github.com/spf13/cobra"
 
+// checkArch is the architecture of the repos the checks run against
+var checkArch string
+
 var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Run various content and metadata checks",
-	Long:  `Run various checks against distribution content or metadata`,
+	Long: `Run various checks against distribution content or metadata. Checks of RPM
+repos run against the x86_64 repos unless --arch is passed.`,
 }
 
 func init() {
 	rootCmd.AddCommand(checkCmd)
+	checkCmd.PersistentFlags().StringVar(&checkArch, "arch", "x86_64", "architecture of the repos to check")
+	checkCmd.AddCommand(archesCmd)
 	checkCmd.AddCommand(bloatCheckCmd)
+	checkCmd.AddCommand(buildreqsCmd)
 	checkCmd.AddCommand(chirpCmd)
+	checkCmd.AddCommand(closureCmd)
 	checkCmd.AddCommand(debuginfoCmd)
+	checkCmd.AddCommand(orphansCmd)
 	checkCmd.AddCommand(pyDepsCmd)
 	checkCmd.AddCommand(ucCmd)
 	checkCmd.AddCommand(verifyBundlesCmd)
 	checkCmd.AddCommand(rpmFileConflictsCmd)
 	checkCmd.AddCommand(sigCmd)
+	checkCmd.AddCommand(srpmsCmd)
 }
@@ -0,0 +1,125 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/diva/pkginfo"
+	"github.com/spf13/cobra"
+)
+
+type closureCmdFlags struct {
+	mixName string
+	version string
+	latest  bool
+	bundle  string
+}
+
+var closureFlags closureCmdFlags
+
+func init() {
+	closureCmd.Flags().StringVarP(&closureFlags.mixName, "name", "n", "clear", "name of data group")
+	closureCmd.Flags().StringVarP(&closureFlags.version, "version", "v", "0", "version to check")
+	closureCmd.Flags().BoolVar(&closureFlags.latest, "latest", false, "get the latest version from upstreamURL")
+	closureCmd.Flags().StringVarP(&closureFlags.bundle, "bundle", "b", "", "only resolve within the packages of bundle")
+}
+
+var closureCmd = &cobra.Command{
+	Use:   "closure",
+	Short: "Check that the runtime requirements of all RPMs are satisfied",
+	Long: `Check that every runtime requirement of every RPM in the binary repo is
+satisfied by a provide or a file of some RPM in the repo, comparing versions
+the way rpm does. With --bundle, only the packages the bundle installs,
+including those of its includes, are checked, and requirements must be
+satisfied by those packages alone. The rpmlib() features provided by rpm
+itself and rich (boolean) dependencies are not checked.`,
+	Run: runCheckClosure,
+}
+
+func runCheckClosure(cmd *cobra.Command, args []string) {
+	u := config.UInfo{
+		MixName: closureFlags.mixName,
+		Ver:     closureFlags.version,
+		Latest:  closureFlags.latest,
+		Arch:    checkArch,
+	}
+
+	repo, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Populating repo")
+	err = pkginfo.PopulateRepo(&repo)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("Repo populated successfully")
+
+	r := diva.NewSuite("closure", "check runtime requirements are satisfied")
+	r.AddSource(repo.Dataset(), repo.Provenance)
+
+	rpms := repo.Packages
+	if closureFlags.bundle != "" {
+		var bundleInfo pkginfo.BundleInfo
+		bundleInfo, err = pkginfo.NewBundleInfo(conf, &u)
+		helpers.FailIfErr(err)
+
+		helpers.PrintBegin("Populating bundle %s", closureFlags.bundle)
+		err = pkginfo.PopulateBundles(&bundleInfo, closureFlags.bundle)
+		helpers.FailIfErr(err)
+		helpers.PrintComplete("Bundle populated successfully")
+		r.AddSource(bundleInfo.Dataset(), bundleInfo.Provenance)
+
+		var pkgs map[string]bool
+		pkgs, err = bundleInfo.BundleDefinitions.GetAllPackages(closureFlags.bundle)
+		helpers.FailIfErr(err)
+		rpms = filterRPMs(repo.Packages, pkgs)
+	}
+
+	checkClosure(r, rpms)
+
+	if r.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// filterRPMs returns the rpms named in names
+func filterRPMs(rpms []*pkginfo.RPM, names map[string]bool) []*pkginfo.RPM {
+	filtered := []*pkginfo.RPM{}
+	for _, rpm := range rpms {
+		if names[rpm.Name] {
+			filtered = append(filtered, rpm)
+		}
+	}
+	return filtered
+}
+
+// checkClosure checks that the runtime requirements of rpms are satisfied by
+// rpms, and lists each unsatisfied capability with the package requiring it
+func checkClosure(r *diva.Results, rpms []*pkginfo.RPM) {
+	unresolved := pkginfo.NewResolver(rpms).Unresolved(rpms, pkginfo.RuntimeRequires)
+
+	r.Ok(len(unresolved) == 0, "all runtime requirements satisfied")
+	if len(unresolved) > 0 {
+		failures := make([]string, 0, len(unresolved))
+		for _, u := range unresolved {
+			failures = append(failures, u.String())
+		}
+		r.Diagnostic(fmt.Sprintf("Unsatisfied runtime requirements: %d\n%s", len(unresolved), strings.Join(failures, "\n")))
+	}
+}
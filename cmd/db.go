@@ -0,0 +1,398 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/clearlinux/diva/download"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/diva/pkginfo"
+	"github.com/spf13/cobra"
+)
+
+type dbCmdFlags struct {
+	mixName     string
+	version     string
+	datasetType string
+	arch        string
+	verbose     bool
+	output      string
+}
+
+// flags passed in as args
+var dbFlags dbCmdFlags
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the database of imported data",
+}
+
+var dbStopCmd = &cobra.Command{
+	Use:   "stop",
+	Run:   runDBStopCmd,
+	Short: "Stop the private redis-server started by diva",
+	Long: `Stop the private redis-server that diva starts on a unix socket under the
+cache location when no configured redis-server is reachable. The server saves
+its data before exiting, and is started again by the next command that needs
+the database.`,
+}
+
+var dbListCmd = &cobra.Command{
+	Use:   "list",
+	Run:   runDBListCmd,
+	Short: "List the imported datasets",
+	Long: `List the completely imported repos, bundle definitions, and manifests of each
+data group with the number of packages, bundles, or manifests they contain, the
+time they were imported, and where they were imported from. Pass --verbose to
+show the full provenance recorded by each import.`,
+}
+
+var dbRmCmd = &cobra.Command{
+	Use:   "rm --name <name> --version <version> --type <type> [--arch <arch>]",
+	Run:   runDBRmCmd,
+	Short: "Remove an imported dataset",
+	Long: `Remove the dataset of data group <name> at <version> and everything stored
+under it. The <type> is the repo type, one of B, SRPM, or debug, or bundles or
+manifests. Pass <arch> to remove a binary or debuginfo repo of an architecture
+other than x86_64. Manifests that are also listed by the manifests of another
+version are kept.`,
+}
+
+var dbVerifyCmd = &cobra.Command{
+	Use:   "verify [--name <name>] [--version <version>] [--type <type>] [--arch <arch>]",
+	Run:   runDBVerifyCmd,
+	Short: "Compare the imported datasets against the cache",
+	Long: `Re-read the cached data of each imported dataset, the RPM cache of repos, the
+update directory of manifests, and the bundle repository at the dataset
+version for bundle definitions, and report every difference from the data
+stored in the database. Pass <name>, <version>, <type>, or <arch> to only
+verify the matching datasets. Exits with a non-zero status if any drift is found.`,
+}
+
+var dbExportCmd = &cobra.Command{
+	Use:   "export --name <name> --version <version> [--type <type>] [--arch <arch>] [--output <file>]",
+	Run:   runDBExportCmd,
+	Short: "Export imported datasets to a snapshot file",
+	Long: `Write the repos, bundle definitions, and manifests of data group <name> at
+<version>, along with their provenance, to a single compressed snapshot file
+that "diva db import-snapshot" loads into any database backend. Pass <type> to
+only export one kind of dataset, and <arch> to only export the repos of one
+architecture. The snapshot is written to
+diva-<name>-<version>.snapshot unless --output is passed.`,
+}
+
+var dbImportSnapshotCmd = &cobra.Command{
+	Use:   "import-snapshot <file>",
+	Run:   runDBImportSnapshotCmd,
+	Args:  cobra.ExactArgs(1),
+	Short: "Load the datasets of a snapshot file into the database",
+	Long: `Load every dataset of a snapshot written by "diva db export" into the
+configured database, replacing datasets of the same name, version, type, and
+architecture.`,
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Run:   runDBMigrateCmd,
+	Short: "Rewrite the database into the current key layout",
+	Long: `Rewrite all imported data into the key layout used by this version of diva.
+Commands refuse to use a database with an older layout until it is migrated.
+
+The legacy redis layout built keys by concatenating the mix name and version,
+so a key such as clear10B may belong to mix clear1 version 0 or mix clear
+version 10. The version of manifests is read from their MoM, and the name and
+version of other datasets are taken from the manifests and the cached RPM
+directories. Datasets that still cannot be told apart are skipped and keep
+their keys, fetch them again after migrating. So must manifests with deleted
+files, which the legacy layout did not store correctly. Only the keys of
+migrated datasets are removed, so other applications may share the database.
+
+The legacy layout stored only the names of package requirements and
+provides, so migrated packages have unversioned dependencies. It also kept
+only one package of each name, and no architecture, so legacy repos are
+migrated to x86_64 repos, and SRPM repos to src repos. Fetch repos again to
+import every version and architecture of their packages, and to record the
+flags and versions of their dependencies.`,
+}
+
+var dbCmds = []*cobra.Command{
+	dbExportCmd,
+	dbImportSnapshotCmd,
+	dbListCmd,
+	dbMigrateCmd,
+	dbRmCmd,
+	dbStopCmd,
+	dbVerifyCmd,
+}
+
+func init() {
+	for _, cmd := range dbCmds {
+		dbCmd.AddCommand(cmd)
+	}
+
+	rootCmd.AddCommand(dbCmd)
+
+	dbListCmd.Flags().BoolVar(&dbFlags.verbose, "verbose", false, "show the full provenance of each dataset")
+
+	dbExportCmd.Flags().StringVarP(&dbFlags.output, "output", "o", "", "path of the snapshot file")
+
+	for _, cmd := range []*cobra.Command{dbExportCmd, dbRmCmd, dbVerifyCmd} {
+		cmd.Flags().StringVarP(&dbFlags.mixName, "name", "n", "", "name of data group")
+		cmd.Flags().StringVarP(&dbFlags.version, "version", "v", "", "version of the dataset")
+		cmd.Flags().StringVarP(&dbFlags.datasetType, "type", "t", "", "B, SRPM, debug, bundles, or manifests")
+		cmd.Flags().StringVar(&dbFlags.arch, "arch", "", "architecture of the repo")
+	}
+}
+
+// datasetFromFlags returns the dataset selected by the --name, --version,
+// --type, and --arch flags
+func datasetFromFlags() pkginfo.Dataset {
+	ds := pkginfo.Dataset{Name: dbFlags.mixName, Version: dbFlags.version}
+	switch dbFlags.datasetType {
+	case pkginfo.DatasetBundles, pkginfo.DatasetManifests:
+		ds.Kind = dbFlags.datasetType
+	case "":
+	default:
+		ds.Kind = pkginfo.DatasetRepo
+		ds.Type = dbFlags.datasetType
+	}
+	ds.Arch = dbFlags.arch
+	return ds
+}
+
+// matches reports whether ds has the name, version, type, and arch set in
+// filter. Only binary and debuginfo repos are built for an arch, all other
+// datasets match any arch.
+func matches(filter, ds pkginfo.Dataset) bool {
+	return (filter.Name == "" || filter.Name == ds.Name) &&
+		(filter.Version == "" || filter.Version == ds.Version) &&
+		(filter.Kind == "" || filter.Kind == ds.Kind) &&
+		(filter.Type == "" || filter.Type == ds.Type) &&
+		(filter.Arch == "" || ds.Kind != pkginfo.DatasetRepo || ds.Type == "SRPM" || filter.Arch == ds.Arch)
+}
+
+func openDB() pkginfo.Store {
+	s, err := pkginfo.NewStore(&conf.Database, conf.Paths.CacheLocation)
+	helpers.FailIfErr(err)
+	return s
+}
+
+func runDBListCmd(cmd *cobra.Command, args []string) {
+	s := openDB()
+	defer func() {
+		_ = s.Close()
+	}()
+
+	datasets, err := s.ListDatasets()
+	helpers.FailIfErr(err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	group := ""
+	for _, ds := range datasets {
+		if ds.Name != group {
+			group = ds.Name
+			_, _ = fmt.Fprintf(w, "%s\n\tKIND\tVERSION\tTYPE\tARCH\tCOUNT\tIMPORTED\tSOURCE\n", group)
+		}
+		_, _ = fmt.Fprintf(w, "\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			ds.Kind, ds.Version, ds.Type, ds.Arch, ds.Count, ds.Imported.Format("2006-01-02 15:04:05"),
+			ds.Provenance.SourceURI)
+		if dbFlags.verbose {
+			_, _ = fmt.Fprintf(w, "\t\t%s\n", ds.Provenance)
+		}
+	}
+	_ = w.Flush()
+}
+
+func runDBRmCmd(cmd *cobra.Command, args []string) {
+	ds := datasetFromFlags()
+	if ds.Name == "" || ds.Version == "" || ds.Kind == "" {
+		helpers.FailIfErr(fmt.Errorf("--name, --version, and --type are required"))
+	}
+
+	s := openDB()
+	defer func() {
+		_ = s.Close()
+	}()
+
+	helpers.PrintBegin("removing %s", ds)
+	err := s.RemoveDataset(ds)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("%s removed", ds)
+}
+
+// verifyDataset compares ds against the cache it was imported from
+func verifyDataset(ds pkginfo.Dataset) ([]string, error) {
+	u := config.UInfo{
+		MixName: ds.Name,
+		Ver:     ds.Version,
+		RPMType: ds.Type,
+		Arch:    ds.Arch,
+	}
+
+	switch ds.Kind {
+	case pkginfo.DatasetRepo:
+		repo, err := pkginfo.NewRepo(conf, &u)
+		if err != nil {
+			return nil, err
+		}
+		return pkginfo.VerifyRepo(&repo)
+
+	case pkginfo.DatasetBundles:
+		bundleInfo, err := pkginfo.NewBundleInfo(conf, &u)
+		if err != nil {
+			return nil, err
+		}
+
+		// check out the imported version and return to the current branch
+		bundleInfo.Branch, err = download.GetCurrentBranch(bundleInfo.BundleCache)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = helpers.CheckoutBranch(bundleInfo.BundleCache, bundleInfo.Branch)
+		}()
+		if err = helpers.CheckoutRepoTag(bundleInfo.BundleCache, bundleInfo.Tag); err != nil {
+			return nil, err
+		}
+		return pkginfo.VerifyBundles(&bundleInfo)
+
+	default:
+		mInfo, err := pkginfo.NewManifestInfo(conf, &u)
+		if err != nil {
+			return nil, err
+		}
+		return pkginfo.VerifyManifests(&mInfo)
+	}
+}
+
+func runDBVerifyCmd(cmd *cobra.Command, args []string) {
+	filter := datasetFromFlags()
+
+	s := openDB()
+	datasets, err := s.ListDatasets()
+	_ = s.Close()
+	helpers.FailIfErr(err)
+
+	drifted := 0
+	for _, ds := range datasets {
+		if !matches(filter, ds.Dataset) {
+			continue
+		}
+
+		helpers.PrintBegin("verifying %s", ds.Dataset)
+		drift, err := verifyDataset(ds.Dataset)
+		if err != nil {
+			drift = []string{fmt.Sprintf("unable to verify: %v", err)}
+		}
+		for _, d := range drift {
+			helpers.PrintComplete("%s", d)
+		}
+		if len(drift) > 0 {
+			drifted++
+			continue
+		}
+		helpers.PrintComplete("no drift found")
+	}
+
+	if drifted > 0 {
+		helpers.PrintBegin("%d datasets differ from the cache", drifted)
+		os.Exit(1)
+	}
+}
+
+func runDBStopCmd(cmd *cobra.Command, args []string) {
+	helpers.PrintBegin("stopping redis-server at %s", pkginfo.PrivateRedisSocket(conf.Paths.CacheLocation))
+	err := pkginfo.StopPrivateRedis(conf.Paths.CacheLocation)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("redis-server stopped")
+}
+
+func runDBMigrateCmd(cmd *cobra.Command, args []string) {
+	helpers.PrintBegin("migrating %s database", conf.Database.Backend)
+	from, to, err := pkginfo.MigrateStore(&conf.Database, conf.Paths.CacheLocation)
+	helpers.FailIfErr(err)
+	if from == to {
+		helpers.PrintComplete("database already uses layout %d", to)
+		return
+	}
+	helpers.PrintComplete("database migrated from layout %d to %d", from, to)
+}
+
+func runDBExportCmd(cmd *cobra.Command, args []string) {
+	filter := datasetFromFlags()
+	if filter.Name == "" || filter.Version == "" {
+		helpers.FailIfErr(fmt.Errorf("--name and --version are required"))
+	}
+
+	s := openDB()
+	defer func() {
+		_ = s.Close()
+	}()
+
+	all, err := s.ListDatasets()
+	helpers.FailIfErr(err)
+
+	datasets := []pkginfo.Dataset{}
+	for _, ds := range all {
+		if matches(filter, ds.Dataset) {
+			datasets = append(datasets, ds.Dataset)
+		}
+	}
+	if len(datasets) == 0 {
+		helpers.FailIfErr(fmt.Errorf("no imported datasets of %s %s found", filter.Name, filter.Version))
+	}
+
+	output := dbFlags.output
+	if output == "" {
+		output = fmt.Sprintf("diva-%s-%s.snapshot", filter.Name, filter.Version)
+	}
+
+	helpers.PrintBegin("exporting %d datasets to %s", len(datasets), output)
+	f, err := os.Create(output)
+	helpers.FailIfErr(err)
+	err = pkginfo.ExportSnapshot(s, datasets, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(output)
+	}
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("snapshot written to %s", output)
+}
+
+func runDBImportSnapshotCmd(cmd *cobra.Command, args []string) {
+	f, err := os.Open(args[0])
+	helpers.FailIfErr(err)
+	defer func() {
+		_ = f.Close()
+	}()
+
+	s := openDB()
+	defer func() {
+		_ = s.Close()
+	}()
+
+	helpers.PrintBegin("loading snapshot %s", args[0])
+	datasets, err := pkginfo.ImportSnapshot(s, f)
+	for _, ds := range datasets {
+		helpers.PrintComplete("%s loaded", ds)
+	}
+	helpers.FailIfErr(err)
+}
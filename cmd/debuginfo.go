@@ -47,6 +47,7 @@ func runCheckDebuginfo(cmd *cobra.Command, args []string) {
 		Ver:     debuginfoFlags.version,
 		Latest:  debuginfoFlags.latest,
 		RPMType: "debug",
+		Arch:    checkArch,
 	}
 
 	repo, err := pkginfo.NewRepo(conf, &u)
@@ -56,6 +57,7 @@ func runCheckDebuginfo(cmd *cobra.Command, args []string) {
 	err = pkginfo.PopulateRepo(&repo)
 	helpers.FailIfErr(err)
 	helpers.PrintComplete("Repo content populated successfully")
+	r.AddSource(repo.Dataset(), repo.Provenance)
 
 	validateDebuginfo(r, &repo)
 }
@@ -56,7 +56,14 @@ var downloadRepoCmd = &cobra.Command{
 supplied, otherwise download the latest available. If --upstreamurl is supplied,
 download from <url> instead of the configured/default upstream URL. The repository
 is cached under the cache location defined in the configuration or default to
-$HOME/clearlinux/data/rpms/<version>.`,
+$HOME/clearlinux/data/rpms/<version>. Pass --arch to download the binary and
+debuginfo repos of an architecture other than x86_64. Pass --metadata to
+download only the primary.xml and filelists.xml of the repository instead of
+every RPM.
+
+Downloaded RPMs are checked against the checksums in the repo metadata and,
+if gpg_keyring is set in the configuration, signatures. RPMs that fail are
+downloaded again and reported if they keep failing.`,
 }
 
 var downloadBundlesCmd = &cobra.Command{
@@ -109,9 +116,11 @@ func init() {
 	downloadCmd.PersistentFlags().StringVarP(&downloadFlags.Version, "version", "v", "0", "version from which to pull data")
 	downloadCmd.PersistentFlags().StringVarP(&downloadFlags.UpstreamURL, "upstreamurl", "u", "", "URL from which to pull update metadata")
 	downloadCmd.PersistentFlags().BoolVar(&downloadFlags.Latest, "latest", false, "get the latest upstream version")
+	downloadCmd.PersistentFlags().StringVar(&downloadFlags.Arch, "arch", "x86_64", "architecture of the binary and debuginfo RPM repos")
 
 	downloadAllCmd.Flags().StringVarP(&downloadFlags.UpstreamRepoURL, "repourl", "m", "", "fully qualified URL from which to pull repodata")
 	downloadAllCmd.Flags().StringVar(&downloadFlags.RPMCache, "rpmcache", "", "path to repo cache destination")
+	downloadAllCmd.Flags().BoolVar(&downloadFlags.Metadata, "metadata", false, "download only the repo metadata instead of every RPM")
 	downloadAllCmd.Flags().BoolVar(&downloadFlags.BinaryRPM, "binary", false, "fetches only binary RPMs")
 	downloadAllCmd.Flags().BoolVar(&downloadFlags.SourceRPM, "source", false, "fetches only SRPMs")
 	downloadAllCmd.Flags().StringVarP(&downloadFlags.BundleURL, "bundleurl", "b", "", "URL from which to pull bundle definitions")
@@ -121,6 +130,7 @@ func init() {
 
 	downloadRepoCmd.Flags().StringVarP(&downloadFlags.UpstreamRepoURL, "repourl", "m", "", "fully qualified URL from which to pull repodata")
 	downloadRepoCmd.Flags().StringVar(&downloadFlags.RPMCache, "rpmcache", "", "path to repo cache destination")
+	downloadRepoCmd.Flags().BoolVar(&downloadFlags.Metadata, "metadata", false, "download only the repo metadata instead of every RPM")
 	downloadRepoCmd.Flags().BoolVar(&downloadFlags.Update, "update", false, "update pre-existing Repo data")
 	downloadRepoCmd.Flags().BoolVar(&downloadFlags.BinaryRPM, "binary", false, "downloads binary RPMs")
 	downloadRepoCmd.Flags().BoolVar(&downloadFlags.SourceRPM, "source", false, "downloads SRPMs")
@@ -64,7 +64,16 @@ var fetchRepoCmd = &cobra.Command{
 supplied, otherwise fetch the latest available. If --upstreamurl is supplied, fetch
 from <url> instead of the configured/default upstream URL. The repository is
 cached under the cache location defined in your configuration or default to
-$HOME/clearlinux/data/rpms/<version>.`,
+$HOME/clearlinux/data/rpms/<version>. Pass --arch to fetch the binary and
+debuginfo repos of an architecture other than x86_64.
+
+Pass --metadata to fetch and import only the primary.xml and filelists.xml of
+the repository instead of every RPM. Checks that need the file attributes or
+the RPMs themselves download the RPMs they use when they run.
+
+Downloaded RPMs are checked against the checksums in the repo metadata and,
+if gpg_keyring is set in the configuration, signatures. RPMs that fail are
+downloaded again and reported if they keep failing.`,
 }
 
 var fetchUpdateCmd = &cobra.Command{
@@ -107,9 +116,11 @@ func init() {
 	fetchCmd.PersistentFlags().StringVarP(&fetchFlags.Version, "version", "v", "0", "version from which to pull data")
 	fetchCmd.PersistentFlags().StringVarP(&fetchFlags.UpstreamURL, "upstreamurl", "u", "", "URL from which to pull update metadata")
 	fetchCmd.PersistentFlags().BoolVar(&fetchFlags.Latest, "latest", false, "get the latest upstream version")
+	fetchCmd.PersistentFlags().StringVar(&fetchFlags.Arch, "arch", "x86_64", "architecture of the binary and debuginfo RPM repos")
 
 	fetchAllCmd.Flags().StringVarP(&fetchFlags.UpstreamRepoURL, "repourl", "m", "", "fully qualified URL from which to pull repodata")
 	fetchAllCmd.Flags().StringVar(&fetchFlags.RPMCache, "rpmcache", "", "path to repo cache destination")
+	fetchAllCmd.Flags().BoolVar(&fetchFlags.Metadata, "metadata", false, "fetch only the repo metadata instead of every RPM")
 	fetchAllCmd.Flags().BoolVar(&fetchFlags.BinaryRPM, "binary", false, "fetches only binary RPMs")
 	fetchAllCmd.Flags().BoolVar(&fetchFlags.SourceRPM, "source", false, "fetches only SRPMs")
 	fetchAllCmd.Flags().StringVarP(&fetchFlags.BundleURL, "bundleurl", "b", "", "URL from which to pull bundle definitions")
@@ -119,6 +130,7 @@ func init() {
 
 	fetchRepoCmd.Flags().StringVarP(&fetchFlags.UpstreamRepoURL, "repourl", "m", "", "fully qualified URL from which to pull repodata")
 	fetchRepoCmd.Flags().StringVar(&fetchFlags.RPMCache, "rpmcache", "", "path to repo cache destination")
+	fetchRepoCmd.Flags().BoolVar(&fetchFlags.Metadata, "metadata", false, "fetch only the repo metadata instead of every RPM")
 	fetchRepoCmd.Flags().BoolVar(&fetchFlags.Update, "update", false, "update data with upstream")
 	fetchRepoCmd.Flags().BoolVar(&fetchFlags.BinaryRPM, "binary", false, "fetches binary RPMs")
 	fetchRepoCmd.Flags().BoolVar(&fetchFlags.SourceRPM, "source", false, "fetches SRPMs")
@@ -58,11 +58,13 @@ func runCheckFileConflicts(cmd *cobra.Command, args []string) {
 		MixName: conflictFlags.mixName,
 		Ver:     conflictFlags.version,
 		Latest:  conflictFlags.latest,
+		Arch:    checkArch,
 	}
 
 	repo, err := pkginfo.NewRepo(conf, &u)
 	helpers.FailIfErr(err)
 
+	var sources []diva.Source
 	if conflictFlags.mash {
 		helpers.PrintBegin("Populating repo package content")
 		err = pkginfo.PopulateRepo(&repo)
@@ -74,11 +76,20 @@ func runCheckFileConflicts(cmd *cobra.Command, args []string) {
 		helpers.FailIfErr(err)
 		err = pkginfo.PopulateRepoFromBundles(&bundleInfo, &repo)
 		helpers.FailIfErr(err)
+		sources = append(sources, diva.Source{Dataset: bundleInfo.Dataset(), Provenance: bundleInfo.Provenance})
 	}
+	sources = append(sources, diva.Source{Dataset: repo.Dataset(), Provenance: repo.Provenance})
 	helpers.PrintComplete("Packages populated successfully")
 
+	// the repo metadata does not have the hashes and attributes of files
+	err = diva.FetchPayload(&repo)
+	helpers.FailIfErr(err)
+
 	results, err := CheckFileConflicts(repo.Packages)
 	helpers.FailIfErr(err)
+	for _, s := range sources {
+		results.AddSource(s.Dataset, s.Provenance)
+	}
 
 	if results.Failed > 0 {
 		os.Exit(1)
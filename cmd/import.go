@@ -56,7 +56,10 @@ var importRepoCmd = &cobra.Command{
 supplied, otherwise import the latest available. If --upstreamurl is supplied,
 import from <url> instead of the configured/default upstream URL. The repository
 is cached under the cache location defined in the configuration or default to
-$HOME/clearlinux/data/rpms/<version>.`,
+$HOME/clearlinux/data/rpms/<version>. Pass --arch to import the binary and
+debuginfo repos of an architecture other than x86_64. Pass --metadata to
+import the repository from the primary.xml and filelists.xml downloaded with
+'download repo --metadata' instead of the RPMs.`,
 }
 
 var importBundlesCmd = &cobra.Command{
@@ -97,9 +100,11 @@ func init() {
 	importCmd.PersistentFlags().StringVarP(&importFlags.Version, "version", "v", "0", "version from which to pull data")
 	importCmd.PersistentFlags().StringVarP(&importFlags.UpstreamURL, "upstreamurl", "u", "", "URL from which to pull update metadata")
 	importCmd.PersistentFlags().BoolVar(&importFlags.Latest, "latest", false, "get the latest upstream version")
+	importCmd.PersistentFlags().StringVar(&importFlags.Arch, "arch", "x86_64", "architecture of the binary and debuginfo RPM repos")
 
 	importAllCmd.Flags().StringVarP(&importFlags.UpstreamRepoURL, "repourl", "m", "", "fully qualified URL from which to pull repodata")
 	importAllCmd.Flags().StringVar(&importFlags.RPMCache, "rpmcache", "", "path to repo cache destination")
+	importAllCmd.Flags().BoolVar(&importFlags.Metadata, "metadata", false, "import the repo from its metadata instead of the RPMs")
 	importAllCmd.Flags().BoolVar(&importFlags.BinaryRPM, "binary", false, "fetches only binary RPMs")
 	importAllCmd.Flags().BoolVar(&importFlags.SourceRPM, "source", false, "fetches only SRPMs")
 	importAllCmd.Flags().StringVarP(&importFlags.BundleURL, "bundleurl", "b", "", "URL from which to pull bundle definitions")
@@ -109,6 +114,7 @@ func init() {
 
 	importRepoCmd.Flags().StringVarP(&importFlags.UpstreamRepoURL, "repourl", "m", "", "fully qualified URL from which to pull repodata")
 	importRepoCmd.Flags().StringVar(&importFlags.RPMCache, "rpmcache", "", "path to repo cache destination")
+	importRepoCmd.Flags().BoolVar(&importFlags.Metadata, "metadata", false, "import the repo from its metadata instead of the RPMs")
 	importRepoCmd.Flags().BoolVar(&importFlags.BinaryRPM, "binary", false, "imports binary RPMs")
 	importRepoCmd.Flags().BoolVar(&importFlags.SourceRPM, "source", false, "imports SRPMs")
 	importRepoCmd.Flags().BoolVar(&importFlags.DebugRPM, "debuginfo", false, "imports debug RPMs")
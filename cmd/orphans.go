@@ -0,0 +1,247 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/diva/pkginfo"
+	"github.com/spf13/cobra"
+)
+
+type orphansCmdFlags struct {
+	mixName   string
+	version   string
+	latest    bool
+	allowlist string
+}
+
+var orphansFlags orphansCmdFlags
+
+func init() {
+	orphansCmd.Flags().StringVarP(&orphansFlags.mixName, "name", "n", "clear", "name of data group")
+	orphansCmd.Flags().StringVarP(&orphansFlags.version, "version", "v", "0", "version to check")
+	orphansCmd.Flags().BoolVar(&orphansFlags.latest, "latest", false, "get the latest version from upstreamURL")
+	orphansCmd.Flags().StringVar(&orphansFlags.allowlist, "allowlist", "", "file listing packages that are intentionally not shipped")
+}
+
+var orphansCmd = &cobra.Command{
+	Use:   "orphans",
+	Short: "Report RPMs in the repo that no bundle ships",
+	Long: `Report the RPMs in the binary repo that are not among the packages of any
+bundle or package bundle, counting each package name once. Development
+packages in the binary repo and debuginfo packages in the debuginfo repo whose
+base package is shipped, but none of the bundles shipping it has a -dev
+bundle, are reported separately.
+
+Packages that are intentionally not shipped can be listed in an allowlist
+file passed with --allowlist, one package name or shell pattern per line.
+Empty lines and lines starting with '#' are ignored.`,
+	Run: runCheckOrphans,
+}
+
+func runCheckOrphans(cmd *cobra.Command, args []string) {
+	u := config.UInfo{
+		MixName: orphansFlags.mixName,
+		Ver:     orphansFlags.version,
+		Latest:  orphansFlags.latest,
+		Arch:    checkArch,
+	}
+
+	var allowlist []string
+	var err error
+	if orphansFlags.allowlist != "" {
+		allowlist, err = readAllowlist(orphansFlags.allowlist)
+		helpers.FailIfErr(err)
+	}
+
+	repo, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Populating repo")
+	err = pkginfo.PopulateRepo(&repo)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("Repo populated successfully")
+
+	debugU := u
+	debugU.RPMType = "debug"
+	debug, err := pkginfo.NewRepo(conf, &debugU)
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Populating debuginfo repo")
+	err = pkginfo.PopulateRepo(&debug)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("Debuginfo repo populated successfully")
+
+	bundleInfo, err := pkginfo.NewBundleInfo(conf, &u)
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Populating bundles")
+	err = pkginfo.PopulateBundles(&bundleInfo, "")
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("Bundles populated successfully")
+
+	r := diva.NewSuite("orphans", "report packages no bundle ships")
+	r.AddSource(repo.Dataset(), repo.Provenance)
+	r.AddSource(debug.Dataset(), debug.Provenance)
+	r.AddSource(bundleInfo.Dataset(), bundleInfo.Provenance)
+
+	err = checkOrphans(r, &repo, &debug, bundleInfo.BundleDefinitions, allowlist)
+	helpers.FailIfErr(err)
+
+	if r.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// readAllowlist reads the package names and patterns listed in path
+func readAllowlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	allowlist := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err = filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %v", line, path, err)
+		}
+		allowlist = append(allowlist, line)
+	}
+	return allowlist, scanner.Err()
+}
+
+// isAllowed reports whether name matches one of the allowlist patterns
+func isAllowed(name string, allowlist []string) bool {
+	for _, pattern := range allowlist {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// develSuffixes are the suffixes of packages split off a base package that a
+// -dev bundle is expected to ship
+var develSuffixes = []string{"-dev", "-devel", "-debuginfo"}
+
+// basePackage returns the package name split off into the development or
+// debuginfo package name, or an empty string if it is neither
+func basePackage(name string) string {
+	for _, suffix := range develSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return ""
+}
+
+// missingDevBundle returns why the development or debuginfo package name
+// lacks a -dev bundle to ship it, or an empty string if its base package is
+// not shipped or one of the bundles shipping the base package has one
+func missingDevBundle(name string, shipped map[string]bool, bundles bundle.DefinitionsSet) string {
+	base := basePackage(name)
+	if base == "" || !shipped[base] {
+		return ""
+	}
+
+	shippers := []string{}
+	for bName, b := range bundles {
+		if !b.DirectPackages[base] {
+			continue
+		}
+		if _, ok := bundles[bName+"-dev"]; ok {
+			return ""
+		}
+		shippers = append(shippers, bName)
+	}
+	sort.Strings(shippers)
+	return fmt.Sprintf("%s: %s is shipped by %s", name, base, strings.Join(shippers, ", "))
+}
+
+// packageNames returns the set of names of rpms
+func packageNames(rpms []*pkginfo.RPM) map[string]bool {
+	names := make(map[string]bool)
+	for _, rpm := range rpms {
+		names[rpm.Name] = true
+	}
+	return names
+}
+
+// checkOrphans reports the RPMs in repo that are not shipped by any bundle
+// in bundles and not allowlisted, and the orphaned development packages of
+// repo and debuginfo packages of the debug repo of shipped packages that
+// lack a -dev bundle to ship them. RPMs are counted by name, so all versions
+// and architectures of a package count once.
+func checkOrphans(r *diva.Results, repo, debug *pkginfo.Repo, bundles bundle.DefinitionsSet, allowlist []string) error {
+	shipped, err := bundles.GetAllPackages("")
+	if err != nil {
+		return err
+	}
+
+	names := packageNames(repo.Packages)
+	var orphans, noDevBundle []string
+	for name := range names {
+		if shipped[name] || isAllowed(name, allowlist) {
+			continue
+		}
+		orphans = append(orphans, name)
+		if missing := missingDevBundle(name, shipped, bundles); missing != "" {
+			noDevBundle = append(noDevBundle, missing)
+		}
+	}
+
+	// debuginfo packages are never shipped by a bundle themselves
+	for name := range packageNames(debug.Packages) {
+		if isAllowed(name, allowlist) {
+			continue
+		}
+		if missing := missingDevBundle(name, shipped, bundles); missing != "" {
+			noDevBundle = append(noDevBundle, missing)
+		}
+	}
+
+	sort.Strings(orphans)
+	sort.Strings(noDevBundle)
+
+	r.Ok(len(orphans) == 0, "all packages shipped by a bundle")
+	if len(orphans) > 0 {
+		r.Diagnostic(fmt.Sprintf("packages not shipped by any bundle: %d/%d\n%s",
+			len(orphans), len(names), strings.Join(orphans, "\n")))
+	}
+
+	r.Ok(len(noDevBundle) == 0, "development and debuginfo packages of shipped packages have a -dev bundle")
+	if len(noDevBundle) > 0 {
+		r.Diagnostic("no -dev bundle for the bundles shipping the base package:\n" + strings.Join(noDevBundle, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/pkginfo"
+)
+
+func TestCheckOrphans(t *testing.T) {
+	repo := &pkginfo.Repo{Packages: []*pkginfo.RPM{
+		{Name: "bash", Version: "5.0", Release: "1", Architecture: "x86_64"},
+		{Name: "bash-dev", Version: "5.0", Release: "1", Architecture: "x86_64"},
+		{Name: "zlib", Version: "1.2", Release: "1", Architecture: "x86_64"},
+		{Name: "zlib", Version: "1.2", Release: "1", Architecture: "i686"},
+		{Name: "zlib-devel", Version: "1.2", Release: "1", Architecture: "x86_64"},
+		{Name: "zlib-devel", Version: "1.2", Release: "1", Architecture: "i686"},
+		{Name: "vim", Version: "8.1", Release: "1", Architecture: "x86_64"},
+		{Name: "vim", Version: "8.1", Release: "2", Architecture: "x86_64"},
+		{Name: "emacs-extras", Version: "26", Release: "1", Architecture: "x86_64"},
+	}}
+	debug := &pkginfo.Repo{Packages: []*pkginfo.RPM{
+		{Name: "bash-debuginfo", Version: "5.0", Release: "1", Architecture: "x86_64"},
+		{Name: "zlib-debuginfo", Version: "1.2", Release: "1", Architecture: "x86_64"},
+		{Name: "zlib-debuginfo", Version: "1.2", Release: "1", Architecture: "i686"},
+		{Name: "vim-debuginfo", Version: "8.1", Release: "1", Architecture: "x86_64"},
+		{Name: "emacs-extras-debuginfo", Version: "26", Release: "1", Architecture: "x86_64"},
+	}}
+	bundles := bundle.DefinitionsSet{
+		"shells": {
+			Name:           "shells",
+			DirectPackages: map[string]bool{"bash": true},
+			AllPackages:    map[string]bool{"bash": true},
+		},
+		"shells-dev": {
+			Name:           "shells-dev",
+			DirectPackages: map[string]bool{},
+			AllPackages:    map[string]bool{"bash": true},
+		},
+		"libs": {
+			Name:           "libs",
+			DirectPackages: map[string]bool{"zlib": true},
+			AllPackages:    map[string]bool{"zlib": true},
+		},
+	}
+
+	out := &bytes.Buffer{}
+	r := diva.NewSuite("orphans", "test")
+	r.Writer = out
+	if err := checkOrphans(r, repo, debug, bundles, []string{"emacs-*"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Failed != 2 {
+		t.Errorf("expected 2 failures, got %d\n%s", r.Failed, out.String())
+	}
+	for _, expected := range []string{
+		"packages not shipped by any bundle: 3/6",
+		"bash-dev\n",
+		"vim\n",
+		"zlib-devel\n",
+		"zlib-devel: zlib is shipped by libs",
+		"zlib-debuginfo: zlib is shipped by libs",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in\n%s", expected, out.String())
+		}
+	}
+	for _, unexpected := range []string{"bash-dev:", "bash-debuginfo", "vim-debuginfo", "emacs-extras",
+		"zlib-devel\nzlib-devel", "zlib-debuginfo: zlib is shipped by libs\nzlib-debuginfo"} {
+		if strings.Contains(out.String(), unexpected) {
+			t.Errorf("unexpected %q in\n%s", unexpected, out.String())
+		}
+	}
+}
+
+func TestBasePackage(t *testing.T) {
+	tests := map[string]string{
+		"zlib-dev":       "zlib",
+		"zlib-devel":     "zlib",
+		"zlib":           "",
+		"zlib-debuginfo": "zlib",
+	}
+	for name, expected := range tests {
+		if base := basePackage(name); base != expected {
+			t.Errorf("basePackage(%s) = %q, expected %q", name, base, expected)
+		}
+	}
+}
@@ -67,6 +67,7 @@ func runCheckPyDeps(cmd *cobra.Command, args []string) {
 		MixName: pipFlags.mixName,
 		Ver:     pipFlags.version,
 		Latest:  pipFlags.latest,
+		Arch:    checkArch,
 	}
 
 	repo, err := pkginfo.NewRepo(conf, &u)
@@ -78,12 +79,17 @@ func runCheckPyDeps(cmd *cobra.Command, args []string) {
 	err = checkSystemRequirements()
 	helpers.FailIfErr(err)
 
-	if pipFlags.path == "" || pipFlags.buildroot {
+	buildroot := pipFlags.path == "" || pipFlags.buildroot
+	if buildroot {
 		err := createFullChroot(p, &bundleInfo, &repo)
 		helpers.FailIfErr(err)
 	}
 
 	results := CheckPyDeps(p)
+	if buildroot {
+		results.AddSource(repo.Dataset(), repo.Provenance)
+		results.AddSource(bundleInfo.Dataset(), bundleInfo.Provenance)
+	}
 	if results.Failed > 0 {
 		os.Exit(1)
 	}
@@ -112,6 +118,12 @@ func createFullChroot(path string, bundleInfo *pkginfo.BundleInfo, repo *pkginfo
 	}
 	helpers.PrintComplete("Repo populated successfully")
 
+	// dnf installs from the RPMs in the cache
+	err = diva.FetchPayload(repo)
+	if err != nil {
+		return err
+	}
+
 	// create repo information
 	err = helpers.RunCommandSilent("createrepo_c", repo.RPMCache)
 	if err != nil {
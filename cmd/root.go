@@ -17,9 +17,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/clearlinux/diva/internal/config"
 	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/diva/pkginfo"
 
 	"github.com/spf13/cobra"
 )
@@ -45,14 +47,21 @@ and more.`,
 var rootCmdFlags = struct {
 	version    bool
 	configPath string
+	jobs       int
+	rateLimit  string
 }{}
 
 func init() {
+	pkginfo.DivaVersion = version
 	cobra.OnInitialize(initConfig)
 	rootCmd.Flags().BoolVar(&rootCmdFlags.version,
 		"version", false, "Print version information and exit")
 	rootCmd.PersistentFlags().StringVarP(&rootCmdFlags.configPath,
 		"config", "c", "", "optional path to configuration file")
+	rootCmd.PersistentFlags().IntVar(&rootCmdFlags.jobs,
+		"jobs", 0, "number of files to download or check at once, overrides the configuration")
+	rootCmd.PersistentFlags().StringVar(&rootCmdFlags.rateLimit,
+		"rate-limit", "", "bandwidth limit of downloads in bytes per second, e.g. 10M, overrides the configuration")
 }
 
 var conf *config.Config
@@ -61,12 +70,29 @@ func initConfig() {
 	var err error
 	conf, err = config.ReadConfig(rootCmdFlags.configPath)
 	helpers.FailIfErr(err)
+
+	if rootCmd.PersistentFlags().Changed("jobs") {
+		conf.Download.Jobs = rootCmdFlags.jobs
+	}
+	if rootCmd.PersistentFlags().Changed("rate-limit") {
+		conf.Download.RateLimit = rootCmdFlags.rateLimit
+	}
+
+	client := helpers.NewDownloadClient(conf.Download.Retries,
+		time.Duration(conf.Download.Backoff)*time.Second, time.Duration(conf.Download.Timeout)*time.Second)
+	client.Jobs = conf.Download.Jobs
+	client.Mirrors = conf.Mirrors
+	client.RateLimit, err = helpers.ParseByteSize(conf.Download.RateLimit)
+	helpers.FailIfErr(err)
+	helpers.DefaultDownloadClient = client
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	_ = pkginfo.ClosePools()
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
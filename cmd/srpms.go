@@ -0,0 +1,162 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/diva/pkginfo"
+	"github.com/spf13/cobra"
+)
+
+type srpmsCmdFlags struct {
+	mixName string
+	version string
+	latest  bool
+}
+
+var srpmsFlags srpmsCmdFlags
+
+func init() {
+	srpmsCmd.Flags().StringVarP(&srpmsFlags.mixName, "name", "n", "clear", "name of data group")
+	srpmsCmd.Flags().StringVarP(&srpmsFlags.version, "version", "v", "0", "version to check")
+	srpmsCmd.Flags().BoolVar(&srpmsFlags.latest, "latest", false, "get the latest version from upstreamURL")
+}
+
+var srpmsCmd = &cobra.Command{
+	Use:   "srpms",
+	Short: "Check that binary RPMs and source RPMs match",
+	Long: `Check that the binary repo and the SRPM repo of a version are consistent.
+Reports binary RPMs whose source RPM is not in the SRPM repo, source RPMs that
+produce no binary RPM in the binary repo, and binary RPMs whose version or
+release differs from the source RPM in the SRPM repo.`,
+	Run: runCheckSRPMs,
+}
+
+func runCheckSRPMs(cmd *cobra.Command, args []string) {
+	u := config.UInfo{
+		MixName: srpmsFlags.mixName,
+		Ver:     srpmsFlags.version,
+		Latest:  srpmsFlags.latest,
+		RPMType: "SRPM",
+		Arch:    checkArch,
+	}
+
+	srpms, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	// the binary repo must be the same version, even if it was the latest
+	u.RPMType = "B"
+	u.Ver = srpms.Version
+	u.Latest = false
+	rpms, err := pkginfo.NewRepo(conf, &u)
+	helpers.FailIfErr(err)
+
+	helpers.PrintBegin("Populating SRPM and binary repos")
+	err = pkginfo.PopulateRepo(&srpms)
+	helpers.FailIfErr(err)
+	err = pkginfo.PopulateRepo(&rpms)
+	helpers.FailIfErr(err)
+	helpers.PrintComplete("Repos populated successfully")
+
+	r := diva.NewSuite("srpms", "check binary and source RPMs match")
+	r.AddSource(srpms.Dataset(), srpms.Provenance)
+	r.AddSource(rpms.Dataset(), rpms.Provenance)
+
+	checkSRPMs(r, &srpms, &rpms)
+
+	if r.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkSRPMs cross checks the SRPMName of every binary RPM in rpms against
+// the source RPMs in srpms
+func checkSRPMs(r *diva.Results, srpms, rpms *pkginfo.Repo) {
+	// the SRPM repo may hold several versions of a source package, so every
+	// one of them is matched against
+	byName := make(map[string][]*pkginfo.RPM, len(srpms.Packages))
+	for _, srpm := range srpms.Packages {
+		byName[srpm.Name] = append(byName[srpm.Name], srpm)
+	}
+
+	var missing, mismatched []string
+	built := make(map[string]bool)
+	for _, rpm := range rpms.Packages {
+		name, version, release, err := pkginfo.ParseSRPMName(rpm.SRPMName)
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("%s: %v", rpm.Name, err))
+			continue
+		}
+
+		variants, ok := byName[name]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%s from %s", rpm.Name, rpm.SRPMName))
+			continue
+		}
+		built[name] = true
+
+		var srpm *pkginfo.RPM
+		var nvrs []string
+		for _, v := range variants {
+			if v.Version == version && v.Release == release {
+				srpm = v
+				break
+			}
+			nvrs = append(nvrs, fmt.Sprintf("%s-%s-%s", v.Name, v.Version, v.Release))
+		}
+
+		if srpm == nil {
+			sort.Strings(nvrs)
+			mismatched = append(mismatched, fmt.Sprintf("%s was built from %s, but the SRPM repo has %s",
+				rpm.Name, rpm.SRPMName, strings.Join(nvrs, ", ")))
+		} else if rpm.Version != srpm.Version || rpm.Release != srpm.Release {
+			mismatched = append(mismatched, fmt.Sprintf("%s is %s-%s, but its source %s is %s-%s",
+				rpm.Name, rpm.Version, rpm.Release, srpm.Name, srpm.Version, srpm.Release))
+		}
+	}
+
+	var unbuilt []string
+	for name := range byName {
+		if !built[name] {
+			unbuilt = append(unbuilt, name)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(mismatched)
+	sort.Strings(unbuilt)
+
+	r.Ok(len(missing) == 0, "all binary RPMs have a source RPM")
+	if len(missing) > 0 {
+		r.Diagnostic("binary RPMs without source RPM:\n" + strings.Join(missing, "\n"))
+	}
+
+	r.Ok(len(unbuilt) == 0, "all source RPMs produce a binary RPM")
+	if len(unbuilt) > 0 {
+		r.Diagnostic("source RPMs without binary RPMs:\n" + strings.Join(unbuilt, "\n"))
+	}
+
+	r.Ok(len(mismatched) == 0, "binary RPM versions match their source RPMs")
+	if len(mismatched) > 0 {
+		r.Diagnostic("version mismatches:\n" + strings.Join(mismatched, "\n"))
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/diva/diva"
+	"github.com/clearlinux/diva/pkginfo"
+)
+
+func TestCheckSRPMs(t *testing.T) {
+	srpms := &pkginfo.Repo{Packages: []*pkginfo.RPM{
+		{Name: "bash", Version: "5.0", Release: "2", Architecture: "src"},
+		{Name: "bash", Version: "5.0", Release: "1", Architecture: "src"},
+		{Name: "zlib", Version: "1.2", Release: "2", Architecture: "src"},
+		{Name: "zlib", Version: "1.2", Release: "3", Architecture: "src"},
+	}}
+	rpms := &pkginfo.Repo{Packages: []*pkginfo.RPM{
+		{Name: "bash", Version: "5.0", Release: "1", SRPMName: "bash-5.0-1.src.rpm"},
+		{Name: "bash-doc", Version: "5.0", Release: "2", SRPMName: "bash-5.0-2.src.rpm"},
+		{Name: "zlib", Version: "1.2", Release: "1", SRPMName: "zlib-1.2-1.src.rpm"},
+	}}
+
+	out := &bytes.Buffer{}
+	r := diva.NewSuite("srpms", "test")
+	r.Writer = out
+	checkSRPMs(r, srpms, rpms)
+
+	if r.Failed != 1 {
+		t.Errorf("expected 1 failure, got %d\n%s", r.Failed, out.String())
+	}
+	expected := "zlib was built from zlib-1.2-1.src.rpm, but the SRPM repo has zlib-1.2-2, zlib-1.2-3"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("expected %q in\n%s", expected, out.String())
+	}
+	if strings.Contains(out.String(), "bash") {
+		t.Errorf("expected every bash variant to match\n%s", out.String())
+	}
+}
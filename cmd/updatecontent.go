@@ -84,6 +84,7 @@ func UCCheck(m *pkginfo.ManifestInfo) (*diva.Results, error) {
 	r := diva.NewSuite("updatecontent", "check update content for release")
 
 	r.Header(0)
+	r.AddSource(m.Dataset(), m.Provenance)
 	err = updatecontent.CheckManifestHashes(r, conf, m)
 	if err != nil {
 		return r, err
@@ -15,28 +15,68 @@
 package diva
 
 import (
+	"path/filepath"
+
 	"github.com/clearlinux/diva/download"
 	"github.com/clearlinux/diva/internal/config"
 	"github.com/clearlinux/diva/internal/helpers"
 	"github.com/clearlinux/diva/pkginfo"
 )
 
-// DownloadRepo downloads the RPM repo to the local cache location
+// DownloadRepo downloads the RPM repo to the local cache location. If
+// u.Metadata is set only the repo metadata is downloaded.
 func DownloadRepo(conf *config.Config, u *config.UInfo, repo *pkginfo.Repo) {
+	if u.Metadata {
+		helpers.PrintBegin("fetching RPM repo metadata from %s", repo.URI)
+		err := download.RepoMetadata(repo, u.Update)
+		helpers.FailIfErr(err)
+		helpers.PrintComplete("repo metadata cached at %s", filepath.Dir(repo.RPMCache))
+		return
+	}
+
 	helpers.PrintBegin("fetching RPM repo from %s", repo.URI)
 	err := download.RepoFiles(repo, u.Update)
 	helpers.FailIfErr(err)
 	helpers.PrintComplete("repo cached at %s", repo.RPMCache)
 }
 
-// ImportRepo stores the repo data from the cacheloc to the database
+// ImportRepo stores the repo data from the cacheloc to the database. If
+// u.Metadata is set the repo is imported from the repo metadata.
 func ImportRepo(conf *config.Config, u *config.UInfo, repo *pkginfo.Repo) {
+	if u.Metadata {
+		helpers.PrintBegin("importing repo metadata from %s to database", filepath.Dir(repo.RPMCache))
+		err := pkginfo.ImportRepoMetadata(repo)
+		helpers.FailIfErr(err)
+		helpers.PrintComplete("RPM repo metadata imported successfully")
+		return
+	}
+
 	helpers.PrintBegin("importing repo from %s to database", repo.RPMCache)
 	err := pkginfo.ImportAllRPMs(repo, u.Update)
 	helpers.FailIfErr(err)
 	helpers.PrintComplete("RPM repo imported successfully")
 }
 
+// FetchPayload downloads the RPMs in repo.Packages of a repo imported from its
+// repo metadata to the RPM cache and reads their file attributes, for checks
+// that need more than the file names. It does nothing for repos imported from
+// the RPM files.
+func FetchPayload(repo *pkginfo.Repo) error {
+	if !repo.Provenance.Repodata {
+		return nil
+	}
+
+	helpers.PrintBegin("fetching %d RPMs from %s", len(repo.Packages), repo.URI)
+	if err := download.Packages(repo, repo.Packages); err != nil {
+		return err
+	}
+	if err := pkginfo.LoadPayload(repo); err != nil {
+		return err
+	}
+	helpers.PrintComplete("RPMs cached at %s", repo.RPMCache)
+	return nil
+}
+
 // FetchRepo downloads RPMs from the repo.URI location and imports them into
 // the database. This calls both the DownloadRepo and ImportRepo functions
 func FetchRepo(conf *config.Config, u *config.UInfo) {
@@ -16,17 +16,27 @@ package diva
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 
+	"github.com/clearlinux/diva/pkginfo"
 	"github.com/mndrix/tap-go" // tap
 )
 
+// Source is a dataset a test run read from the database and the provenance
+// recorded when it was imported
+type Source struct {
+	Dataset    pkginfo.Dataset
+	Provenance pkginfo.Provenance
+}
+
 // Results holds the results of a test run
 type Results struct {
 	Name        string
 	Description string
 	Passed      uint
 	Failed      uint
+	Sources     []Source
 	*tap.T
 }
 
@@ -59,3 +69,10 @@ func (r *Results) Ok(test bool, description string) {
 	}
 	r.T.Ok(test, description)
 }
+
+// AddSource records that the results are based on the dataset ds imported
+// with the provenance p, and prints it as a diagnostic
+func (r *Results) AddSource(ds pkginfo.Dataset, p pkginfo.Provenance) {
+	r.Sources = append(r.Sources, Source{Dataset: ds, Provenance: p})
+	r.Diagnostic(fmt.Sprintf("using %s: %s", ds, p))
+}
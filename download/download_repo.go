@@ -21,6 +21,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	rpm "github.com/cavaliercoder/go-rpm"
@@ -28,11 +30,12 @@ import (
 	"github.com/clearlinux/diva/pkginfo"
 )
 
-// buildFileListsURL parses upstream repomd.xml file to find the filelists
-// file.  We cannot just look for the filelists file directly because a hash is
-// part of the filename. The repomd.xml file lists this file name so we can
-// construct the url using this value.
-func buildFilelistsURL(repo *pkginfo.Repo, update bool) (string, error) {
+// repodataURLs parses upstream repomd.xml file to find the repodata files of
+// the repo by their type, e.g. "primary" or "filelists". We cannot just look
+// for these files directly because a hash is part of the filename. The
+// repomd.xml file lists the file names so we can construct the urls using
+// these values.
+func repodataURLs(repo *pkginfo.Repo, update bool) (map[string]string, error) {
 	// download repomd.xml
 	repomdFile := filepath.Join(filepath.Dir(repo.RPMCache), "repomd.xml")
 	repomdURL := fmt.Sprintf("%s/repodata/repomd.xml", repo.URI)
@@ -44,7 +47,7 @@ func buildFilelistsURL(repo *pkginfo.Repo, update bool) (string, error) {
 	if err != nil || update {
 		err = helpers.Download(repomdURL, repomdFile, update)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 	}
 
@@ -68,70 +71,49 @@ func buildFilelistsURL(repo *pkginfo.Repo, update bool) (string, error) {
 
 	d, err := ioutil.ReadFile(repomdFile)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	v := new(repomd)
 	err = xml.Unmarshal([]byte(d), v)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var path string
+	urls := make(map[string]string)
 	for _, section := range v.Data {
-		if section.Key == "filelists" {
-			path = section.Location.Path
-			break
+		if _, ok := urls[section.Key]; !ok {
+			urls[section.Key] = fmt.Sprintf("%s/%s", repo.URI, section.Location.Path)
 		}
 	}
-
-	return fmt.Sprintf("%s/%s", repo.URI, path), nil
+	return urls, nil
 }
 
-// If a RPM needs to be redownloaded, return true, otherwise return false
-func updateCache(newRPM, rpmCache string, cRPM *rpm.PackageFile) bool {
-	cachedRPM := fmt.Sprintf("%s-%s-%s.%s.rpm",
-		cRPM.Name(),
-		cRPM.Version(),
-		cRPM.Release(),
-		cRPM.Architecture(),
-	)
-
-	if cachedRPM == newRPM {
-		return false
+// downloadRepodata downloads the repodata file of type key listed in urls to
+// the working directory of the repo as <key>.xml. The file can be either gz or
+// xz compressed, DownloadFile uses whatever extraction method is appropriate
+// based on the file extension.
+func downloadRepodata(repo *pkginfo.Repo, urls map[string]string, key string, update bool) error {
+	url, ok := urls[key]
+	if !ok {
+		return fmt.Errorf("no %s data listed in repomd.xml of %s", key, repo.URI)
 	}
-
-	cachedRPMURL := filepath.Join(rpmCache, cachedRPM)
-	_ = os.Remove(cachedRPMURL)
-	return true
+	return helpers.DownloadFile(url, filepath.Join(filepath.Dir(repo.RPMCache), key+".xml"), update)
 }
 
-func buildPackageURLs(repo *pkginfo.Repo, flistsPath string, upgrade bool) ([]string, error) {
-	// <filelists xmlns="http://linux.duke.edu/metadata/filelists" packages="7436">
-	//   <package ... name="pkgname" arch="x86_64">
-	//     <version ... ver="ver" rel="rel"/>
-	//     ...
-	//   </package>
-	type verRel struct {
-		V string `xml:"ver,attr"`
-		R string `xml:"rel,attr"`
-	}
-	type packageInfo struct {
-		Name string `xml:"name,attr"`
-		VR   verRel `xml:"version"`
-		Arch string `xml:"arch,attr"`
-	}
-	type filelists struct {
-		XMLName  xml.Name      `xml:"filelists"`
-		Packages []packageInfo `xml:"package"`
-	}
-
-	d, err := ioutil.ReadFile(flistsPath)
-	if err != nil {
-		return []string{}, err
+// cacheRepodata downloads the repodata file of type key listed in urls unless
+// it is already cached and update is not set
+func cacheRepodata(repo *pkginfo.Repo, urls map[string]string, key string, update bool) error {
+	if _, err := os.Stat(filepath.Join(filepath.Dir(repo.RPMCache), key+".xml")); err == nil && !update {
+		return nil
 	}
+	return downloadRepodata(repo, urls, key, update)
+}
 
-	v := new(filelists)
-	err = xml.Unmarshal(d, v)
+// buildPackageURLs returns the urls of the RPMs listed in the primary.xml of
+// the repo at their locations. With upgrade the RPMs already in the RPMCache
+// are left out, and cached RPMs the repo no longer lists are removed.
+func buildPackageURLs(repo *pkginfo.Repo, upgrade bool) ([]string, error) {
+	locations, err := pkginfo.RepodataLocations(repo)
 	if err != nil {
 		return []string{}, err
 	}
@@ -140,63 +122,97 @@ func buildPackageURLs(repo *pkginfo.Repo, flistsPath string, upgrade bool) ([]st
 		return []string{}, err
 	}
 
-	// convert slice to map for faster rpm lookup
-	cachedRPMs := map[string]*rpm.PackageFile{}
+	// index the cached RPMs by NVRA, a repo may hold several versions and
+	// architectures of a package
+	cachedRPMs := map[string]string{}
 	if upgrade {
 		cRPMs, err := rpm.OpenPackageFiles(repo.RPMCache)
 		if err != nil {
 			return []string{}, err
 		}
 		for _, c := range cRPMs {
-			cachedRPMs[c.Name()] = c
+			cachedRPMs[pkginfo.NVRA(c.Name(), c.Version(), c.Release(), c.Architecture())] = c.Path()
 		}
 	}
 
-	rpmURL := repo.URI
-	if repo.Type == "B" {
-		rpmURL = fmt.Sprintf("%s/Packages", repo.URI)
+	packages := []string{}
+	for nvra, href := range locations {
+		if _, exists := cachedRPMs[nvra]; exists {
+			continue
+		}
+		packages = append(packages, fmt.Sprintf("%s/%s", repo.URI, href))
 	}
+	sort.Strings(packages)
 
-	packages := []string{}
-	for _, p := range v.Packages {
-		rpm := fmt.Sprintf("%s-%s-%s.%s.rpm", p.Name, p.VR.V, p.VR.R, p.Arch)
-		if upgrade {
-			if cRPM, exists := cachedRPMs[p.Name]; exists {
-				if !updateCache(rpm, repo.RPMCache, cRPM) {
-					continue
-				}
-			}
+	// cached RPMs the repo no longer lists were replaced by another version
+	for nvra, path := range cachedRPMs {
+		if _, ok := locations[nvra]; !ok {
+			_ = os.Remove(path)
 		}
-		packages = append(packages, fmt.Sprintf("%s/%s", rpmURL, rpm))
 	}
 	return packages, nil
 }
 
-func downloadAllRPMs(packages []string, rpmCache string) error {
+// verifyError is the failure of a downloaded rpm to verify
+type verifyError struct {
+	rpm string
+	err error
+}
+
+func (e *verifyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.rpm, e.err)
+}
+
+// downloadAllRPMs downloads the packages that are not in the rpmCache yet and
+// verifies them with v, along with the ones that are. RPMs that fail
+// verification are deleted and downloaded again up to verifyAttempts times.
+func downloadAllRPMs(packages []string, rpmCache string, v *verifier) error {
 	// ensure directory in cache exists
 	if err := os.MkdirAll(rpmCache, 0755); err != nil {
 		return err
 	}
 	var wg sync.WaitGroup
-	workers := runtime.NumCPU()
+	workers := helpers.DownloadJobs(runtime.NumCPU())
 	wg.Add(workers)
 	urlCh := make(chan string)
 	errorCh := make(chan error)
+	progress := helpers.NewProgress("RPMs", len(packages))
+
+	// download and verify a single rpm
+	dlRPM := func(url string) {
+		base := filepath.Base(url)
+		outFile := filepath.Join(rpmCache, base)
+		// do not download again if it already exists and is intact
+		if _, err := os.Stat(outFile); err == nil {
+			if v.verifyCached(outFile) == nil {
+				return
+			}
+			_ = os.Remove(outFile)
+		}
+		var dlErr, verifyErr error
+		for i := 0; i < verifyAttempts; i++ {
+			if dlErr = helpers.Download(url, outFile, false); dlErr != nil {
+				break
+			}
+			if verifyErr = v.verify(outFile); verifyErr == nil {
+				break
+			}
+			// delete the corrupt file so it is downloaded again
+			_ = os.Remove(outFile)
+		}
+		// report the error to the user
+		if dlErr != nil {
+			errorCh <- fmt.Errorf("%s: %v", base, dlErr)
+		} else if verifyErr != nil {
+			errorCh <- &verifyError{base, verifyErr}
+		}
+	}
 
 	// download worker
 	dlWorker := func() {
 		for url := range urlCh {
-			base := filepath.Base(url)
-			outFile := filepath.Join(rpmCache, "packages", base)
-			var dlErr error
-			// do not download again if it already exists
-			if _, dlErr = os.Stat(outFile); dlErr != nil {
-				dlErr = helpers.Download(url, outFile, false)
-			}
-			if dlErr != nil {
-				// report the error to the user
-				errorCh <- dlErr
-			}
+			dlRPM(url)
+			progress.Done(1)
 		}
 		wg.Done()
 	}
@@ -223,14 +239,37 @@ func downloadAllRPMs(packages []string, rpmCache string) error {
 	}
 	close(urlCh)
 	wg.Wait()
+	progress.Finish()
 	// close this when all the urls have finished processing
 	close(errorCh)
+	if err := v.saveRecord(); err != nil {
+		return err
+	}
 
 	// final check for error that could happen after all workers are spawned
-	// report failed downloads to user
-	errors := <-errSummary
-	if len(errors) > 0 {
-		return fmt.Errorf("unable to download %d RPMs, please try again", len(errors))
+	// report failed downloads and verifications to user
+	var dlFailures, verifyFailures []string
+	for _, dlErr := range <-errSummary {
+		if _, ok := dlErr.(*verifyError); ok {
+			verifyFailures = append(verifyFailures, dlErr.Error())
+		} else {
+			dlFailures = append(dlFailures, dlErr.Error())
+		}
+	}
+
+	var summary []string
+	if len(dlFailures) > 0 {
+		sort.Strings(dlFailures)
+		summary = append(summary, fmt.Sprintf("unable to download %d RPMs, please try again:\n  %s",
+			len(dlFailures), strings.Join(dlFailures, "\n  ")))
+	}
+	if len(verifyFailures) > 0 {
+		sort.Strings(verifyFailures)
+		summary = append(summary, fmt.Sprintf("%d RPMs failed verification:\n  %s",
+			len(verifyFailures), strings.Join(verifyFailures, "\n  ")))
+	}
+	if len(summary) > 0 {
+		return fmt.Errorf("%s", strings.Join(summary, "\n"))
 	}
 
 	return nil
@@ -247,7 +286,7 @@ func RepoFiles(repo *pkginfo.Repo, update bool) error {
 		return err
 	}
 
-	url, err := buildFilelistsURL(repo, update)
+	urls, err := repodataURLs(repo, update)
 	if err != nil {
 		return err
 	}
@@ -256,18 +295,74 @@ func RepoFiles(repo *pkginfo.Repo, update bool) error {
 	if _, err = os.Stat(flistsPath); err == nil && !update {
 		return fmt.Errorf(`%s already exists, so it will fail to redownload. Pass '--update' to overwrite the current filelists.xml file, or download to a different --repocache location`, flistsPath)
 	}
-	// this file can be either gz or xz compressed, use DownloadFile
-	// which will use whatever extraction method is appropriate based
-	// on the file extension.
-	err = helpers.DownloadFile(url, flistsPath, update)
+	err = downloadRepodata(repo, urls, "filelists", update)
 	if err != nil {
 		return err
 	}
 
-	packages, err := buildPackageURLs(repo, flistsPath, update)
+	// the checksums of the RPMs are listed in primary.xml
+	err = cacheRepodata(repo, urls, "primary", update)
+	if err != nil {
+		return err
+	}
+	v, err := newVerifier(repo)
+	if err != nil {
+		return err
+	}
+
+	packages, err := buildPackageURLs(repo, update)
+	if err != nil {
+		return err
+	}
+
+	return downloadAllRPMs(packages, repo.RPMCache, v)
+}
+
+// RepoMetadata downloads the repomd.xml, primary.xml, and filelists.xml of the
+// RPM repo at repo.URI next to the repo.RPMCache, without downloading any RPM.
+// Cached files are only downloaded again if update is set.
+func RepoMetadata(repo *pkginfo.Repo, update bool) error {
+	workingDir := filepath.Dir(repo.RPMCache)
+	if err := os.MkdirAll(workingDir, 0755); err != nil {
+		return err
+	}
+
+	urls, err := repodataURLs(repo, update)
 	if err != nil {
 		return err
 	}
 
-	return downloadAllRPMs(packages, workingDir)
+	for _, key := range []string{"primary", "filelists"} {
+		if err = cacheRepodata(repo, urls, key, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Packages downloads the given RPMs of the repo from their locations in the
+// primary.xml to the repo.RPMCache unless they are already there intact, e.g.
+// for checks that need the payload of RPMs imported from the repo metadata.
+func Packages(repo *pkginfo.Repo, rpms []*pkginfo.RPM) error {
+	locations, err := pkginfo.RepodataLocations(repo)
+	if err != nil {
+		return err
+	}
+	packages := []string{}
+	for _, r := range rpms {
+		href, ok := locations[r.NVRA()]
+		if !ok {
+			return fmt.Errorf("%s is not listed in primary.xml", r.NVRA())
+		}
+		packages = append(packages, fmt.Sprintf("%s/%s", repo.URI, href))
+	}
+	if len(packages) == 0 {
+		return nil
+	}
+
+	v, err := newVerifier(repo)
+	if err != nil {
+		return err
+	}
+	return downloadAllRPMs(packages, repo.RPMCache, v)
 }
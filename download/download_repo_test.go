@@ -0,0 +1,93 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package download
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/diva/pkginfo"
+	"github.com/go-test/deep"
+)
+
+// testPackage encodes an rpm of the package name-version-release.arch
+func testPackage(name, version, release, arch string) []byte {
+	sigs := testHeader(testEntry{1004, []byte("md5"), 0})
+	header := testHeader(stringEntry(1000, name), stringEntry(1001, version),
+		stringEntry(1002, release), stringEntry(1022, arch))
+	return testRPM(sigs, header, nil)
+}
+
+func TestBuildPackageURLsUpdate(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	repo := &pkginfo.Repo{URI: "https://example.com/repo", Type: "B", RPMCache: filepath.Join(dir, "packages")}
+	listed := [][4]string{
+		{"zlib", "1.2.11", "1", "x86_64"},
+		{"zlib", "1.2.11", "1", "i686"},
+		{"bash", "5.0", "2", "x86_64"},
+	}
+	entries := []string{}
+	for _, p := range listed {
+		entries = append(entries, fmt.Sprintf(`<package><name>%s</name><arch>%s</arch><version ver="%s" rel="%s"/>`+
+			`<location href="Packages/%c/%s.rpm"/></package>`, p[0], p[3], p[1], p[2], p[0][0], pkginfo.NVRA(p[0], p[1], p[2], p[3])))
+	}
+	primary := filepath.Join(dir, "primary.xml")
+	err := ioutil.WriteFile(primary, []byte("<metadata>"+strings.Join(entries, "")+"</metadata>"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both variants of zlib are cached, bash is cached in a version the repo
+	// no longer lists
+	if err = os.MkdirAll(repo.RPMCache, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cached := [][4]string{
+		{"zlib", "1.2.11", "1", "x86_64"},
+		{"zlib", "1.2.11", "1", "i686"},
+		{"bash", "5.0", "1", "x86_64"},
+	}
+	for _, p := range cached {
+		path := filepath.Join(repo.RPMCache, pkginfo.NVRA(p[0], p[1], p[2], p[3])+".rpm")
+		if err = ioutil.WriteFile(path, testPackage(p[0], p[1], p[2], p[3]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	urls, err := buildPackageURLs(repo, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(urls, []string{"https://example.com/repo/Packages/b/bash-5.0-2.x86_64.rpm"}); diff != nil {
+		t.Error(diff)
+	}
+
+	for _, name := range []string{"zlib-1.2.11-1.x86_64.rpm", "zlib-1.2.11-1.i686.rpm"} {
+		if _, err = os.Stat(filepath.Join(repo.RPMCache, name)); err != nil {
+			t.Errorf("expected cached %s to be kept: %v", name, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(repo.RPMCache, "bash-5.0-1.x86_64.rpm")); !os.IsNotExist(err) {
+		t.Errorf("expected replaced bash to be removed: %v", err)
+	}
+}
@@ -80,10 +80,11 @@ func UpdateFiles(mInfo *pkginfo.ManifestInfo) error {
 	}
 
 	var wg sync.WaitGroup
-	nworkers := 8
+	nworkers := helpers.DownloadJobs(8)
 	wg.Add(nworkers)
 	fChan := make(chan finfo)
 	errChan := make(chan error, nworkers)
+	progress := helpers.NewProgress("files", len(dlFiles))
 
 	for i := 0; i < nworkers; i++ {
 		go func() {
@@ -91,11 +92,13 @@ func UpdateFiles(mInfo *pkginfo.ManifestInfo) error {
 			for f := range fChan {
 				// we already have this file cached
 				if _, err := os.Lstat(strings.TrimSuffix(f.out, ".tar")); err == nil {
+					progress.Done(1)
 					continue
 				}
 
 				f.err = helpers.TarExtractURL(f.url, f.out)
 				_ = os.Remove(f.out)
+				progress.Done(1)
 
 				if f.err != nil {
 					errChan <- f.err
@@ -115,6 +118,7 @@ func UpdateFiles(mInfo *pkginfo.ManifestInfo) error {
 		}
 	}()
 	wg.Wait()
+	progress.Finish()
 	close(errChan)
 
 	if len(errChan) > 0 {
@@ -0,0 +1,270 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package download
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	rpm "github.com/cavaliercoder/go-rpm"
+	"github.com/clearlinux/diva/pkginfo"
+	"golang.org/x/crypto/openpgp"
+)
+
+// verifyAttempts is how many times an RPM that fails verification is
+// downloaded before it is reported
+const verifyAttempts = 3
+
+// verifiedRecord is the file next to the primary.xml that records the cached
+// RPMs that passed verification
+const verifiedRecord = "verified.json"
+
+// verifiedFile is the state of an rpm file when it passed verification. The
+// file is not hashed again while its size and modification time are
+// unchanged and its checksum is still the one listed in primary.xml.
+type verifiedFile struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Checksum string    `json:"checksum"`
+	Signed   bool      `json:"signed"`
+}
+
+// verifier checks downloaded RPMs against the checksums in the primary.xml of
+// their repo and, if a keyring is configured, their signatures
+type verifier struct {
+	checksums map[string]pkginfo.RepodataChecksum
+	keyring   openpgp.KeyRing
+
+	// record is the path of the verifiedRecord, verified files are not
+	// recorded if it is empty
+	record   string
+	mutex    sync.Mutex
+	verified map[string]verifiedFile
+}
+
+// newVerifier reads the checksums of the RPMs of the repo from the
+// primary.xml next to its RPMCache, the keyring of the repo, and the record
+// of the cached RPMs that were verified before
+func newVerifier(repo *pkginfo.Repo) (*verifier, error) {
+	checksums, err := pkginfo.RepodataChecksums(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	ver := &verifier{
+		checksums: checksums,
+		record:    filepath.Join(filepath.Dir(repo.RPMCache), verifiedRecord),
+		verified:  make(map[string]verifiedFile),
+	}
+	if repo.Keyring != "" {
+		if ver.keyring, err = rpm.KeyRingFromFile(repo.Keyring); err != nil {
+			return nil, fmt.Errorf("unable to read keyring %s: %v", repo.Keyring, err)
+		}
+	}
+
+	// a missing or unreadable record only means the cached RPMs are
+	// verified again
+	if data, err := ioutil.ReadFile(ver.record); err == nil {
+		if err = json.Unmarshal(data, &ver.verified); err != nil {
+			ver.verified = make(map[string]verifiedFile)
+		}
+	}
+	return ver, nil
+}
+
+// saveRecord writes the record of the verified RPMs that are still listed in
+// primary.xml
+func (v *verifier) saveRecord() error {
+	if v.record == "" {
+		return nil
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for name := range v.verified {
+		if _, ok := v.checksums[name]; !ok {
+			delete(v.verified, name)
+		}
+	}
+	data, err := json.Marshal(v.verified)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(v.record, data, 0644)
+}
+
+func newChecksumHash(typ string) (hash.Hash, error) {
+	switch typ {
+	case "sha", "sha1":
+		return sha1.New(), nil
+	case "sha224":
+		return sha256.New224(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum type %s", typ)
+}
+
+// verifyChecksum checks the rpm file at path against its checksum in the
+// repodata
+func (v *verifier) verifyChecksum(path string) error {
+	sum, ok := v.checksums[filepath.Base(path)]
+	if !ok {
+		return fmt.Errorf("no checksum listed in primary.xml")
+	}
+
+	h, err := newChecksumHash(sum.Type)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err = io.Copy(h, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sum.Value {
+		return fmt.Errorf("%s checksum mismatch", sum.Type)
+	}
+	return nil
+}
+
+// signature tags of the rpm signature header, from rpmtag.h
+const (
+	sigTagDSAHeader = 267
+	sigTagRSAHeader = 268
+	sigTagPGP       = 1002
+	sigTagGPG       = 1005
+)
+
+// verifySignature checks the signature of the header of the rpm file at path
+// against the keyring. RPMs that are only signed over their header and
+// payload, as by rpm versions before 4.0, are checked against that
+// signature instead.
+func (v *verifier) verifySignature(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err = rpm.ReadPackageLead(f); err != nil {
+		return err
+	}
+	sigs, err := rpm.ReadPackageHeader(f)
+	if err != nil {
+		return err
+	}
+	// the header follows the signature header at the next multiple of 8
+	if _, err = io.CopyN(ioutil.Discard, f, int64(8-(sigs.Length%8))%8); err != nil {
+		return err
+	}
+	var header bytes.Buffer
+	if _, err = rpm.ReadPackageHeader(io.TeeReader(f, &header)); err != nil {
+		return err
+	}
+
+	signed := io.Reader(&header)
+	sig := sigs.Indexes.BytesByTag(sigTagRSAHeader)
+	if sig == nil {
+		sig = sigs.Indexes.BytesByTag(sigTagDSAHeader)
+	}
+	if sig == nil {
+		signed = io.MultiReader(&header, f)
+		if sig = sigs.Indexes.BytesByTag(sigTagPGP); sig == nil {
+			sig = sigs.Indexes.BytesByTag(sigTagGPG)
+		}
+	}
+	if sig == nil {
+		return fmt.Errorf("not signed")
+	}
+
+	if _, err = openpgp.CheckDetachedSignature(v.keyring, signed, bytes.NewReader(sig)); err != nil {
+		return fmt.Errorf("bad signature: %v", err)
+	}
+	return nil
+}
+
+// verify checks the rpm file at path against its checksum and, if there is
+// a keyring, its signature, and records it as verified
+func (v *verifier) verify(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err = v.verifyChecksum(path); err != nil {
+		return err
+	}
+	if v.keyring != nil {
+		if err = v.verifySignature(path); err != nil {
+			return err
+		}
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.verified == nil {
+		v.verified = make(map[string]verifiedFile)
+	}
+	v.verified[filepath.Base(path)] = verifiedFile{
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Checksum: v.checksums[filepath.Base(path)].Value,
+		Signed:   v.keyring != nil,
+	}
+	return nil
+}
+
+// verifyCached checks the cached rpm file at path like verify unless it
+// passed verification before and is unchanged since
+func (v *verifier) verifyCached(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	v.mutex.Lock()
+	f, ok := v.verified[filepath.Base(path)]
+	v.mutex.Unlock()
+	if ok && f.Size == info.Size() && f.ModTime.Equal(info.ModTime()) &&
+		f.Checksum == v.checksums[filepath.Base(path)].Value && (f.Signed || v.keyring == nil) {
+		return nil
+	}
+	return v.verify(path)
+}
@@ -0,0 +1,356 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clearlinux/diva/pkginfo"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// testEntry is an entry of an rpm header, binary unless typ is set
+type testEntry struct {
+	tag   int
+	value []byte
+	typ   int
+}
+
+// stringEntry returns a string entry of an rpm header
+func stringEntry(tag int, s string) testEntry {
+	return testEntry{tag, []byte(s + "\x00"), 6}
+}
+
+// testHeader encodes an rpm header holding entries
+func testHeader(entries ...testEntry) []byte {
+	var index, store bytes.Buffer
+	for _, e := range entries {
+		typ, count := 7, len(e.value)
+		if e.typ != 0 {
+			typ, count = e.typ, 1
+		}
+		for _, v := range []int{e.tag, typ, store.Len(), count} {
+			_ = binary.Write(&index, binary.BigEndian, uint32(v))
+		}
+		store.Write(e.value)
+	}
+
+	var h bytes.Buffer
+	h.Write([]byte{0x8E, 0xAD, 0xE8, 0x01, 0, 0, 0, 0})
+	_ = binary.Write(&h, binary.BigEndian, uint32(len(entries)))
+	_ = binary.Write(&h, binary.BigEndian, uint32(store.Len()))
+	h.Write(index.Bytes())
+	h.Write(store.Bytes())
+	return h.Bytes()
+}
+
+// testRPM encodes an rpm of the signature header sigs, padded to a multiple
+// of 8 bytes, the header and the payload
+func testRPM(sigs, header, payload []byte) []byte {
+	var r bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xED, 0xAB, 0xEE, 0xDB, 3, 0})
+	r.Write(lead)
+	r.Write(sigs)
+	r.Write(make([]byte, (8-len(sigs)%8)%8))
+	r.Write(header)
+	r.Write(payload)
+	return r.Bytes()
+}
+
+func sha256sum(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "diva-verify-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	content := []byte("rpm content")
+	path := filepath.Join(dir, "a-1-1.x86_64.rpm")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		sum  *pkginfo.RepodataChecksum
+		err  string
+	}{
+		{"match", &pkginfo.RepodataChecksum{Type: "sha256", Value: sha256sum(content)}, ""},
+		{"mismatch", &pkginfo.RepodataChecksum{Type: "sha256", Value: sha256sum([]byte("other content"))}, "sha256 checksum mismatch"},
+		{"unsupported type", &pkginfo.RepodataChecksum{Type: "md5", Value: "9e107d9d372bb6826bd81d3542a419d6"}, "unsupported checksum type md5"},
+		{"not listed", nil, "no checksum listed in primary.xml"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := &verifier{checksums: make(map[string]pkginfo.RepodataChecksum)}
+			if tc.sum != nil {
+				v.checksums[filepath.Base(path)] = *tc.sum
+			}
+			err := v.verifyChecksum(path)
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Errorf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyCached(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	content := []byte("rpm content")
+	primary := fmt.Sprintf(`<metadata><package><checksum type="sha256">%s</checksum><location href="Packages/a.rpm"/></package></metadata>`,
+		sha256sum(content))
+	if err := ioutil.WriteFile(filepath.Join(dir, "primary.xml"), []byte(primary), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a.rpm")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo := &pkginfo.Repo{RPMCache: filepath.Join(dir, "packages")}
+
+	v, err := newVerifier(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = v.verifyCached(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = v.saveRecord(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file of the same size and modification time is not hashed again
+	if err = ioutil.WriteFile(path, []byte("rpm CONTENT"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = newVerifier(repo); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.verifyCached(path); err != nil {
+		t.Errorf("expected recorded rpm to be skipped, got %v", err)
+	}
+
+	// but it is once it was modified
+	modified := info.ModTime().Add(time.Second)
+	if err = os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.verifyCached(path); err == nil || err.Error() != "sha256 checksum mismatch" {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
+
+// writeKeyring writes the armored public key of e to path
+func writeKeyring(path string, e *openpgp.Entity) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = e.Serialize(w); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func TestVerifySignature(t *testing.T) {
+	dir := tempDir(t)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	key, err := openpgp.NewEntity("diva", "", "diva@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(e *openpgp.Entity, signed ...[]byte) []byte {
+		var sig bytes.Buffer
+		if err := openpgp.DetachSign(&sig, e, bytes.NewReader(bytes.Join(signed, nil)), nil); err != nil {
+			t.Fatal(err)
+		}
+		return sig.Bytes()
+	}
+
+	header := testHeader(testEntry{1000, []byte("a\x00"), 0})
+	payload := []byte("payload")
+	tests := []struct {
+		name    string
+		sig     testEntry
+		header  []byte
+		payload []byte
+		err     string
+	}{
+		{"header signature", testEntry{sigTagRSAHeader, sign(key, header), 0}, header, payload, ""},
+		{"header and payload signature", testEntry{sigTagPGP, sign(key, header, payload), 0}, header, payload, ""},
+		{"unsigned", testEntry{1004, []byte("md5"), 0}, header, payload, "not signed"},
+		{"unknown key", testEntry{sigTagRSAHeader, sign(other, header), 0}, header, payload, "bad signature"},
+		{"modified header", testEntry{sigTagRSAHeader, sign(key, header), 0},
+			testHeader(testEntry{1000, []byte("b\x00"), 0}), payload, "bad signature"},
+		{"modified payload", testEntry{sigTagPGP, sign(key, header, payload), 0}, header, []byte("modified"), "bad signature"},
+	}
+
+	keyring := filepath.Join(dir, "keyring")
+	if err = writeKeyring(keyring, key); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "primary.xml"), []byte("<metadata></metadata>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newVerifier(&pkginfo.Repo{RPMCache: filepath.Join(dir, "packages"), Keyring: keyring})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tests {
+		// vary the length of the signature header so the header follows
+		// it after every amount of padding
+		for pad := 1; pad <= 8; pad++ {
+			t.Run(fmt.Sprintf("%s/%d", tc.name, pad), func(t *testing.T) {
+				sigs := testHeader(tc.sig, testEntry{1000, make([]byte, pad), 0})
+				path := filepath.Join(dir, "a.rpm")
+				if err := ioutil.WriteFile(path, testRPM(sigs, tc.header, tc.payload), 0644); err != nil {
+					t.Fatal(err)
+				}
+				err := v.verifySignature(path)
+				if tc.err == "" && err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if tc.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.err)) {
+					t.Errorf("expected error %q, got %v", tc.err, err)
+				}
+			})
+		}
+	}
+}
+
+func TestDownloadAllRPMsVerify(t *testing.T) {
+	good := []byte("good rpm")
+	tests := []struct {
+		name     string
+		cached   string
+		corrupt  int
+		requests int
+		err      bool
+	}{
+		{"valid", "", 0, 1, false},
+		{"retried", "", verifyAttempts - 1, verifyAttempts, false},
+		{"corrupt", "", verifyAttempts, verifyAttempts, true},
+		{"cached", string(good), 0, 0, false},
+		{"cached corrupt", "corrupt rpm", 0, 1, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer func() {
+				_ = os.RemoveAll(dir)
+			}()
+			if err := os.MkdirAll(filepath.Join(dir, "packages"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tc.cached != "" {
+				err := ioutil.WriteFile(filepath.Join(dir, "packages", "a.rpm"), []byte(tc.cached), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= tc.corrupt {
+					_, _ = w.Write([]byte("corrupt rpm"))
+					return
+				}
+				_, _ = w.Write(good)
+			}))
+			defer ts.Close()
+
+			v := &verifier{checksums: map[string]pkginfo.RepodataChecksum{"a.rpm": {Type: "sha256", Value: sha256sum(good)}}}
+			err := downloadAllRPMs([]string{ts.URL + "/a.rpm"}, filepath.Join(dir, "packages"), v)
+			if tc.err && (err == nil || !strings.Contains(err.Error(), "1 RPMs failed verification")) {
+				t.Errorf("expected verification failure, got %v", err)
+			}
+			if !tc.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if requests != tc.requests {
+				t.Errorf("expected %d requests, got %d", tc.requests, requests)
+			}
+
+			_, err = os.Stat(filepath.Join(dir, "packages", "a.rpm"))
+			if tc.err && !os.IsNotExist(err) {
+				t.Errorf("expected corrupt rpm to be deleted, got %v", err)
+			}
+			if !tc.err && err != nil {
+				t.Errorf("expected rpm to be downloaded, got %v", err)
+			}
+		})
+	}
+}
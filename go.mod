@@ -13,6 +13,6 @@ require (
 	github.com/rafaeljusto/redigomock v0.0.0-20181020085750-2c62053f7724
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.3 // indirect
-	golang.org/x/crypto v0.0.0-20181126163421-e657309f52e7 // indirect
+	golang.org/x/crypto v0.0.0-20181126163421-e657309f52e7
 	gopkg.in/yaml.v2 v2.2.1 // indirect
 )
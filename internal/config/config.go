@@ -17,6 +17,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -34,19 +35,68 @@ type mixConfig struct {
 	MixWorkSpace string `toml:"workspace"`
 }
 
-// pathConfig defines paths to various data used by diva
+// pathConfig defines paths to various data used by diva. GPGKeyring is the
+// file of armored public keys downloaded RPMs must be signed with, their
+// signatures are not checked when it is empty.
 type pathConfig struct {
 	BundleDefsRepo string `toml:"bundle_repository"`
 	LocalRPMRepo   string `toml:"local_rpms"`
 	CacheLocation  string `toml:"cache"`
+	GPGKeyring     string `toml:"gpg_keyring"`
+}
+
+// DatabaseConfig defines the database backend used to store imported data.
+// Backend may be "redis" or "file". Path is only used by the file backend and
+// defaults to <cache>/db when empty. The remaining fields configure the
+// connection to the redis-server; Socket takes precedence over Address when
+// set, and timeouts are in seconds with 0 meaning no timeout. If AutoStart is
+// set and no server is reachable, a private redis-server is started on a unix
+// socket under the cache location. BatchSize is the number of write commands
+// sent to the redis-server in a single round trip during imports.
+// LockTimeout is the lease in seconds of the lock held on a dataset during
+// its import. The lease is renewed while the import runs, so a lock that is
+// not renewed for that long was left behind by a dead process and is taken
+// over.
+type DatabaseConfig struct {
+	Backend        string `toml:"backend"`
+	Path           string `toml:"path"`
+	AutoStart      bool   `toml:"autostart"`
+	Address        string `toml:"address"`
+	Socket         string `toml:"socket"`
+	Password       string `toml:"password"`
+	DB             int    `toml:"db"`
+	ConnectTimeout int    `toml:"connect_timeout"`
+	ReadTimeout    int    `toml:"read_timeout"`
+	WriteTimeout   int    `toml:"write_timeout"`
+	BatchSize      int    `toml:"batch_size"`
+	LockTimeout    int    `toml:"lock_timeout"`
+}
+
+// DownloadConfig defines how files are downloaded. Requests that fail are
+// retried Retries times, waiting Backoff seconds before the first retry and
+// twice as long before every further one. Timeout is the number of seconds a
+// request may wait for a connection, a response, or more data, 0 meaning no
+// timeout. Jobs is the number of files downloaded or checked at once, 0
+// meaning the default of each step. RateLimit is the bandwidth all downloads
+// share in bytes per second with an optional K, M, or G suffix, e.g. "10M",
+// empty meaning no limit.
+type DownloadConfig struct {
+	Retries   int    `toml:"retries"`
+	Backoff   int    `toml:"backoff"`
+	Timeout   int    `toml:"timeout"`
+	Jobs      int    `toml:"jobs"`
+	RateLimit string `toml:"rate_limit"`
 }
 
 // Config struct that defines the layout of the configuration file
 type Config struct {
-	Mixer         mixConfig  `toml:"mixer"`
-	Paths         pathConfig `toml:"paths"`
-	UpstreamURL   string     `toml:"upstream_url"`
-	BundleDefsURL string     `toml:"bundles_url"`
+	Mixer         mixConfig      `toml:"mixer"`
+	Paths         pathConfig     `toml:"paths"`
+	Database      DatabaseConfig `toml:"database"`
+	Download      DownloadConfig `toml:"download"`
+	UpstreamURL   string         `toml:"upstream_url"`
+	Mirrors       []string       `toml:"mirrors"`
+	BundleDefsURL string         `toml:"bundles_url"`
 }
 
 // FetchingFlags are the command line flags used by the download, import, and
@@ -65,6 +115,8 @@ type FetchingFlags struct {
 	BundleCache     string
 	Update          bool
 	Recursive       bool
+	Arch            string
+	Metadata        bool
 }
 
 // UInfo contains information used by all commands that is defaulted with
@@ -84,6 +136,8 @@ type UInfo struct {
 	BundleCache string
 	Recursive   bool
 	MinVer      uint
+	Arch        string
+	Metadata    bool
 }
 
 // UpdateConfigInstance modifies the default config instance values with any
@@ -124,6 +178,8 @@ func NewUinfo(flags FetchingFlags, conf *Config) UInfo {
 		RPMCache:    flags.RPMCache,
 		BundleURL:   flags.BundleURL,
 		BundleCache: flags.BundleCache,
+		Arch:        flags.Arch,
+		Metadata:    flags.Metadata,
 	}
 	UpdateConfigInstance(conf, u)
 	return u
@@ -140,12 +196,38 @@ func DefaultConf() Config {
 			filepath.Join(ws, "projects/clr-bundles"),
 			filepath.Join(ws, "repo"),
 			filepath.Join(ws, "data"),
+			"",
+		},
+		DatabaseConfig{
+			Backend:        "redis",
+			AutoStart:      true,
+			Address:        ":6379",
+			ConnectTimeout: 10,
+			BatchSize:      1000,
+			LockTimeout:    300,
+		},
+		DownloadConfig{
+			Retries: 3,
+			Backoff: 1,
+			Timeout: 60,
 		},
 		upstreamURL,
+		nil,
 		bundleDefsURL,
 	}
 }
 
+// prependMirror returns the mirrors with url first and not repeated later
+func prependMirror(url string, mirrors []string) []string {
+	prepended := []string{url}
+	for _, m := range mirrors {
+		if strings.TrimSuffix(m, "/") != strings.TrimSuffix(url, "/") {
+			prepended = append(prepended, m)
+		}
+	}
+	return prepended
+}
+
 // ReadConfig reads configuration files on the system from default locations or
 // at the path passed to configPath. The first configuration file found will be
 // read. The configuration file paths are checked in the following order:
@@ -164,11 +246,20 @@ func ReadConfig(configPath string) (*Config, error) {
 		if path == "" {
 			continue
 		}
-		_, err = toml.DecodeFile(path, &c)
+		var md toml.MetaData
+		md, err = toml.DecodeFile(path, &c)
 		// if the file isn't found, try the next one
 		if os.IsNotExist(err) {
 			continue
 		}
+		// the first mirror is the upstream, the others are tried when it
+		// fails. An upstream_url set along with the mirrors is tried first.
+		if err == nil && len(c.Mirrors) > 0 {
+			if md.IsDefined("upstream_url") {
+				c.Mirrors = prependMirror(c.UpstreamURL, c.Mirrors)
+			}
+			c.UpstreamURL = c.Mirrors[0]
+		}
 		// file found, return result of decode
 		return &c, err
 	}
@@ -0,0 +1,369 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DownloadClient downloads files over http(s). Requests that fail with a
+// network error or a server error are retried up to Retries times, waiting
+// Backoff before the first retry and twice as long before every further one.
+// Timeout limits how long a request waits for a connection, a response, or
+// more data, 0 means no limit. Jobs is the number of files callers download
+// at once, see DownloadJobs. RateLimit limits the bytes per second all
+// downloads of the client receive together, 0 means no limit. Mirrors are
+// the upstreams content is fetched from in order, see mirrorURLs.
+type DownloadClient struct {
+	// bytes is the number of bytes downloaded so far, first for the
+	// alignment atomic operations need
+	bytes int64
+
+	Retries   int
+	Backoff   time.Duration
+	Timeout   time.Duration
+	Jobs      int
+	RateLimit int64
+	Mirrors   []string
+	client    *http.Client
+
+	// next is when the rate limit allows the next byte to be received
+	mu   sync.Mutex
+	next time.Time
+
+	// logMu serializes writes to the mirror logs, logs are the mirror logs
+	// written to by directory
+	logMu sync.Mutex
+	logs  map[string]*mirrorLog
+}
+
+// NewDownloadClient returns a DownloadClient with the given retry count,
+// initial backoff, and timeout
+func NewDownloadClient(retries int, backoff, timeout time.Duration) *DownloadClient {
+	return &DownloadClient{
+		Retries: retries,
+		Backoff: backoff,
+		Timeout: timeout,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+				TLSHandshakeTimeout:   timeout,
+				ResponseHeaderTimeout: timeout,
+				MaxIdleConnsPerHost:   16,
+			},
+		},
+	}
+}
+
+// DefaultDownloadClient is the client all downloads of diva go through. The
+// command line tool replaces it with one configured by the download section
+// of the configuration.
+var DefaultDownloadClient = NewDownloadClient(3, time.Second, 60*time.Second)
+
+// DownloadJobs returns the number of files to download or check at once, the
+// Jobs of the DefaultDownloadClient or def if that is not set
+func DownloadJobs(def int) int {
+	if DefaultDownloadClient.Jobs > 0 {
+		return DefaultDownloadClient.Jobs
+	}
+	return def
+}
+
+// BytesDownloaded returns the number of bytes c downloaded so far
+func (c *DownloadClient) BytesDownloaded() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+// throttle waits until receiving n more bytes keeps the downloads of c
+// within its rate limit
+func (c *DownloadClient) throttle(n int) {
+	if c.RateLimit <= 0 || n <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	if c.next.Before(now) {
+		c.next = now
+	}
+	c.next = c.next.Add(time.Duration(int64(n) * int64(time.Second) / c.RateLimit))
+	wait := c.next.Sub(now)
+	c.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// statusError is a request answered with a status other than the expected
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Get %s replied: %d (%s)", e.url, e.code, http.StatusText(e.code))
+}
+
+// retryable reports whether a request that failed with err may succeed when
+// it is sent again. Only server errors and rate limiting are retried, other
+// replies such as 404 will not change.
+func retryable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// retryable, or c.Retries retries have failed
+func (c *DownloadClient) retry(fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || !retryable(err) || attempt >= c.Retries {
+			return err
+		}
+		time.Sleep(c.Backoff << uint(attempt))
+	}
+}
+
+// Get sends a GET request for url, or the same content on the other mirrors
+// if that fails, and returns the first response with the status 200 OK. The
+// caller must close the response body.
+func (c *DownloadClient) Get(url string) (*http.Response, error) {
+	urls, _ := c.mirrorURLs(url)
+	var firstErr error
+	for _, u := range urls {
+		resp, err := c.get(u)
+		if err == nil {
+			return resp, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, mirrorsFailed(firstErr, len(urls))
+}
+
+func (c *DownloadClient) get(url string) (*http.Response, error) {
+	if path, ok := localPath(url); ok {
+		return getLocal(url, path)
+	}
+
+	var resp *http.Response
+	err := c.retry(func() error {
+		var err error
+		if resp, err = c.client.Get(url); err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return &statusError{url, resp.StatusCode}
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// bodyReader reads the body of a response within the rate limit of the
+// client and counts the bytes read. If timer is set it cancels the request
+// when no data has been read for longer than the timeout of the client, time
+// spent waiting for the rate limit does not count.
+type bodyReader struct {
+	c     *DownloadClient
+	r     io.Reader
+	timer *time.Timer
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	atomic.AddInt64(&r.c.bytes, int64(n))
+	r.c.throttle(n)
+	if r.timer != nil {
+		r.timer.Reset(r.c.Timeout)
+	}
+	return n, err
+}
+
+// fetch appends the content of url after the bytes already in the partial
+// file at tmpFile to it, or replaces the partial file if the server does not
+// support ranges
+func (c *DownloadClient) fetch(url, tmpFile string) error {
+	if path, ok := localPath(url); ok {
+		return c.fetchLocal(url, path, tmpFile)
+	}
+
+	out, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var start int64
+		if _, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			// not the range that was asked for, start over
+			_ = out.Truncate(0)
+			return fmt.Errorf("Get %s replied with range %q instead of bytes %d-",
+				url, resp.Header.Get("Content-Range"), offset)
+		}
+	case http.StatusOK:
+		// the whole file, the server ignored the range
+		if err = out.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = out.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is longer than the file, start over
+		_ = out.Truncate(0)
+		return fmt.Errorf("Get %s: partial download of %d bytes is larger than the file", url, offset)
+	default:
+		return &statusError{url, resp.StatusCode}
+	}
+
+	body := &bodyReader{c: c, r: resp.Body}
+	if c.Timeout > 0 {
+		body.timer = time.AfterFunc(c.Timeout, cancel)
+		defer body.timer.Stop()
+	}
+	_, err = io.Copy(out, body)
+	return err
+}
+
+// Download downloads url to filename. The content is written to the
+// temporary file .dl.<filename> first, so if the process is aborted the user
+// is not left with a truncated file. If a request fails part way the next
+// attempt resumes the temporary file with a Range request. Unless overwrite
+// is set, the content at url is expected to never change, so a temporary
+// file left behind by an earlier run is resumed as well; with overwrite a
+// download always starts over. If url is on one of the Mirrors and cannot be
+// downloaded the other mirrors are tried in order, each starting over, since
+// a partial download is only resumed from the mirror that served it. The
+// mirror that served the file is recorded in the mirror log of its
+// directory, see ReadMirrorLog.
+func (c *DownloadClient) Download(url, filename string, overwrite bool) error {
+	tmpFile := filepath.Join(filepath.Dir(filename), ".dl."+filepath.Base(filename))
+	if overwrite {
+		if err := os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	urls, mirrors := c.mirrorURLs(url)
+	var err, firstErr error
+	for i, u := range urls {
+		if i > 0 {
+			if err = os.Remove(tmpFile); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = c.retry(func() error {
+			return c.fetch(u, tmpFile)
+		})
+		if err == nil {
+			if err = c.logMirror(filename, mirrors[i]); err != nil {
+				return err
+			}
+			break
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err != nil {
+		if !retryable(err) {
+			_ = os.Remove(tmpFile)
+		}
+		return mirrorsFailed(firstErr, len(urls))
+	}
+
+	if overwrite {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// move tempfile to final now that everything else has succeeded
+	return renameIfNotExists(tmpFile, filename)
+}
+
+// ParseByteSize parses a number of bytes with an optional K, M, or G suffix
+// for KiB, MiB, or GiB, e.g. "512K". An empty string is 0.
+func ParseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	num, unit := s, int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		unit = 1 << 10
+	case "M":
+		unit = 1 << 20
+	case "G":
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		num = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
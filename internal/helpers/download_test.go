@@ -0,0 +1,153 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// testServer serves testContent, honoring ranges unless ignoreRange is set,
+// after failing the first failures requests with status 500
+func testServer(failures int, ignoreRange bool) (*httptest.Server, *[]string) {
+	var ranges []string
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path != "/file" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var start int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err == nil && !ignoreRange {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(testContent)-1, len(testContent)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte(testContent[start:]))
+			return
+		}
+		_, _ = w.Write([]byte(testContent))
+	})), &ranges
+}
+
+func TestDownloadClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	c := NewDownloadClient(2, time.Millisecond, time.Second)
+	tests := []struct {
+		name        string
+		partial     string
+		failures    int
+		ignoreRange bool
+		overwrite   bool
+		ranges      []string
+	}{
+		{"fresh", "", 0, false, false, []string{""}},
+		{"retried", "", 2, false, false, []string{"", "", ""}},
+		{"resumed", testContent[:10], 0, false, false, []string{"bytes=10-"}},
+		{"range ignored", testContent[:10], 0, true, false, []string{"bytes=10-"}},
+		{"overwrite", "stale", 0, false, true, []string{""}},
+	}
+
+	for _, tc := range tests {
+		s, ranges := testServer(tc.failures, tc.ignoreRange)
+		target := filepath.Join(dir, strings.Replace(tc.name, " ", "-", -1))
+		if tc.partial != "" {
+			err = ioutil.WriteFile(filepath.Join(dir, ".dl."+filepath.Base(target)), []byte(tc.partial), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err = c.Download(s.URL+"/file", target, tc.overwrite); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if b, _ := ioutil.ReadFile(target); string(b) != testContent {
+			t.Errorf("%s: unexpected content %q", tc.name, b)
+		}
+		if strings.Join(*ranges, ",") != strings.Join(tc.ranges, ",") {
+			t.Errorf("%s: expected requests with ranges %q but got %q", tc.name, tc.ranges, *ranges)
+		}
+		s.Close()
+	}
+
+	// not found is not retried, and failing with only server errors gives up
+	// after the retries
+	for _, tc := range []struct {
+		path     string
+		failures int
+		requests int
+	}{{"/missing", 0, 1}, {"/file", 5, 3}} {
+		s, ranges := testServer(tc.failures, false)
+		if err = c.Download(s.URL+tc.path, filepath.Join(dir, "failed"), false); err == nil {
+			t.Errorf("expected download of %s to fail", tc.path)
+		}
+		if len(*ranges) != tc.requests {
+			t.Errorf("expected %d requests for %s but got %d", tc.requests, tc.path, len(*ranges))
+		}
+		s.Close()
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"":     0,
+		"100":  100,
+		"512K": 512 << 10,
+		"10m":  10 << 20,
+		"2G":   2 << 30,
+	}
+	for s, expected := range tests {
+		if n, err := ParseByteSize(s); err != nil || n != expected {
+			t.Errorf("expected %q to be %d bytes but got %d, %v", s, expected, n, err)
+		}
+	}
+
+	for _, s := range []string{"M", "ten", "-1K", "1T"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestDownloadClientRateLimit(t *testing.T) {
+	c := NewDownloadClient(0, 0, 0)
+	c.RateLimit = 1 << 20
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		c.throttle(64 << 10)
+	}
+	// the first 256K of a 1M per second limit take a quarter second
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected throttled reads to take a quarter second but took %s", elapsed)
+	}
+}
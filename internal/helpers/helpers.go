@@ -30,76 +30,19 @@ import (
 	"strings"
 )
 
-// Min returns the min of two strings...since go only implements Float64
-func Min(a, b string) string {
-	if a < b {
-		return a
-	}
-	return b
-}
-
-// Max returns the max of two strings...since go only implements Float64
-func Max(a, b string) string {
-	if a > b {
-		return a
-	}
-	return b
-}
-
-// CheckStatus does a simple http.Get on the url and performs a check against the
-// error code. The response body is only returned for StatusOK
+// CheckStatus does a simple GET of the url with the DefaultDownloadClient and
+// performs a check against the error code. The response body is only
+// returned for StatusOK, the caller must close it.
 func CheckStatus(url string) (*http.Response, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return &http.Response{}, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Get %s replied: %d (%s)",
-			url, resp.StatusCode, http.StatusText(resp.StatusCode))
-	}
-	return resp, nil
+	return DefaultDownloadClient.Get(url)
 }
 
 // Download will attempt to download a from URL to the given filename. Does not
 // try to extract the file, simply lays it on disk. Use this function if you
 // know the file at url is not compressed or if you want to download a
-// compressed file as-is.
+// compressed file as-is. See DownloadClient.Download.
 func Download(url, filename string, overwrite bool) error {
-	resp, err := CheckStatus(url)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
-	// write to a temporary file so if the process is aborted the user is
-	// not left with a truncated file
-	tmpFile := filepath.Join(filepath.Dir(filename), ".dl."+filepath.Base(filename))
-	out, err := os.Create(tmpFile)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = out.Close()
-		_ = os.Remove(tmpFile)
-	}()
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
-	}
-
-	if overwrite {
-		err := os.Remove(filename)
-		if err != nil && !os.IsNotExist(err) {
-			return err
-		}
-	}
-
-	// move tempfile to final now that everything else has succeeded
-	return renameIfNotExists(tmpFile, filename)
+	return DefaultDownloadClient.Download(url, filename, overwrite)
 }
 
 func renameIfNotExists(src, dst string) error {
@@ -133,15 +76,24 @@ func DownloadFile(url, target string, overwrite bool) error {
 // gzExtractURL will download a file at the url and extract it to the target
 // location
 func gzExtractURL(url, target string, overwrite bool) error {
-	resp, err := CheckStatus(url)
+	// download to file first, so an interrupted download can be resumed
+	gzFile := target + ".gz"
+	if err := Download(url, gzFile, overwrite); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(gzFile)
+	}()
+
+	in, err := os.Open(gzFile)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		_ = resp.Body.Close()
+		_ = in.Close()
 	}()
 
-	zr, err := gzip.NewReader(resp.Body)
+	zr, err := gzip.NewReader(in)
 	if err != nil {
 		return err
 	}
@@ -286,7 +238,7 @@ func FailIfErr(err error) {
 // GetLatestVersion returns the version value at upstreamURL/latest or an error
 // if unable to do so.
 func GetLatestVersion(upstreamURL string) (string, error) {
-	resp, err := http.Get(upstreamURL + "/latest")
+	resp, err := CheckStatus(upstreamURL + "/latest")
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,248 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MirrorLog is the file in each directory files are downloaded to that
+// records the mirror each file was downloaded from. Every line is the name
+// of a file and the mirror separated by a tab, later lines replace earlier
+// ones for the same file.
+const MirrorLog = ".mirrors"
+
+// localPath returns the path of a file:// url or of a url without a scheme,
+// which is a plain path, and whether url is one of those
+func localPath(url string) (string, bool) {
+	if strings.HasPrefix(url, "file://") {
+		return strings.TrimPrefix(url, "file://"), true
+	}
+	return url, !strings.Contains(url, "://")
+}
+
+// mirrorURLs returns the urls of the content at url on the mirror url is on
+// followed by the other Mirrors in order, and those mirrors. A url that is not
+// on any mirror is only fetched from itself, and its directory is returned as
+// its mirror.
+func (c *DownloadClient) mirrorURLs(url string) ([]string, []string) {
+	on := -1
+	var rest string
+	for i, m := range c.Mirrors {
+		m = strings.TrimSuffix(m, "/")
+		if url == m || strings.HasPrefix(url, m+"/") {
+			on, rest = i, strings.TrimPrefix(url, m)
+			break
+		}
+	}
+	if on < 0 {
+		dir := url
+		if i := strings.LastIndex(url, "/"); i >= 0 {
+			dir = url[:i]
+		}
+		return []string{url}, []string{dir}
+	}
+
+	urls := []string{url}
+	mirrors := []string{strings.TrimSuffix(c.Mirrors[on], "/")}
+	for i, m := range c.Mirrors {
+		if i != on {
+			m = strings.TrimSuffix(m, "/")
+			urls = append(urls, m+rest)
+			mirrors = append(mirrors, m)
+		}
+	}
+	return urls, mirrors
+}
+
+// mirrorsFailed returns the error of the first of n mirrors, noting the
+// others failed too
+func mirrorsFailed(err error, n int) error {
+	if n > 1 {
+		return fmt.Errorf("%v, and %d other mirrors failed", err, n-1)
+	}
+	return err
+}
+
+// getLocal returns a response with the content of the file at path, or an
+// empty body if path is a directory, as a server would reply to a GET of url
+func getLocal(url, path string) (*http.Response, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, &statusError{url, http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	body := io.ReadCloser(f)
+	if fi.IsDir() {
+		_ = f.Close()
+		body = ioutil.NopCloser(strings.NewReader(""))
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Body:          body,
+		ContentLength: fi.Size(),
+	}, nil
+}
+
+// fetchLocal copies the file at path, the location of url, to tmpFile,
+// resuming after the bytes already in tmpFile like fetch does
+func (c *DownloadClient) fetchLocal(url, path, tmpFile string) error {
+	in, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &statusError{url, http.StatusNotFound}
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err = in.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if fi, err := in.Stat(); err != nil || fi.Size() < offset {
+		// the partial file is longer than the file, start over
+		_ = out.Truncate(0)
+		return fmt.Errorf("%s: partial download of %d bytes is larger than the file", url, offset)
+	}
+
+	_, err = io.Copy(out, &bodyReader{c: c, r: in})
+	return err
+}
+
+// mirrorLog counts the lines a DownloadClient appended to the mirror log of a
+// directory since it last compacted it to files lines
+type mirrorLog struct {
+	files    int
+	appended int
+}
+
+// logMirror records that filename was downloaded from mirror in the mirror
+// log of its directory. The log is compacted the first time the client
+// writes to it and whenever it appended more lines than the log had after
+// that, so downloading the same files again does not grow it forever.
+func (c *DownloadClient) logMirror(filename, mirror string) error {
+	c.logMu.Lock()
+	defer c.logMu.Unlock()
+
+	dir := filepath.Dir(filename)
+	if c.logs == nil {
+		c.logs = make(map[string]*mirrorLog)
+	}
+	log, ok := c.logs[dir]
+	if !ok || log.appended > log.files {
+		files, err := compactMirrorLog(dir)
+		if err != nil {
+			return err
+		}
+		log = &mirrorLog{files: files}
+		c.logs[dir] = log
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, MirrorLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(f, "%s\t%s\n", filepath.Base(filename), mirror); err != nil {
+		_ = f.Close()
+		return err
+	}
+	log.appended++
+	return f.Close()
+}
+
+// compactMirrorLog rewrites the mirror log of dir with one line for each file
+// and returns the number of lines left. Files that are gone are kept, as
+// compressed repodata is removed once it is extracted, but still records the
+// mirror the repodata came from.
+func compactMirrorLog(dir string) (int, error) {
+	mirrors, err := ReadMirrorLog(dir)
+	if err != nil || len(mirrors) == 0 {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(mirrors))
+	for name := range mirrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var log bytes.Buffer
+	for _, name := range names {
+		_, _ = fmt.Fprintf(&log, "%s\t%s\n", name, mirrors[name])
+	}
+	tmpFile := filepath.Join(dir, ".dl."+MirrorLog)
+	if err = ioutil.WriteFile(tmpFile, log.Bytes(), 0644); err != nil {
+		return 0, err
+	}
+	return len(names), os.Rename(tmpFile, filepath.Join(dir, MirrorLog))
+}
+
+// ReadMirrorLog returns the mirror each file in dir was downloaded from by
+// file name. A directory without a mirror log has no recorded mirrors.
+func ReadMirrorLog(dir string) (map[string]string, error) {
+	mirrors := make(map[string]string)
+	f, err := os.Open(filepath.Join(dir, MirrorLog))
+	if os.IsNotExist(err) {
+		return mirrors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) == 2 {
+			mirrors[parts[0]] = parts[1]
+		}
+	}
+	return mirrors, scanner.Err()
+}
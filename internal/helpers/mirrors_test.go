@@ -0,0 +1,221 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorURLs(t *testing.T) {
+	c := &DownloadClient{Mirrors: []string{"https://a.example.com/", "file:///srv/www", "/mnt/update"}}
+	tests := []struct {
+		url     string
+		urls    []string
+		mirrors []string
+	}{
+		{"file:///srv/www/update/10/Manifest.MoM",
+			[]string{"file:///srv/www/update/10/Manifest.MoM", "https://a.example.com/update/10/Manifest.MoM", "/mnt/update/update/10/Manifest.MoM"},
+			[]string{"file:///srv/www", "https://a.example.com", "/mnt/update"}},
+		{"https://a.example.com/latest",
+			[]string{"https://a.example.com/latest", "file:///srv/www/latest", "/mnt/update/latest"},
+			[]string{"https://a.example.com", "file:///srv/www", "/mnt/update"}},
+		// urls that are not on a mirror, e.g. from --upstreamurl, are only
+		// fetched from themselves
+		{"https://b.example.com/update/10/Manifest.MoM",
+			[]string{"https://b.example.com/update/10/Manifest.MoM"},
+			[]string{"https://b.example.com/update/10"}},
+		{"/mnt/updates/latest", []string{"/mnt/updates/latest"}, []string{"/mnt/updates"}},
+	}
+
+	for _, tc := range tests {
+		urls, mirrors := c.mirrorURLs(tc.url)
+		if strings.Join(urls, " ") != strings.Join(tc.urls, " ") {
+			t.Errorf("expected %s urls %v but got %v", tc.url, tc.urls, urls)
+		}
+		if strings.Join(mirrors, " ") != strings.Join(tc.mirrors, " ") {
+			t.Errorf("expected %s mirrors %v but got %v", tc.url, tc.mirrors, mirrors)
+		}
+	}
+}
+
+func TestDownloadClientMirrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-mirrors-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	local := filepath.Join(dir, "www")
+	if err = os.Mkdir(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(local, "file"), []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := filepath.Join(dir, "cache")
+	if err = os.Mkdir(cache, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server always fails, so every fetch falls back to the local mirror
+	ts, _ := testServer(100, false)
+	defer ts.Close()
+	c := NewDownloadClient(1, time.Millisecond, time.Second)
+	c.Mirrors = []string{ts.URL, "file://" + local}
+
+	target := filepath.Join(cache, "file")
+	if err = c.Download(ts.URL+"/file", target, false); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := ioutil.ReadFile(target); string(d) != testContent {
+		t.Errorf("expected %q but downloaded %q", testContent, d)
+	}
+
+	// a partial download is resumed from a local mirror as well
+	partial := filepath.Join(cache, "resumed")
+	if err = ioutil.WriteFile(filepath.Join(cache, ".dl.resumed"), []byte(testContent[:10]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Download(local+"/file", partial, false); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := ioutil.ReadFile(partial); string(d) != testContent {
+		t.Errorf("expected %q but resumed %q", testContent, d)
+	}
+
+	log, err := ReadMirrorLog(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log["file"] != "file://"+local || log["resumed"] != local {
+		t.Errorf("unexpected mirror log %v", log)
+	}
+
+	resp, err := c.Get(ts.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || string(d) != testContent {
+		t.Errorf("expected %q from local mirror but got %q, %v", testContent, d, err)
+	}
+
+	if err = c.Download(ts.URL+"/missing", filepath.Join(cache, "missing"), false); err == nil {
+		t.Error("expected error for a file missing from all mirrors")
+	} else if !strings.Contains(err.Error(), "1 other mirrors failed") {
+		t.Errorf("expected the other mirror to be reported but got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(cache, ".dl.missing")); !os.IsNotExist(err) {
+		t.Error("expected partial file of a missing file to be removed")
+	}
+}
+
+func TestDownloadClientMirrorsRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-mirrors-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// the first mirror breaks off every response after other content, which
+	// must not be resumed from the second mirror
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(testContent)))
+		_, _ = w.Write([]byte("XXXXXXXXXX"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer broken.Close()
+	ts, ranges := testServer(0, false)
+	defer ts.Close()
+	c := NewDownloadClient(1, time.Millisecond, time.Second)
+	c.Mirrors = []string{broken.URL, ts.URL}
+
+	target := filepath.Join(dir, "file")
+	if err = c.Download(broken.URL+"/file", target, false); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := ioutil.ReadFile(target); string(d) != testContent {
+		t.Errorf("expected %q but downloaded %q", testContent, d)
+	}
+	if strings.Join(*ranges, ",") != "" {
+		t.Errorf("expected the second mirror to start over but got ranges %q", *ranges)
+	}
+}
+
+func TestLogMirrorCompacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-mirrors-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// a log written before, with a file downloaded from two mirrors
+	old := "a\thttps://a.example.com\nkept\thttps://a.example.com\na\thttps://b.example.com\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, MirrorLog), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &DownloadClient{}
+	for i := 0; i < 100; i++ {
+		for _, name := range []string{"a", "b"} {
+			if err = c.logMirror(filepath.Join(dir, name), fmt.Sprintf("https://%d.example.com", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	d, err := ioutil.ReadFile(filepath.Join(dir, MirrorLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(d), "\n"); lines > 2*3+1 {
+		t.Errorf("expected the mirror log to be compacted but it has %d lines:\n%s", lines, d)
+	}
+
+	log, err := ReadMirrorLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"kept": "https://a.example.com",
+		"a":    "https://99.example.com",
+		"b":    "https://99.example.com",
+	}
+	if len(log) != len(expected) {
+		t.Errorf("expected mirror log %v but got %v", expected, log)
+	}
+	for name, mirror := range expected {
+		if log[name] != mirror {
+			t.Errorf("expected mirror log %v but got %v", expected, log)
+			break
+		}
+	}
+}
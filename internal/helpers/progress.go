@@ -0,0 +1,143 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progress reports are redrawn this often on a terminal, and logged this
+// often otherwise
+const (
+	progressRedraw   = 500 * time.Millisecond
+	progressInterval = 30 * time.Second
+)
+
+// Progress reports the progress of a number of downloads or other items of
+// work on stderr: the items done out of the total, the bytes downloaded by
+// the DefaultDownloadClient since it started, and the estimated time left.
+// On a terminal the report is a status line redrawn in place, otherwise it
+// is logged periodically.
+type Progress struct {
+	done int64
+
+	desc  string
+	total int
+	bytes int64
+	start time.Time
+	out   io.Writer
+	tty   bool
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// NewProgress starts reporting the progress of total items described by desc
+func NewProgress(desc string, total int) *Progress {
+	p := &Progress{
+		desc:  desc,
+		total: total,
+		bytes: DefaultDownloadClient.BytesDownloaded(),
+		start: time.Now(),
+		out:   os.Stderr,
+		tty:   isTerminal(os.Stderr),
+		stop:  make(chan struct{}),
+	}
+
+	interval := progressInterval
+	if p.tty {
+		interval = progressRedraw
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.print()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Done records that n more items are done
+func (p *Progress) Done(n int) {
+	atomic.AddInt64(&p.done, int64(n))
+}
+
+// Finish stops reporting and prints the final report
+func (p *Progress) Finish() {
+	close(p.stop)
+	p.wg.Wait()
+	p.print()
+	if p.tty {
+		_, _ = fmt.Fprintln(p.out)
+	}
+}
+
+// formatBytes formats n bytes in binary units, e.g. "1.5 GiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// report describes the progress so far
+func (p *Progress) report() string {
+	done := atomic.LoadInt64(&p.done)
+	elapsed := time.Since(p.start)
+	bytes := DefaultDownloadClient.BytesDownloaded() - p.bytes
+
+	s := fmt.Sprintf("%s: %d/%d", p.desc, done, p.total)
+	if bytes > 0 {
+		s += fmt.Sprintf(", %s at %s/s", formatBytes(bytes), formatBytes(int64(float64(bytes)/elapsed.Seconds())))
+	}
+	if done > 0 && int(done) < p.total {
+		eta := time.Duration(float64(elapsed) * float64(int64(p.total)-done) / float64(done))
+		s += fmt.Sprintf(", %s left", eta.Round(time.Second))
+	}
+	return s
+}
+
+func (p *Progress) print() {
+	if p.tty {
+		// redraw the status line
+		_, _ = fmt.Fprintf(p.out, "\r    %s\033[K", p.report())
+		return
+	}
+	_, _ = fmt.Fprintf(p.out, "    %s\n", p.report())
+}
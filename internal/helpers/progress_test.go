@@ -0,0 +1,60 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:              "0 B",
+		1023:           "1023 B",
+		1536:           "1.5 KiB",
+		10 << 20:       "10.0 MiB",
+		(5 << 30) / 2:  "2.5 GiB",
+		int64(3) << 40: "3.0 TiB",
+	}
+	for n, expected := range tests {
+		if s := formatBytes(n); s != expected {
+			t.Errorf("expected %d bytes to be %q but got %q", n, expected, s)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := &Progress{
+		desc:  "RPMs",
+		total: 4,
+		start: time.Now().Add(-10 * time.Second),
+		out:   &out,
+		stop:  make(chan struct{}),
+	}
+
+	p.Done(1)
+	if r := p.report(); !strings.HasPrefix(r, "RPMs: 1/4") || !strings.HasSuffix(r, "30s left") {
+		t.Errorf("unexpected report %q", r)
+	}
+
+	p.Done(3)
+	p.Finish()
+	if s := out.String(); !strings.HasPrefix(s, "    RPMs: 4/4") || strings.Contains(s, "left") {
+		t.Errorf("unexpected final report %q", s)
+	}
+}
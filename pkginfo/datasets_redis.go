@@ -0,0 +1,106 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// scanKeys returns all keys in the database matching pattern
+func scanKeys(c redis.Conn, pattern string) ([]string, error) {
+	keys := []string{}
+	cursor := 0
+	for {
+		v, err := redis.Values(c.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []string
+		if _, err = redis.Scan(v, &cursor, &batch); err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// listDatasetsRedis returns all datasets marked complete in the database
+func listDatasetsRedis(c redis.Conn) ([]DatasetInfo, error) {
+	gens, err := redis.StringMap(c.Do("HGETALL", completeKey))
+	if err != nil {
+		return nil, err
+	}
+
+	datasets := []DatasetInfo{}
+	for name, gen := range gens {
+		ds, err := datasetFromName(name)
+		if err != nil {
+			return nil, err
+		}
+		k := generationKeys(ds, gen)
+
+		// the packages of a repo are listed in a separate set, bundles and
+		// manifests are listed in the generation key itself
+		setKey := k.root
+		if ds.Kind == DatasetRepo {
+			setKey = k.packagesKey()
+		}
+		n, err := redis.Int(c.Do("SCARD", setKey))
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := redis.Int64(c.Do("GET", k.importedKey()))
+		if err != nil {
+			return nil, err
+		}
+
+		prov, err := getProvenanceRedis(c, k)
+		if err != nil {
+			return nil, err
+		}
+
+		datasets = append(datasets, DatasetInfo{Dataset: ds, Imported: time.Unix(t, 0), Count: n, Provenance: prov})
+	}
+
+	sortDatasets(datasets)
+	return datasets, nil
+}
+
+// removeDatasetRedis unmarks ds and deletes the generation of its import.
+// The dataset is removed on request, so unlike a replaced generation its
+// generation is not kept for readers that may still use it.
+func removeDatasetRedis(c redis.Conn, ds Dataset, batchSize int) error {
+	k, err := liveKeysRedis(c, ds)
+	if err == redis.ErrNil {
+		return fmt.Errorf("%s not found in database", ds)
+	}
+	if err != nil {
+		return err
+	}
+
+	// unmark the dataset first so it is not used while it is removed
+	if _, err = c.Do("HDEL", completeKey, datasetName(ds)); err != nil {
+		return err
+	}
+	return removeGenerationRedis(c, k.root, batchSize)
+}
@@ -0,0 +1,107 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestListDatasetsRedis(t *testing.T) {
+	repoDs := Dataset{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "B"}
+	repoKeys := generationKeys(repoDs, generationKey("0000000000000001"))
+	bundlesDs := Dataset{Kind: DatasetBundles, Name: "clear", Version: "100"}
+	bundlesKeys := generationKeys(bundlesDs, generationKey("0000000000000002"))
+
+	prov := bytes.Buffer{}
+	err := gob.NewEncoder(&prov).Encode(&Provenance{SourceURI: "https://example.com", DivaVersion: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := redigomock.NewConn()
+	conn.Command("HGETALL", completeKey).ExpectMap(map[string]string{
+		datasetName(repoDs):    repoKeys.root,
+		datasetName(bundlesDs): bundlesKeys.root,
+	})
+	conn.Command("SCARD", repoKeys.packagesKey()).Expect(int64(42))
+	conn.Command("SCARD", bundlesKeys.root).Expect(int64(7))
+	conn.Command("GET", repoKeys.importedKey()).Expect([]byte("1500000000"))
+	conn.Command("GET", bundlesKeys.importedKey()).Expect([]byte("1500000001"))
+	conn.Command("GET", repoKeys.provenanceKey()).Expect(prov.Bytes())
+	// bundles imported without provenance
+	conn.Command("GET", bundlesKeys.provenanceKey()).Expect(nil)
+
+	datasets, err := listDatasetsRedis(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(datasets) != 2 {
+		t.Fatalf("expected 2 datasets but got %d", len(datasets))
+	}
+
+	// sorted by kind within the data group
+	if datasets[0].Kind != DatasetBundles || datasets[0].Count != 7 || datasets[0].Provenance.DivaVersion != "" {
+		t.Errorf("unexpected first dataset %+v", datasets[0])
+	}
+	if datasets[1].Kind != DatasetRepo || datasets[1].Count != 42 || datasets[1].Imported.Unix() != 1500000000 {
+		t.Errorf("unexpected second dataset %+v", datasets[1])
+	}
+	if datasets[1].Provenance.SourceURI != "https://example.com" {
+		t.Errorf("unexpected provenance %+v", datasets[1].Provenance)
+	}
+}
+
+func TestRemoveDatasetRedis(t *testing.T) {
+	ds := Dataset{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "B"}
+	k := generationKeys(ds, generationKey("0123456789abcdef"))
+
+	conn := redigomock.NewConn()
+	conn.Command("HGET", completeKey, datasetName(ds)).Expect([]byte(k.root))
+	unmark := conn.Command("HDEL", completeKey, datasetName(ds)).Expect(int64(1))
+	conn.Command("SCAN", 0, "MATCH", subkeysPattern(k.root), "COUNT", 1000).ExpectSlice(
+		[]byte("0"), []interface{}{[]byte(k.packagesKey()), []byte(k.pkgKey("bash-1-1.x86_64"))})
+	del := conn.GenericCommand("DEL").Expect(int64(1))
+
+	if err := removeDatasetRedis(conn, ds, defaultBatchSize); err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.Stats(unmark) != 1 {
+		t.Error("expected dataset to be unmarked")
+	}
+	if conn.Stats(del) != 3 {
+		t.Errorf("expected 3 keys to be deleted but got %d", conn.Stats(del))
+	}
+}
+
+func TestRemoveMissingDatasetRedis(t *testing.T) {
+	ds := Dataset{Kind: DatasetBundles, Name: "clear", Version: "100"}
+
+	conn := redigomock.NewConn()
+	conn.Command("HGET", completeKey, datasetName(ds)).Expect(nil)
+	unmark := conn.GenericCommand("HDEL").Expect(int64(0))
+
+	if err := removeDatasetRedis(conn, ds, defaultBatchSize); err == nil {
+		t.Error("expected error removing missing dataset")
+	}
+	if conn.Stats(unmark) != 0 {
+		t.Error("expected nothing to be unmarked")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"strings"
+)
+
+// Dependency flags, from rpmds.h. Only the comparison bits describe the
+// version range, the others record where the dependency came from.
+const (
+	DepFlagLess    = 1 << 1
+	DepFlagGreater = 1 << 2
+	DepFlagEqual   = 1 << 3
+	DepFlagPrereq  = 1 << 6
+	DepFlagRpmlib  = 1 << 24
+
+	depFlagCompare = DepFlagLess | DepFlagGreater | DepFlagEqual
+)
+
+// Dependency is a capability required, provided, conflicted, obsoleted,
+// recommended, or suggested by an RPM. EVR is the [epoch:]version[-release]
+// the capability is compared against, empty if any version matches.
+type Dependency struct {
+	Name  string
+	Flags int
+	EVR   string
+}
+
+// Comparison returns the operator of the version range of d, e.g. ">=", or
+// an empty string if any version matches
+func (d Dependency) Comparison() string {
+	op := ""
+	if d.Flags&DepFlagLess != 0 {
+		op += "<"
+	}
+	if d.Flags&DepFlagGreater != 0 {
+		op += ">"
+	}
+	if d.Flags&DepFlagEqual != 0 {
+		op += "="
+	}
+	return op
+}
+
+// IsRpmlib reports whether d is an rpmlib() feature added by rpmbuild rather
+// than a capability provided by a package
+func (d Dependency) IsRpmlib() bool {
+	return d.Flags&DepFlagRpmlib != 0 || strings.HasPrefix(d.Name, "rpmlib(")
+}
+
+// IsRich reports whether d is a boolean dependency such as "(a or b)", which
+// the Resolver does not evaluate
+func (d Dependency) IsRich() bool {
+	return strings.HasPrefix(d.Name, "(")
+}
+
+// String formats d the way rpm -qR does, e.g. "glibc >= 2.27"
+func (d Dependency) String() string {
+	op := d.Comparison()
+	if op == "" || d.EVR == "" {
+		return d.Name
+	}
+	return d.Name + " " + op + " " + d.EVR
+}
+
+// dependencyStrings returns deps formatted by Dependency.String
+func dependencyStrings(deps []Dependency) []string {
+	strs := make([]string, 0, len(deps))
+	for _, d := range deps {
+		strs = append(strs, d.String())
+	}
+	return strs
+}
+
+// dependenciesFromNames returns unversioned dependencies on names, the only
+// data stored for dependencies before their flags and versions were kept
+func dependenciesFromNames(names []string) []Dependency {
+	deps := make([]Dependency, 0, len(names))
+	for _, n := range names {
+		deps = append(deps, Dependency{Name: n})
+	}
+	return deps
+}
@@ -0,0 +1,47 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"testing"
+)
+
+func TestDependencyString(t *testing.T) {
+	tests := []struct {
+		dep      Dependency
+		expected string
+	}{
+		{Dependency{Name: "bash"}, "bash"},
+		{Dependency{Name: "glibc", Flags: DepFlagGreater | DepFlagEqual, EVR: "2.27"}, "glibc >= 2.27"},
+		{Dependency{Name: "foo", Flags: DepFlagLess, EVR: "1:2.0-3"}, "foo < 1:2.0-3"},
+		{Dependency{Name: "foo", Flags: DepFlagEqual | DepFlagPrereq, EVR: "1.0"}, "foo = 1.0"},
+		{Dependency{Name: "/bin/sh", Flags: DepFlagPrereq}, "/bin/sh"},
+	}
+
+	for _, tc := range tests {
+		if s := tc.dep.String(); s != tc.expected {
+			t.Errorf("expected %q but got %q", tc.expected, s)
+		}
+	}
+}
+
+func TestDependencyIsRpmlib(t *testing.T) {
+	if !(Dependency{Name: "rpmlib(CompressedFileNames)", Flags: DepFlagRpmlib | DepFlagLess | DepFlagEqual}).IsRpmlib() {
+		t.Error("expected rpmlib() dependency to be detected")
+	}
+	if (Dependency{Name: "bash"}).IsRpmlib() {
+		t.Error("expected bash not to be an rpmlib() dependency")
+	}
+}
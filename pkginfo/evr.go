@@ -0,0 +1,233 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"strings"
+)
+
+// EVR is the epoch, version, and release of a package or dependency. Epoch
+// and Release are empty if they were not given.
+type EVR struct {
+	Epoch   string
+	Version string
+	Release string
+}
+
+// ParseEVR splits an [epoch:]version[-release] string the way rpm does. The
+// epoch is the leading digits before a ':', the release everything after the
+// last '-'.
+func ParseEVR(s string) EVR {
+	e := EVR{}
+	digits := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if digits >= 0 && s[digits] == ':' {
+		e.Epoch = s[:digits]
+		if e.Epoch == "" {
+			e.Epoch = "0"
+		}
+		s = s[digits+1:]
+	}
+	if i := strings.LastIndex(s, "-"); i >= 0 {
+		e.Release = s[i+1:]
+		s = s[:i]
+	}
+	e.Version = s
+	return e
+}
+
+func (e EVR) String() string {
+	s := e.Version
+	if e.Epoch != "" {
+		s = e.Epoch + ":" + s
+	}
+	if e.Release != "" {
+		s += "-" + e.Release
+	}
+	return s
+}
+
+// Compare returns -1, 0, or 1 if e is older than, the same as, or newer than
+// other. A missing epoch is epoch 0. Releases are only compared if both have
+// one, so a version without a release matches every release of it, the same
+// way rpm matches dependencies.
+func (e EVR) Compare(other EVR) int {
+	if c := Vercmp(epochOrZero(e.Epoch), epochOrZero(other.Epoch)); c != 0 {
+		return c
+	}
+	if c := Vercmp(e.Version, other.Version); c != 0 {
+		return c
+	}
+	if e.Release == "" || other.Release == "" {
+		return 0
+	}
+	return Vercmp(e.Release, other.Release)
+}
+
+func epochOrZero(epoch string) string {
+	if epoch == "" {
+		return "0"
+	}
+	return epoch
+}
+
+// CompareEVR parses and compares two [epoch:]version[-release] strings, see
+// EVR.Compare
+func CompareEVR(a, b string) int {
+	return ParseEVR(a).Compare(ParseEVR(b))
+}
+
+// EVR returns the epoch, version, and release of the RPM
+func (rpm *RPM) EVR() EVR {
+	return EVR{Epoch: rpm.Epoch, Version: rpm.Version, Release: rpm.Release}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isVerSeparator reports whether c only separates segments of a version,
+// anything but letters, digits, '~', and '^'
+func isVerSeparator(c byte) bool {
+	return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^'
+}
+
+// splitSegment splits the leading run of bytes matching is off of s
+func splitSegment(s string, is func(byte) bool) (string, string) {
+	i := 0
+	for i < len(s) && is(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// Vercmp compares two version or release strings with the semantics of rpm's
+// rpmvercmp and returns -1, 0, or 1 if a is older than, the same as, or newer
+// than b.
+//
+// Both are split into runs of digits and runs of letters, everything else
+// only separates them. Numeric segments compare by value and are newer than
+// alphabetic ones, alphabetic segments compare as strings. If all segments
+// match, the string with segments left over is newer. A '~' sorts before
+// everything, even the end of the string, so 1.0~rc1 is older than 1.0. A '^'
+// sorts after the end of the string but before any other segment, so 1.0^git1
+// is newer than 1.0 but older than 1.0.1.
+func Vercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	for len(a) > 0 || len(b) > 0 {
+		_, a = splitSegment(a, isVerSeparator)
+		_, b = splitSegment(b, isVerSeparator)
+
+		aTilde, bTilde := strings.HasPrefix(a, "~"), strings.HasPrefix(b, "~")
+		if aTilde || bTilde {
+			if !aTilde {
+				return 1
+			}
+			if !bTilde {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		aCaret, bCaret := strings.HasPrefix(a, "^"), strings.HasPrefix(b, "^")
+		if aCaret || bCaret {
+			switch {
+			case a == "":
+				return -1
+			case b == "":
+				return 1
+			case !aCaret:
+				return 1
+			case !bCaret:
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		var segA, segB string
+		numeric := isDigit(a[0])
+		if numeric {
+			segA, a = splitSegment(a, isDigit)
+			segB, b = splitSegment(b, isDigit)
+		} else {
+			segA, a = splitSegment(a, isAlpha)
+			segB, b = splitSegment(b, isAlpha)
+		}
+
+		// the segments are of different types, numbers are newer
+		if segB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// Overlaps reports whether the version ranges of d and other intersect, so a
+// provide other satisfies a requirement d of the same name. A dependency
+// without a version matches every version.
+func (d Dependency) Overlaps(other Dependency) bool {
+	if d.Name != other.Name {
+		return false
+	}
+	if d.Comparison() == "" || d.EVR == "" || other.Comparison() == "" || other.EVR == "" {
+		return true
+	}
+
+	c := ParseEVR(d.EVR).Compare(ParseEVR(other.EVR))
+	switch {
+	case c < 0:
+		return d.Flags&DepFlagGreater != 0 || other.Flags&DepFlagLess != 0
+	case c > 0:
+		return d.Flags&DepFlagLess != 0 || other.Flags&DepFlagGreater != 0
+	}
+	return (d.Flags&other.Flags)&depFlagCompare != 0
+}
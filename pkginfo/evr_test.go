@@ -0,0 +1,195 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+// vercmpTests are the cases of rpm's own rpmvercmp test suite
+var vercmpTests = []struct {
+	a, b     string
+	expected int
+}{
+	{"1.0", "1.0", 0},
+	{"1.0", "2.0", -1},
+	{"2.0", "1.0", 1},
+	{"2.0.1", "2.0.1", 0},
+	{"2.0", "2.0.1", -1},
+	{"2.0.1", "2.0", 1},
+	{"2.0.1a", "2.0.1a", 0},
+	{"2.0.1a", "2.0.1", 1},
+	{"2.0.1", "2.0.1a", -1},
+	{"5.5p1", "5.5p1", 0},
+	{"5.5p1", "5.5p2", -1},
+	{"5.5p2", "5.5p1", 1},
+	{"5.5p10", "5.5p10", 0},
+	{"5.5p1", "5.5p10", -1},
+	{"5.5p10", "5.5p1", 1},
+	{"10xyz", "10.1xyz", -1},
+	{"10.1xyz", "10xyz", 1},
+	{"xyz10", "xyz10", 0},
+	{"xyz10", "xyz10.1", -1},
+	{"xyz10.1", "xyz10", 1},
+	{"xyz.4", "xyz.4", 0},
+	{"xyz.4", "8", -1},
+	{"8", "xyz.4", 1},
+	{"xyz.4", "2", -1},
+	{"2", "xyz.4", 1},
+	{"5.5p2", "5.6p1", -1},
+	{"5.6p1", "5.5p2", 1},
+	{"5.6p1", "6.5p1", -1},
+	{"6.5p1", "5.6p1", 1},
+	{"6.0.rc1", "6.0", 1},
+	{"6.0", "6.0.rc1", -1},
+	{"10b2", "10a1", 1},
+	{"10a2", "10b2", -1},
+	{"1.0aa", "1.0aa", 0},
+	{"1.0a", "1.0aa", -1},
+	{"1.0aa", "1.0a", 1},
+	{"10.0001", "10.0001", 0},
+	{"10.0001", "10.1", 0},
+	{"10.1", "10.0001", 0},
+	{"10.0001", "10.0039", -1},
+	{"10.0039", "10.0001", 1},
+	{"4.999.9", "5.0", -1},
+	{"5.0", "4.999.9", 1},
+	{"20101121", "20101121", 0},
+	{"20101121", "20101122", -1},
+	{"20101122", "20101121", 1},
+	{"2_0", "2_0", 0},
+	{"2.0", "2_0", 0},
+	{"2_0", "2.0", 0},
+	{"a", "a", 0},
+	{"a+", "a+", 0},
+	{"a+", "a_", 0},
+	{"a_", "a+", 0},
+	{"+a", "+a", 0},
+	{"+a", "_a", 0},
+	{"_a", "+a", 0},
+	{"+_", "+_", 0},
+	{"_+", "+_", 0},
+	{"_+", "_+", 0},
+	{"+", "_", 0},
+	{"_", "+", 0},
+	{"1.0~rc1", "1.0~rc1", 0},
+	{"1.0~rc1", "1.0", -1},
+	{"1.0", "1.0~rc1", 1},
+	{"1.0~rc1", "1.0~rc2", -1},
+	{"1.0~rc2", "1.0~rc1", 1},
+	{"1.0~rc1~git123", "1.0~rc1~git123", 0},
+	{"1.0~rc1~git123", "1.0~rc1", -1},
+	{"1.0~rc1", "1.0~rc1~git123", 1},
+	{"1.0^", "1.0^", 0},
+	{"1.0^", "1.0", 1},
+	{"1.0", "1.0^", -1},
+	{"1.0^git1", "1.0^git1", 0},
+	{"1.0^git1", "1.0", 1},
+	{"1.0", "1.0^git1", -1},
+	{"1.0^git1", "1.0^git2", -1},
+	{"1.0^git2", "1.0^git1", 1},
+	{"1.0^git1", "1.01", -1},
+	{"1.01", "1.0^git1", 1},
+	{"1.0^20160101", "1.0^20160101", 0},
+	{"1.0^20160101", "1.0.1", -1},
+	{"1.0.1", "1.0^20160101", 1},
+	{"1.0^20160101^git1", "1.0^20160101^git1", 0},
+	{"1.0^20160102", "1.0^20160101^git1", 1},
+	{"1.0^20160101^git1", "1.0^20160102", -1},
+	{"1.0~rc1^git1", "1.0~rc1^git1", 0},
+	{"1.0~rc1^git1", "1.0~rc1", 1},
+	{"1.0~rc1", "1.0~rc1^git1", -1},
+	{"1.0^git1~pre", "1.0^git1~pre", 0},
+	{"1.0^git1", "1.0^git1~pre", 1},
+	{"1.0^git1~pre", "1.0^git1", -1},
+}
+
+func TestVercmp(t *testing.T) {
+	for _, tc := range vercmpTests {
+		if c := Vercmp(tc.a, tc.b); c != tc.expected {
+			t.Errorf("Vercmp(%q, %q) returned %d but expected %d", tc.a, tc.b, c, tc.expected)
+		}
+	}
+}
+
+func TestParseEVR(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected EVR
+	}{
+		{"1.0", EVR{Version: "1.0"}},
+		{"1.0-1", EVR{Version: "1.0", Release: "1"}},
+		{"2:1.0-1", EVR{Epoch: "2", Version: "1.0", Release: "1"}},
+		{":1.0", EVR{Epoch: "0", Version: "1.0"}},
+		{"1.0-rc1-3", EVR{Version: "1.0-rc1", Release: "3"}},
+		{"a:1.0", EVR{Version: "a:1.0"}},
+	}
+
+	for _, tc := range tests {
+		e := ParseEVR(tc.s)
+		if diff := deep.Equal(e, tc.expected); diff != nil {
+			t.Errorf("ParseEVR(%q): %v", tc.s, diff)
+		}
+		if tc.s != ":1.0" && e.String() != tc.s {
+			t.Errorf("expected %q to format as itself but got %q", tc.s, e.String())
+		}
+	}
+}
+
+func TestCompareEVR(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0-1", "1.0-1", 0},
+		{"1.0-2", "1.0-10", -1},
+		{"1:1.0-1", "2.0-1", 1},
+		{"0:1.0-1", "1.0-1", 0},
+		{"1.0", "1.0-5", 0},
+		{"1.0-5", "1.1", -1},
+	}
+
+	for _, tc := range tests {
+		if c := CompareEVR(tc.a, tc.b); c != tc.expected {
+			t.Errorf("CompareEVR(%q, %q) returned %d but expected %d", tc.a, tc.b, c, tc.expected)
+		}
+	}
+}
+
+func TestDependencyOverlaps(t *testing.T) {
+	ge := DepFlagGreater | DepFlagEqual
+	tests := []struct {
+		req, prov Dependency
+		expected  bool
+	}{
+		{Dependency{Name: "foo"}, Dependency{Name: "foo", Flags: DepFlagEqual, EVR: "1.0-1"}, true},
+		{Dependency{Name: "foo", Flags: ge, EVR: "1.0"}, Dependency{Name: "foo"}, true},
+		{Dependency{Name: "foo"}, Dependency{Name: "bar"}, false},
+		{Dependency{Name: "foo", Flags: ge, EVR: "1.0"}, Dependency{Name: "foo", Flags: DepFlagEqual, EVR: "1.0-1"}, true},
+		{Dependency{Name: "foo", Flags: ge, EVR: "2.0"}, Dependency{Name: "foo", Flags: DepFlagEqual, EVR: "1.0-1"}, false},
+		{Dependency{Name: "foo", Flags: DepFlagLess, EVR: "2.0"}, Dependency{Name: "foo", Flags: DepFlagEqual, EVR: "1.0-1"}, true},
+		{Dependency{Name: "foo", Flags: DepFlagLess, EVR: "1.0"}, Dependency{Name: "foo", Flags: DepFlagEqual, EVR: "1.0-1"}, false},
+		{Dependency{Name: "foo", Flags: DepFlagEqual, EVR: "1:1.0"}, Dependency{Name: "foo", Flags: DepFlagEqual, EVR: "1.0-1"}, false},
+		{Dependency{Name: "foo", Flags: DepFlagLess, EVR: "1.0"}, Dependency{Name: "foo", Flags: DepFlagGreater, EVR: "0.5"}, true},
+	}
+
+	for _, tc := range tests {
+		if ok := tc.req.Overlaps(tc.prov); ok != tc.expected {
+			t.Errorf("expected %s overlapping %s to be %v", tc.req, tc.prov, tc.expected)
+		}
+	}
+}
@@ -15,30 +15,33 @@
 package pkginfo
 
 import (
+	"time"
+
 	"github.com/clearlinux/diva/bundle"
-	"github.com/gomodule/redigo/redis"
 )
 
 // ImportBundleDefinitions gets all of the bundle definitions and imports them
 // into the database
 func ImportBundleDefinitions(bundleInfo *BundleInfo) error {
-	bundleDefinitions, err := bundle.GetAll(bundleInfo.BundleCache)
+	s, err := openStore(&bundleInfo.BaseInfo)
 	if err != nil {
 		return err
 	}
-
-	var c redis.Conn
-	if c, err = initRedis(0); err != nil {
-		return err
-	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
 
-	err = storeBundleInfoRedis(c, bundleInfo, &bundleDefinitions)
-	if err != nil {
-		return err
-	}
+	return withImportLock(s, &bundleInfo.BaseInfo, bundleInfo.Dataset(), func() error {
+		bundleInfo.Provenance = newProvenance(bundleInfo.BundleURL, bundleInfo.BundleCache)
+		bundleDefinitions, err := bundle.GetAll(bundleInfo.BundleCache)
+		if err != nil {
+			return err
+		}
+		if err = bundleInfo.Provenance.readBundlesCommit(bundleInfo.BundleCache); err != nil {
+			return err
+		}
+		bundleInfo.Provenance.Finished = time.Now()
 
-	return nil
+		return s.StoreBundles(bundleInfo, &bundleDefinitions)
+	})
 }
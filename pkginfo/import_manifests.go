@@ -17,15 +17,17 @@ package pkginfo
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/clearlinux/mixer-tools/swupd"
-	"github.com/gomodule/redigo/redis"
 )
 
+func momPath(bundleInfo BundleInfo) string {
+	return filepath.Join(bundleInfo.CacheLoc, "update", bundleInfo.Version, "Manifest.MoM")
+}
+
 func getMoM(bundleInfo BundleInfo) (*swupd.Manifest, error) {
-	baseCache := filepath.Join(bundleInfo.CacheLoc, "update")
-	momPath := filepath.Join(baseCache, bundleInfo.Version, "Manifest.MoM")
-	return swupd.ParseManifestFile(momPath)
+	return swupd.ParseManifestFile(momPath(bundleInfo))
 }
 
 func getManifests(bundleInfo BundleInfo) ([]*swupd.Manifest, error) {
@@ -54,18 +56,31 @@ func getManifests(bundleInfo BundleInfo) ([]*swupd.Manifest, error) {
 // ImportManifests gets the manifests from their cached location and stores
 // them into the database
 func ImportManifests(mInfo *ManifestInfo) error {
-	manifests, err := getManifests(mInfo.BundleInfo)
+	s, err := openStore(&mInfo.BaseInfo)
 	if err != nil {
 		return err
 	}
-
-	var c redis.Conn
-	if c, err = initRedis(0); err != nil {
-		return err
-	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
 
-	return storeManifestRedis(c, mInfo, manifests)
+	return withImportLock(s, &mInfo.BaseInfo, mInfo.Dataset(), func() error {
+		mInfo.Provenance = newProvenance(mInfo.UpstreamURL, filepath.Join(mInfo.CacheLoc, "update"))
+		manifests, err := getManifests(mInfo.BundleInfo)
+		if err != nil {
+			return err
+		}
+		if mInfo.Provenance.MoMHash, err = fileSHA256(momPath(mInfo.BundleInfo)); err != nil {
+			return err
+		}
+		for _, m := range manifests {
+			dir := filepath.Join(mInfo.CacheLoc, "update", fmt.Sprint(m.Header.Version))
+			if err = mInfo.Provenance.readMirrors(dir, "Manifest."+m.Name); err != nil {
+				return err
+			}
+		}
+		mInfo.Provenance.Finished = time.Now()
+
+		return s.StoreManifests(mInfo, manifests)
+	})
 }
@@ -17,29 +17,56 @@ package pkginfo
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/cavaliercoder/go-rpm"
-	"github.com/gomodule/redigo/redis"
 )
 
 // ImportAllRPMs imports all RPMs from a given repository. It populates the
-// passed repo with all RPMs imported.
+// passed repo with all RPMs imported. Concurrent imports of the same repo
+// wait for each other.
 func ImportAllRPMs(repo *Repo, update bool) error {
-	var err error
+	return importRepo(repo, false)
+}
 
-	if err = loadRepoFromCache(repo, repo.RPMCache); err != nil {
-		return err
-	}
+// ImportRepoMetadata imports all RPMs from a given repository like
+// ImportAllRPMs, but reads them from the primary.xml and filelists.xml
+// downloaded next to the RPMCache instead of from the RPM files. The
+// provenance of the repo records that its file attributes are missing.
+func ImportRepoMetadata(repo *Repo) error {
+	return importRepo(repo, true)
+}
+
+func importRepo(repo *Repo, repodata bool) error {
+	var err error
 
-	var c redis.Conn
-	if c, err = initRedis(0); err != nil {
+	var s Store
+	if s, err = openStore(&repo.BaseInfo); err != nil {
 		return err
 	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
 
-	return storeRepoInfoRedis(c, repo)
+	return withImportLock(s, &repo.BaseInfo, repo.Dataset(), func() error {
+		repo.Provenance = newProvenance(repo.URI, repo.RPMCache)
+		repo.Provenance.Repodata = repodata
+		if err = loadRepo(repo, repodata); err != nil {
+			return err
+		}
+		if err = repo.Provenance.readRepomd(repomdPath(repo)); err != nil {
+			return err
+		}
+		for _, dir := range []string{filepath.Dir(repo.RPMCache), repo.RPMCache} {
+			if err = repo.Provenance.readMirrors(dir); err != nil {
+				return err
+			}
+		}
+		repo.Provenance.Finished = time.Now()
+
+		return s.StoreRepo(repo)
+	})
 }
 
 // ImportRPM imports a single RPM named <rpm> from a given repo. It adds the
@@ -51,16 +78,18 @@ func ImportRPM(repo *Repo, rpm, path string, update bool) (*RPM, error) {
 		return nil, err
 	}
 
-	var c redis.Conn
-	if c, err = initRedis(0); err != nil {
+	var s Store
+	if s, err = openStore(&repo.BaseInfo); err != nil {
 		return nil, err
 	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
 	for _, r := range repo.Packages {
 		if r.Name == rpm {
-			return r, storeRPMInfoRedis(c, repo, r)
+			return r, withImportLock(s, &repo.BaseInfo, repo.Dataset(), func() error {
+				return s.StoreRPM(repo, r)
+			})
 		}
 	}
 
@@ -95,9 +124,59 @@ func fileFromPackageFile(pkgFI *rpm.FileInfo) *File {
 	}
 }
 
+// dependencyTags are the rpm header tags holding the names, flags, and
+// versions of one kind of dependency
+type dependencyTags struct {
+	names, flags, versions int
+}
+
+var (
+	provideTags   = dependencyTags{1047, 1112, 1113}
+	requireTags   = dependencyTags{1049, 1048, 1050}
+	conflictTags  = dependencyTags{1054, 1053, 1055}
+	obsoleteTags  = dependencyTags{1090, 1114, 1115}
+	recommendTags = dependencyTags{5046, 5048, 5047}
+	suggestTags   = dependencyTags{5049, 5051, 5050}
+)
+
+// dependenciesFromPackage reads the dependencies described by tags from the
+// header of pkg. Unlike the go-rpm accessors it does not panic on headers
+// with fewer flags or versions than names, those are left unset.
+func dependenciesFromPackage(pkg *rpm.PackageFile, tags dependencyTags) []Dependency {
+	names := pkg.GetStrings(1, tags.names)
+	flags := pkg.GetInts(1, tags.flags)
+	versions := pkg.GetStrings(1, tags.versions)
+
+	var deps []Dependency
+	for i, name := range names {
+		d := Dependency{Name: name}
+		if i < len(flags) {
+			d.Flags = int(flags[i])
+		}
+		if i < len(versions) {
+			d.EVR = versions[i]
+		}
+		deps = append(deps, d)
+	}
+	return deps
+}
+
+// buildRequires returns the requirements of a source rpm header without the
+// rpmlib() features rpmbuild adds, which no package provides
+func buildRequires(requires []Dependency) []Dependency {
+	var deps []Dependency
+	for _, d := range requires {
+		if !d.IsRpmlib() {
+			deps = append(deps, d)
+		}
+	}
+	return deps
+}
+
 func rpmFromPackage(pkg *rpm.PackageFile) *RPM {
 	rpm := &RPM{
 		Name:         pkg.Name(),
+		Epoch:        epochString(pkg.Epoch()),
 		Version:      pkg.Version(),
 		Release:      pkg.Release(),
 		Architecture: pkg.Architecture(),
@@ -105,13 +184,17 @@ func rpmFromPackage(pkg *rpm.PackageFile) *RPM {
 		License:      pkg.License(),
 	}
 
-	for _, d := range pkg.Requires() {
-		rpm.Requires = append(rpm.Requires, d.Name())
-	}
-
-	for _, p := range pkg.Provides() {
-		rpm.Provides = append(rpm.Provides, p.Name())
+	rpm.Requires = dependenciesFromPackage(pkg, requireTags)
+	if rpm.SRPMName == "" {
+		// the requirements in the header of a source rpm are what it needs
+		// to build, not to run
+		rpm.BuildRequires, rpm.Requires = buildRequires(rpm.Requires), nil
 	}
+	rpm.Provides = dependenciesFromPackage(pkg, provideTags)
+	rpm.Conflicts = dependenciesFromPackage(pkg, conflictTags)
+	rpm.Obsoletes = dependenciesFromPackage(pkg, obsoleteTags)
+	rpm.Recommends = dependenciesFromPackage(pkg, recommendTags)
+	rpm.Suggests = dependenciesFromPackage(pkg, suggestTags)
 
 	for _, f := range pkg.Files() {
 		rpm.Files = append(rpm.Files, fileFromPackageFile(&f))
@@ -120,9 +203,11 @@ func rpmFromPackage(pkg *rpm.PackageFile) *RPM {
 	return rpm
 }
 
-func appendUniqueRPMName(rpms []*RPM, rpm *RPM) []*RPM {
-	for i := range rpms {
-		if rpms[i].Name == rpm.Name {
+// appendUniqueRPM appends rpm unless an rpm with the same NVRA is in rpms
+func appendUniqueRPM(rpms []*RPM, rpm *RPM) []*RPM {
+	for _, r := range rpms {
+		if r.Name == rpm.Name && r.Version == rpm.Version && r.Release == rpm.Release &&
+			r.Architecture == rpm.Architecture {
 			return rpms
 		}
 	}
@@ -137,7 +222,7 @@ func loadRepoFromCache(repo *Repo, cacheLoc string) error {
 	}
 
 	for i := range rpms {
-		repo.Packages = appendUniqueRPMName(repo.Packages, rpmFromPackage(rpms[i]))
+		repo.Packages = appendUniqueRPM(repo.Packages, rpmFromPackage(rpms[i]))
 	}
 
 	return nil
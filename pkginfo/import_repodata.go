@@ -0,0 +1,330 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cavaliercoder/go-rpm"
+)
+
+// repodataFlags are the dependency flags of the comparison operators in the
+// flags attribute of repodata dependency entries
+var repodataFlags = map[string]int{
+	"EQ": DepFlagEqual,
+	"LT": DepFlagLess,
+	"GT": DepFlagGreater,
+	"LE": DepFlagLess | DepFlagEqual,
+	"GE": DepFlagGreater | DepFlagEqual,
+}
+
+// repodataEntry is a dependency in primary.xml, e.g.
+// <rpm:entry name="glibc" flags="GE" epoch="0" ver="2.27" pre="1"/>
+type repodataEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr"`
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+	Pre   string `xml:"pre,attr"`
+}
+
+// dependency converts e to a Dependency. The repodata lists epoch 0 for
+// versions without an epoch, so it is left out of the EVR like it is in the
+// RPM header.
+func (e repodataEntry) dependency() (Dependency, error) {
+	d := Dependency{Name: e.Name}
+	if e.Flags != "" {
+		flags, ok := repodataFlags[e.Flags]
+		if !ok {
+			return d, fmt.Errorf("unknown flags %s of dependency %s", e.Flags, e.Name)
+		}
+		d.Flags = flags
+	}
+	if e.Pre == "1" {
+		d.Flags |= DepFlagPrereq
+	}
+
+	if e.Ver != "" {
+		d.EVR = e.Ver
+		if e.Epoch != "" && e.Epoch != "0" {
+			d.EVR = e.Epoch + ":" + d.EVR
+		}
+		if e.Rel != "" {
+			d.EVR += "-" + e.Rel
+		}
+	}
+	return d, nil
+}
+
+type repodataEntries struct {
+	Entries []repodataEntry `xml:"entry"`
+}
+
+func (l repodataEntries) dependencies() ([]Dependency, error) {
+	var deps []Dependency
+	for _, e := range l.Entries {
+		d, err := e.dependency()
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, d)
+	}
+	return deps, nil
+}
+
+// RepodataChecksum is the checksum of an RPM file listed in primary.xml, e.g.
+// <checksum type="sha256" pkgid="YES">hash</checksum>
+type RepodataChecksum struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// primaryPackage is a <package> of primary.xml. Only the fields diva stores
+// are decoded, the files are read from filelists.xml, which lists all of
+// them.
+type primaryPackage struct {
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Checksum RepodataChecksum `xml:"checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format struct {
+		License    string          `xml:"license"`
+		SourceRPM  string          `xml:"sourcerpm"`
+		Provides   repodataEntries `xml:"provides"`
+		Requires   repodataEntries `xml:"requires"`
+		Conflicts  repodataEntries `xml:"conflicts"`
+		Obsoletes  repodataEntries `xml:"obsoletes"`
+		Recommends repodataEntries `xml:"recommends"`
+		Suggests   repodataEntries `xml:"suggests"`
+	} `xml:"format"`
+}
+
+func rpmFromPrimary(p *primaryPackage) (*RPM, error) {
+	epoch := 0
+	if p.Version.Epoch != "" {
+		var err error
+		if epoch, err = strconv.Atoi(p.Version.Epoch); err != nil {
+			return nil, fmt.Errorf("invalid epoch %s of %s", p.Version.Epoch, p.Name)
+		}
+	}
+
+	rpm := &RPM{
+		Name:         p.Name,
+		Epoch:        epochString(epoch),
+		Version:      p.Version.Ver,
+		Release:      p.Version.Rel,
+		Architecture: p.Arch,
+		SRPMName:     p.Format.SourceRPM,
+		License:      p.Format.License,
+	}
+
+	var err error
+	for _, deps := range []struct {
+		field   *[]Dependency
+		entries repodataEntries
+	}{
+		{&rpm.Requires, p.Format.Requires},
+		{&rpm.Provides, p.Format.Provides},
+		{&rpm.Conflicts, p.Format.Conflicts},
+		{&rpm.Obsoletes, p.Format.Obsoletes},
+		{&rpm.Recommends, p.Format.Recommends},
+		{&rpm.Suggests, p.Format.Suggests},
+	} {
+		if *deps.field, err = deps.entries.dependencies(); err != nil {
+			return nil, fmt.Errorf("%s: %v", rpm.NVRA(), err)
+		}
+	}
+	if rpm.SRPMName == "" {
+		// the requirements of a source rpm are what it needs to build, not
+		// to run
+		rpm.BuildRequires, rpm.Requires = buildRequires(rpm.Requires), nil
+	}
+
+	return rpm, nil
+}
+
+// filelistsPackage is a <package> of filelists.xml, identified by the pkgid
+// checksum of the package in primary.xml
+type filelistsPackage struct {
+	PkgID string `xml:"pkgid,attr"`
+	Name  string `xml:"name,attr"`
+	Files []struct {
+		Type string `xml:"type,attr"`
+		Name string `xml:",chardata"`
+	} `xml:"file"`
+}
+
+// decodeRepodataPackages calls decode for every <package> element of the
+// repodata file at path, without reading the whole file into memory
+func decodeRepodataPackages(path string, decode func(*xml.Decoder, *xml.StartElement) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	d := xml.NewDecoder(f)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %v", path, err)
+		}
+		if start, ok := t.(xml.StartElement); ok && start.Name.Local == "package" {
+			if err = decode(d, &start); err != nil {
+				return fmt.Errorf("unable to parse %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// loadRepoFromRepodata reads the RPMs of the repo from the primary.xml and
+// filelists.xml in dir. The repodata only lists the names and whether they
+// are directories or ghosts of the files of an RPM, so files are of Type 'D',
+// 'G', or 'F', symlinks included. Their other attributes and actual types are
+// read from the RPM files by LoadPayload.
+func loadRepoFromRepodata(repo *Repo, dir string) error {
+	byPkgID := make(map[string]*RPM)
+	err := decodeRepodataPackages(filepath.Join(dir, "primary.xml"), func(d *xml.Decoder, start *xml.StartElement) error {
+		p := primaryPackage{}
+		if err := d.DecodeElement(&p, start); err != nil {
+			return err
+		}
+		rpm, err := rpmFromPrimary(&p)
+		if err != nil {
+			return err
+		}
+		byPkgID[p.Checksum.Value] = rpm
+		repo.Packages = appendUniqueRPM(repo.Packages, rpm)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return decodeRepodataPackages(filepath.Join(dir, "filelists.xml"), func(d *xml.Decoder, start *xml.StartElement) error {
+		p := filelistsPackage{}
+		if err := d.DecodeElement(&p, start); err != nil {
+			return err
+		}
+		rpm, ok := byPkgID[p.PkgID]
+		if !ok {
+			return fmt.Errorf("package %s %s is not in primary.xml", p.Name, p.PkgID)
+		}
+		for _, f := range p.Files {
+			t := byte('F')
+			switch f.Type {
+			case "dir":
+				t = byte('D')
+			case "ghost":
+				t = byte('G')
+			}
+			rpm.Files = append(rpm.Files, &File{Name: f.Name, Type: t})
+		}
+		return nil
+	})
+}
+
+// loadRepo reads the RPMs of the repo from its repo metadata if repodata is
+// set, otherwise from the RPM files in its RPMCache
+func loadRepo(repo *Repo, repodata bool) error {
+	if repodata {
+		return loadRepoFromRepodata(repo, filepath.Dir(repo.RPMCache))
+	}
+	return loadRepoFromCache(repo, repo.RPMCache)
+}
+
+// RepodataLocations reads the locations of the RPM files of the repo,
+// relative to the repo.URI, by NVRA from the primary.xml next to its
+// RPMCache. The files are not necessarily named after the NVRA of the RPM.
+func RepodataLocations(repo *Repo) (map[string]string, error) {
+	locations := make(map[string]string)
+	err := decodeRepodataPackages(filepath.Join(filepath.Dir(repo.RPMCache), "primary.xml"), func(d *xml.Decoder, start *xml.StartElement) error {
+		p := primaryPackage{}
+		if err := d.DecodeElement(&p, start); err != nil {
+			return err
+		}
+		r := RPM{Name: p.Name, Version: p.Version.Ver, Release: p.Version.Rel, Architecture: p.Arch}
+		if p.Location.Href == "" {
+			return fmt.Errorf("no location listed for %s", r.NVRA())
+		}
+		locations[r.NVRA()] = p.Location.Href
+		return nil
+	})
+	return locations, err
+}
+
+// RepodataChecksums reads the checksums of the RPM files listed in the
+// primary.xml next to the RPMCache of the repo by file name
+func RepodataChecksums(repo *Repo) (map[string]RepodataChecksum, error) {
+	checksums := make(map[string]RepodataChecksum)
+	err := decodeRepodataPackages(filepath.Join(filepath.Dir(repo.RPMCache), "primary.xml"), func(d *xml.Decoder, start *xml.StartElement) error {
+		p := primaryPackage{}
+		if err := d.DecodeElement(&p, start); err != nil {
+			return err
+		}
+		checksums[path.Base(p.Location.Href)] = p.Checksum
+		return nil
+	})
+	return checksums, err
+}
+
+// LoadPayload reads the file attributes of the RPMs of a repo imported from
+// its repo metadata from the RPM files in the RPMCache, which must have been
+// downloaded from their RepodataLocations. It does nothing for repos imported
+// from the RPM files.
+func LoadPayload(repo *Repo) error {
+	if !repo.Provenance.Repodata {
+		return nil
+	}
+
+	locations, err := RepodataLocations(repo)
+	if err != nil {
+		return err
+	}
+	for _, r := range repo.Packages {
+		href, ok := locations[r.NVRA()]
+		if !ok {
+			return fmt.Errorf("%s is not listed in primary.xml", r.NVRA())
+		}
+		pkg, err := rpm.OpenPackageFile(filepath.Join(repo.RPMCache, path.Base(href)))
+		if err != nil {
+			return err
+		}
+		r.Files = nil
+		for _, f := range pkg.Files() {
+			r.Files = append(r.Files, fileFromPackageFile(&f))
+		}
+	}
+	return nil
+}
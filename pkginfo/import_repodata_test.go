@@ -0,0 +1,205 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="2">
+<package type="rpm">
+  <name>bash</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="5.0" rel="12"/>
+  <checksum type="sha256" pkgid="YES">aaaa</checksum>
+  <location href="Packages/bash-5.0-12.x86_64.rpm"/>
+  <format>
+    <rpm:license>GPL-3.0</rpm:license>
+    <rpm:sourcerpm>bash-5.0-12.src.rpm</rpm:sourcerpm>
+    <rpm:provides>
+      <rpm:entry name="bash" flags="EQ" epoch="0" ver="5.0" rel="12"/>
+    </rpm:provides>
+    <rpm:requires>
+      <rpm:entry name="/bin/sh" pre="1"/>
+      <rpm:entry name="glibc" flags="GE" epoch="1" ver="2.27"/>
+    </rpm:requires>
+    <file>/usr/bin/bash</file>
+  </format>
+</package>
+<package type="rpm">
+  <name>bash</name>
+  <arch>src</arch>
+  <version epoch="2" ver="5.0" rel="12"/>
+  <checksum type="sha256" pkgid="YES">bbbb</checksum>
+  <location href="Packages/b/bash-5.0-12.src.rpm"/>
+  <format>
+    <rpm:license>GPL-3.0</rpm:license>
+    <rpm:sourcerpm/>
+    <rpm:requires>
+      <rpm:entry name="ncurses-dev"/>
+    </rpm:requires>
+  </format>
+</package>
+</metadata>
+`
+
+const testFilelists = `<?xml version="1.0" encoding="UTF-8"?>
+<filelists xmlns="http://linux.duke.edu/metadata/filelists" packages="2">
+<package pkgid="aaaa" name="bash" arch="x86_64">
+  <version epoch="0" ver="5.0" rel="12"/>
+  <file>/usr/bin/bash</file>
+  <file type="dir">/usr/share/bash</file>
+  <file type="ghost">/var/log/bash.log</file>
+</package>
+<package pkgid="bbbb" name="bash" arch="src">
+  <version epoch="2" ver="5.0" rel="12"/>
+  <file>bash-5.0.tar.gz</file>
+</package>
+</filelists>
+`
+
+func writeTestRepodata(t *testing.T, primary, filelists string) string {
+	dir, err := ioutil.TempDir("", "diva-repodata-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"primary.xml": primary, "filelists.xml": filelists} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadRepoFromRepodata(t *testing.T) {
+	dir := writeTestRepodata(t, testPrimary, testFilelists)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	repo := &Repo{}
+	if err := loadRepoFromRepodata(repo, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*RPM{
+		{
+			Name:         "bash",
+			Version:      "5.0",
+			Release:      "12",
+			Architecture: "x86_64",
+			SRPMName:     "bash-5.0-12.src.rpm",
+			License:      "GPL-3.0",
+			Requires: []Dependency{
+				{Name: "/bin/sh", Flags: DepFlagPrereq},
+				{Name: "glibc", Flags: DepFlagGreater | DepFlagEqual, EVR: "1:2.27"},
+			},
+			Provides: []Dependency{{Name: "bash", Flags: DepFlagEqual, EVR: "5.0-12"}},
+			Files: []*File{
+				{Name: "/usr/bin/bash", Type: 'F'},
+				{Name: "/usr/share/bash", Type: 'D'},
+				{Name: "/var/log/bash.log", Type: 'G'},
+			},
+		},
+		{
+			Name:          "bash",
+			Epoch:         "2",
+			Version:       "5.0",
+			Release:       "12",
+			Architecture:  "src",
+			License:       "GPL-3.0",
+			BuildRequires: []Dependency{{Name: "ncurses-dev"}},
+			Files:         []*File{{Name: "bash-5.0.tar.gz", Type: 'F'}},
+		},
+	}
+	if diff := deep.Equal(repo.Packages, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRepodataLocations(t *testing.T) {
+	dir := writeTestRepodata(t, testPrimary, testFilelists)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	locations, err := RepodataLocations(&Repo{RPMCache: filepath.Join(dir, "packages")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"bash-5.0-12.x86_64": "Packages/bash-5.0-12.x86_64.rpm",
+		"bash-5.0-12.src":    "Packages/b/bash-5.0-12.src.rpm",
+	}
+	if diff := deep.Equal(locations, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRepodataChecksums(t *testing.T) {
+	dir := writeTestRepodata(t, testPrimary, testFilelists)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	checksums, err := RepodataChecksums(&Repo{RPMCache: filepath.Join(dir, "packages")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]RepodataChecksum{
+		"bash-5.0-12.x86_64.rpm": {"sha256", "aaaa"},
+		"bash-5.0-12.src.rpm":    {"sha256", "bbbb"},
+	}
+	if diff := deep.Equal(checksums, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLoadRepoFromRepodataErrors(t *testing.T) {
+	tests := map[string]struct {
+		primary, filelists string
+	}{
+		"unknown flags": {
+			`<metadata><package><name>a</name><format><requires><entry name="b" flags="XX"/></requires></format></package></metadata>`,
+			`<filelists/>`,
+		},
+		"invalid epoch": {
+			`<metadata><package><name>a</name><version epoch="x" ver="1" rel="1"/></package></metadata>`,
+			`<filelists/>`,
+		},
+		"unknown package": {
+			testPrimary,
+			`<filelists><package pkgid="cccc" name="zsh"/></filelists>`,
+		},
+		"truncated": {
+			testPrimary[:len(testPrimary)/2],
+			testFilelists,
+		},
+	}
+
+	for name, tc := range tests {
+		dir := writeTestRepodata(t, tc.primary, tc.filelists)
+		if err := loadRepoFromRepodata(&Repo{}, dir); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		_ = os.RemoveAll(dir)
+	}
+}
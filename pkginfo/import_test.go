@@ -16,10 +16,15 @@ package pkginfo
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/go-test/deep"
 )
 
-func TestAppendUniqueRPMName(t *testing.T) {
+func TestAppendUniqueRPM(t *testing.T) {
 	rpms := []*RPM{
 		{Name: "0"},
 		{Name: "1"},
@@ -33,7 +38,7 @@ func TestAppendUniqueRPMName(t *testing.T) {
 	}
 
 	for _, r := range rpmsToAppend {
-		rpms = appendUniqueRPMName(rpms, r)
+		rpms = appendUniqueRPM(rpms, r)
 	}
 
 	if len(rpms) != 5 {
@@ -45,4 +50,52 @@ func TestAppendUniqueRPMName(t *testing.T) {
 			t.Errorf("expected %s but got %s", fmt.Sprint(i), r.Name)
 		}
 	}
+
+	// other versions and architectures of a package are kept
+	variants := []*RPM{
+		{Name: "5", Version: "1", Release: "1", Architecture: "x86_64"},
+		{Name: "5", Version: "1", Release: "1", Architecture: "i686"},
+		{Name: "5", Version: "1", Release: "2", Architecture: "x86_64"},
+		{Name: "5", Version: "1", Release: "1", Architecture: "x86_64"},
+	}
+	for _, r := range variants {
+		rpms = appendUniqueRPM(rpms, r)
+	}
+	if len(rpms) != 8 {
+		t.Errorf("expected 8 total RPMs but got %d", len(rpms))
+	}
+}
+
+func TestBuildRequires(t *testing.T) {
+	requires := []Dependency{
+		{Name: "rpmlib(CompressedFileNames)", Flags: DepFlagRpmlib | DepFlagLess | DepFlagEqual, EVR: "3.0.4-1"},
+		{Name: "gcc"},
+		{Name: "pkgconfig(glib-2.0)", Flags: DepFlagGreater | DepFlagEqual, EVR: "2.56"},
+	}
+
+	expected := []Dependency{requires[1], requires[2]}
+	if diff := deep.Equal(buildRequires(requires), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestPopulateRepoFromCacheMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// a cache of a repo fetched without its RPMs holds only repo metadata
+	if err = ioutil.WriteFile(filepath.Join(dir, "primary.xml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, cache := range []string{filepath.Join(dir, "missing"), dir} {
+		repo := &Repo{RPMCache: cache}
+		if err = PopulateRepoFromCache(repo); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be reported missing but got %v", cache, err)
+		}
+	}
 }
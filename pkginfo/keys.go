@@ -0,0 +1,257 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// redisSchema is a version of the layout of keys in the redis database.
+//
+// Schema 1 built keys by concatenating names and versions, so the keys of
+// different datasets could collide, e.g. mix "clear1" version "0" and mix
+// "clear" version "10" both used "clear10B". It was never stored in the
+// database, so a database without a schema version uses schema 1.
+//
+// Schema 2 joins the parts of every key with ':', escaping ':' and '\' in
+// names with a '\', and keeps every key under the diva namespace, so diva can
+// share a redis-server with other applications without touching their keys.
+// Datasets are named
+//
+//	repo:<name>:<version>:<type>:<arch>
+//	bundles:<name>:<version>
+//	manifests:<name>:<version>
+//
+// where repos of source RPMs use the architecture "src". Each import writes
+// its dataset under a new generation, so the data of a dataset is only ever
+// replaced by pointing the dataset at another generation:
+//
+//	diva:schema                          schema version
+//	diva:datasets                        dataset -> generation of its
+//	                                     committed import
+//	diva:importing                       dataset -> generation of its
+//	                                     unfinished import
+//	diva:retired                         sorted set of replaced generations
+//	                                     by the unix time they were replaced
+//	diva:lock:<dataset>                  gob encoded import lock owner
+//
+//	diva:gen:<id>:imported               unix time the import finished
+//	diva:gen:<id>:provenance             gob encoded Provenance
+//
+// A repo generation holds
+//
+//	diva:gen:<id>                        repo URI
+//	  ...:packages                       set of rpm NVRAs
+//	  ...:name:<rpm name>                set of rpm NVRAs
+//	  ...:pkg:<nvra>                     RPM fields, dependencies gob encoded
+//	  ...:pkg:<nvra>:files               file name -> fileN
+//	  ...:pkg:<nvra>:fileN               File fields
+//
+// a bundles generation
+//
+//	diva:gen:<id>                        set of bundle names
+//	  ...:bundle:<bundle>                Definition fields
+//	  ...:bundle:<bundle>:<field>        Header field
+//	  ...:bundle:<bundle>:includes       set of includes, and directPackages
+//	                                     and allPackages sets
+//
+// and a manifests generation holds every manifest its MoM lists, whichever
+// version they were created/changed in
+//
+//	diva:gen:<id>                        set of manifest names
+//	  ...:manifest:<manifest>            Manifest fields
+//	  ...:manifest:<manifest>:Header     gob encoded header
+//	  ...:manifest:<manifest>:Files      file name -> fileN
+//	  ...:manifest:<manifest>:fileN      gob encoded File
+//	  ...:manifest:<manifest>:DeletedFiles
+//	                                     file name -> deletedN
+//	  ...:manifest:<manifest>:deletedN   gob encoded File
+type redisSchema int
+
+const (
+	legacySchema  redisSchema = 1
+	currentSchema redisSchema = 2
+)
+
+const (
+	// schemaKey holds the schema version of the database
+	schemaKey = "diva:schema"
+	// completeKey is the hash mapping each completely imported dataset to
+	// the generation of its last import
+	completeKey = "diva:datasets"
+	// importingKey is the hash mapping each dataset to the generation of an
+	// import that is not committed yet
+	importingKey = "diva:importing"
+	// retiredKey is the sorted set of generations that were replaced by a
+	// newer import, scored by the unix time they were replaced
+	retiredKey = "diva:retired"
+	// divaNamespace prefixes all keys of the current schema
+	divaNamespace = "diva:"
+	// generationNamespace prefixes the keys of each generation
+	generationNamespace = divaNamespace + "gen:"
+	// lockNamespace prefixes the import lock of each dataset
+	lockNamespace = divaNamespace + "lock:"
+)
+
+var keyEscaper = strings.NewReplacer(`\`, `\\`, `:`, `\:`)
+
+// joinKey escapes each part and joins them into a key
+func joinKey(parts ...string) string {
+	for i := range parts {
+		parts[i] = keyEscaper.Replace(parts[i])
+	}
+	return strings.Join(parts, ":")
+}
+
+// splitKey splits a key built by joinKey back into its unescaped parts
+func splitKey(key string) []string {
+	parts := []string{}
+	part := []byte{}
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '\\':
+			if i+1 < len(key) {
+				i++
+			}
+			part = append(part, key[i])
+		case ':':
+			parts = append(parts, string(part))
+			part = []byte{}
+		default:
+			part = append(part, key[i])
+		}
+	}
+	return append(parts, string(part))
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// subkeysPattern returns a SCAN pattern matching all keys below key
+func subkeysPattern(key string) string {
+	return globEscaper.Replace(key) + ":*"
+}
+
+// datasetName returns the name ds is recorded under in the dataset hashes
+// and its lock key
+func datasetName(ds Dataset) string {
+	switch ds.Kind {
+	case DatasetBundles:
+		return joinKey("bundles", ds.Name, ds.Version)
+	case DatasetManifests:
+		return joinKey("manifests", ds.Name, ds.Version)
+	default:
+		return joinKey("repo", ds.Name, ds.Version, ds.Type, repoArch(ds.Type, ds.Arch))
+	}
+}
+
+// datasetFromName returns the dataset named name by datasetName
+func datasetFromName(name string) (Dataset, error) {
+	parts := splitKey(name)
+	switch {
+	case len(parts) == 5 && parts[0] == "repo":
+		return Dataset{Kind: DatasetRepo, Name: parts[1], Version: parts[2], Type: parts[3], Arch: parts[4]}, nil
+	case len(parts) == 3 && parts[0] == "bundles":
+		return Dataset{Kind: DatasetBundles, Name: parts[1], Version: parts[2]}, nil
+	case len(parts) == 3 && parts[0] == "manifests":
+		return Dataset{Kind: DatasetManifests, Name: parts[1], Version: parts[2]}, nil
+	}
+	return Dataset{}, fmt.Errorf("%s is not a dataset name", name)
+}
+
+// lockKey returns the key of the import lock of ds
+func lockKey(ds Dataset) string {
+	return lockNamespace + datasetName(ds)
+}
+
+// generationKey returns the key the generation id is stored under
+func generationKey(id string) string {
+	return generationNamespace + id
+}
+
+// datasetKeys are the keys the data of a dataset is stored under. The root
+// is the key of a generation, or the dataset key of the legacy schema.
+type datasetKeys struct {
+	schema redisSchema
+	ds     Dataset
+	root   string
+}
+
+// generationKeys returns the keys of ds stored in the generation gen
+func generationKeys(ds Dataset, gen string) datasetKeys {
+	return datasetKeys{schema: currentSchema, ds: ds, root: gen}
+}
+
+// legacyKeys returns the keys of ds in the legacy schema
+func legacyKeys(ds Dataset) datasetKeys {
+	root := fmt.Sprintf("%s%s%s", ds.Name, ds.Version, ds.Type)
+	switch ds.Kind {
+	case DatasetBundles:
+		root = fmt.Sprintf("%s%sbundles", ds.Name, ds.Version)
+	case DatasetManifests:
+		root = fmt.Sprintf("%s%smanifests", ds.Name, ds.Version)
+	}
+	return datasetKeys{schema: legacySchema, ds: ds, root: root}
+}
+
+// packagesKey returns the key of the set of packages of a repo
+func (k datasetKeys) packagesKey() string {
+	return k.root + ":packages"
+}
+
+// pkgKey returns the key of a package, which is identified by its name in
+// the legacy schema and by its NVRA since
+func (k datasetKeys) pkgKey(pkg string) string {
+	if k.schema == legacySchema {
+		return fmt.Sprintf("%s:%s", k.root, pkg)
+	}
+	return k.root + ":" + joinKey("pkg", pkg)
+}
+
+// pkgNameKey returns the key of the set of NVRAs of the packages named rpmName
+func (k datasetKeys) pkgNameKey(rpmName string) string {
+	return k.root + ":" + joinKey("name", rpmName)
+}
+
+func (k datasetKeys) bundleKey(bundleName string) string {
+	if k.schema == legacySchema {
+		return fmt.Sprintf("%s:%s", k.root, bundleName)
+	}
+	return k.root + ":" + joinKey("bundle", bundleName)
+}
+
+// manifestKey returns the key of the manifest manifestName, which the legacy
+// schema stored under the version it was created/changed in
+func (k datasetKeys) manifestKey(version, manifestName string) string {
+	if k.schema == legacySchema {
+		return fmt.Sprintf("%s%smanifests:%s", k.ds.Name, version, manifestName)
+	}
+	return k.root + ":" + joinKey("manifest", manifestName)
+}
+
+// provenanceKey returns the key the provenance of the dataset is stored under
+func (k datasetKeys) provenanceKey() string {
+	return k.root + ":provenance"
+}
+
+// importedKey returns the key of the unix time the import finished
+func (k datasetKeys) importedKey() string {
+	return k.root + ":imported"
+}
+
+// inNamespace reports whether key belongs to the current schema
+func inNamespace(key string) bool {
+	return strings.HasPrefix(key, divaNamespace)
+}
@@ -0,0 +1,171 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestRedisKeysDistinct(t *testing.T) {
+	a := Dataset{Kind: DatasetRepo, Name: "clear1", Version: "0", Type: "B"}
+	b := Dataset{Kind: DatasetRepo, Name: "clear", Version: "10", Type: "B"}
+
+	if legacyKeys(a).root != legacyKeys(b).root {
+		t.Error("expected legacy repo keys to collide")
+	}
+
+	if datasetName(a) == datasetName(b) {
+		t.Errorf("expected distinct repo names but both are %s", datasetName(a))
+	}
+
+	a.Kind, b.Kind = DatasetBundles, DatasetBundles
+	if datasetName(a) == datasetName(b) {
+		t.Errorf("expected distinct bundles names but both are %s", datasetName(a))
+	}
+}
+
+func TestJoinKeyEscapes(t *testing.T) {
+	tests := []struct {
+		parts    []string
+		expected string
+	}{
+		{[]string{"repo", "clear", "100", "B"}, "repo:clear:100:B"},
+		{[]string{"repo", "a:b", "1"}, `repo:a\:b:1`},
+		{[]string{"repo", "a", "b:1"}, `repo:a:b\:1`},
+		{[]string{"repo", `a\`, "1"}, `repo:a\\:1`},
+	}
+
+	for _, tc := range tests {
+		if key := joinKey(tc.parts...); key != tc.expected {
+			t.Errorf("expected key %s but got %s", tc.expected, key)
+		}
+	}
+}
+
+func TestManifestKeySchemas(t *testing.T) {
+	ds := Dataset{Kind: DatasetManifests, Name: "clear", Version: "22000"}
+	if k := legacyKeys(ds).manifestKey("21000", "MoM"); k != "clear21000manifests:MoM" {
+		t.Errorf("unexpected legacy manifest key %s", k)
+	}
+
+	// a generation holds every manifest its MoM lists, whichever version
+	// they were created in
+	if k := generationKeys(ds, "diva:gen:1").manifestKey("21000", "MoM"); k != "diva:gen:1:manifest:MoM" {
+		t.Errorf("unexpected manifest key %s", k)
+	}
+}
+
+// expectLegacyScan mocks the scans for legacy keys, returning keys for the
+// patterns they match
+func expectLegacyScan(conn *redigomock.Conn, keys map[string][]string) {
+	for _, pattern := range legacyKeyPatterns {
+		matches := []interface{}{}
+		for _, key := range keys[pattern] {
+			matches = append(matches, []byte(key))
+		}
+		conn.Command("SCAN", 0, "MATCH", pattern, "COUNT", 1000).Expect([]interface{}{[]byte("0"), matches})
+	}
+}
+
+func TestGetSchemaRedis(t *testing.T) {
+	conn := redigomock.NewConn()
+	conn.Command("GET", schemaKey).Expect(nil)
+	expectLegacyScan(conn, nil)
+	set := conn.Command("SETNX", schemaKey, int(currentSchema)).Expect(int64(1))
+
+	v, err := getSchemaRedis(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != currentSchema || conn.Stats(set) != 1 {
+		t.Errorf("expected empty database to be set to schema %d", currentSchema)
+	}
+
+	// keys of other applications do not make a database legacy diva data
+	conn.Clear()
+	conn.Command("GET", schemaKey).Expect(nil)
+	expectLegacyScan(conn, map[string][]string{
+		"*:packages": {"shop:cart:packages", "repo:packages"},
+		"*bundles":   {"gift-bundles"},
+	})
+	set = conn.Command("SETNX", schemaKey, int(currentSchema)).Expect(int64(1))
+	if v, err = getSchemaRedis(conn); err != nil || v != currentSchema || conn.Stats(set) != 1 {
+		t.Errorf("expected shared database to be set to schema %d but got %d, %v", currentSchema, v, err)
+	}
+
+	conn.Clear()
+	conn.Command("GET", schemaKey).Expect(nil)
+	expectLegacyScan(conn, map[string][]string{"*bundles": {"clear10bundles"}})
+	if v, err = getSchemaRedis(conn); err != nil || v != legacySchema {
+		t.Errorf("expected unversioned database to use legacy schema but got %d, %v", v, err)
+	}
+	if err = checkSchemaRedis(conn); err == nil {
+		t.Error("expected legacy schema to be rejected")
+	}
+
+	conn.Clear()
+	conn.Command("GET", schemaKey).Expect([]byte("3"))
+	if err = checkSchemaRedis(conn); err == nil {
+		t.Error("expected newer schema to be rejected")
+	}
+
+	conn.Clear()
+	conn.Command("GET", schemaKey).Expect([]byte("2"))
+	if err = checkSchemaRedis(conn); err != nil {
+		t.Errorf("expected current schema to be accepted but got %v", err)
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	parts := []string{"repo", `a:b\c`, "100", "B", "aarch64"}
+	key := joinKey(append([]string{}, parts...)...)
+
+	got := splitKey(key)
+	if len(got) != len(parts) {
+		t.Fatalf("expected %d parts but got %v", len(parts), got)
+	}
+	for i := range parts {
+		if got[i] != parts[i] {
+			t.Errorf("expected part %q but got %q", parts[i], got[i])
+		}
+	}
+
+	ds, err := datasetFromName(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Kind != DatasetRepo || ds.Name != `a:b\c` || ds.Version != "100" || ds.Type != "B" || ds.Arch != "aarch64" {
+		t.Errorf("unexpected dataset %+v", ds)
+	}
+
+	ds, err = datasetFromName("bundles:clear:100")
+	if err != nil || ds.Kind != DatasetBundles || ds.Name != "clear" || ds.Version != "100" {
+		t.Errorf("unexpected dataset %+v, %v", ds, err)
+	}
+
+	for _, name := range []string{"repo:clear:100:B:x86_64:packages", "diva:bundles:clear:100"} {
+		if _, err = datasetFromName(name); err == nil {
+			t.Errorf("expected error for %s, which is not a dataset name", name)
+		}
+	}
+}
+
+func TestSubkeysPattern(t *testing.T) {
+	if p := subkeysPattern("repo:clear*:100:B"); p != `repo:clear\*:100:B:*` {
+		t.Errorf("unexpected pattern %s", p)
+	}
+}
@@ -25,8 +25,7 @@ import (
 	"github.com/gomodule/redigo/redis"
 )
 
-func getFilesRedis(c redis.Conn, repo *Repo, p *RPM) ([]*File, error) {
-	pkgKey := fmt.Sprintf("%s%s%s:%s", repo.Name, repo.Version, repo.Type, p.Name)
+func getFilesRedis(c redis.Conn, pkgKey string) ([]*File, error) {
 	fIdxsKey := fmt.Sprintf("%s:files", pkgKey)
 	fIdxs, err := redis.Strings(c.Do("HVALS", fIdxsKey))
 	if err != nil {
@@ -52,15 +51,24 @@ func getFilesRedis(c redis.Conn, repo *Repo, p *RPM) ([]*File, error) {
 	return files, nil
 }
 
-func getRPMRedis(c redis.Conn, repo *Repo, rpmName string) (*RPM, error) {
+// getRPMRedis reads the package pkg, its name in the legacy schema and its
+// NVRA since, from the repo keys k
+func getRPMRedis(c redis.Conn, k datasetKeys, pkg string) (*RPM, error) {
 	var err error
 	p := &RPM{}
-	pkgKey := fmt.Sprintf("%s%s%s:%s", repo.Name, repo.Version, repo.Type, rpmName)
+	pkgKey := k.pkgKey(pkg)
 	p.Name, err = redis.String(c.Do("HGET", pkgKey, "Name"))
 	if err != nil {
 		return nil, err
 	}
 
+	if k.schema != legacySchema {
+		p.Epoch, err = redis.String(c.Do("HGET", pkgKey, "Epoch"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	p.Version, err = redis.String(c.Do("HGET", pkgKey, "Version"))
 	if err != nil {
 		return nil, err
@@ -86,56 +94,106 @@ func getRPMRedis(c redis.Conn, repo *Repo, rpmName string) (*RPM, error) {
 		return nil, err
 	}
 
-	rb, err := redis.Bytes(c.Do("HGET", pkgKey, "Requires"))
-	if err != nil {
-		return nil, err
+	if k.schema == legacySchema {
+		err = getDependencyNamesRedis(c, pkgKey, p)
+	} else {
+		err = getDependenciesRedis(c, pkgKey, p)
 	}
-	p.Requires = strings.Fields(strings.Trim(string(rb), "[]"))
-
-	bb, err := redis.Bytes(c.Do("HGET", pkgKey, "BuildRequires"))
 	if err != nil {
 		return nil, err
 	}
-	p.BuildRequires = strings.Fields(strings.Trim(string(bb), "[]"))
 
-	pb, err := redis.Bytes(c.Do("HGET", pkgKey, "Provides"))
+	p.Files, err = getFilesRedis(c, pkgKey)
 	if err != nil {
 		return nil, err
 	}
-	p.Provides = strings.Fields(strings.Trim(string(pb), "[]"))
 
-	p.Files, err = getFilesRedis(c, repo, p)
-	if err != nil {
-		return nil, err
+	return p, nil
+}
+
+// getDependenciesRedis reads the gob encoded dependencies of p. Fields that
+// are not set are left empty.
+func getDependenciesRedis(c redis.Conn, pkgKey string, p *RPM) error {
+	for _, f := range dependencyFields(p) {
+		v, err := redis.Bytes(c.Do("HGET", pkgKey, f.name))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = gob.NewDecoder(bytes.NewBuffer(v)).Decode(f.deps); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return p, nil
+// getDependencyNamesRedis reads the dependencies of p stored by the legacy
+// schema, which kept only the names of
+// requirements, build requirements, and provides, formatted by fmt as
+// "[name ...]"
+func getDependencyNamesRedis(c redis.Conn, pkgKey string, p *RPM) error {
+	fields := []dependencyField{
+		{"Requires", &p.Requires},
+		{"BuildRequires", &p.BuildRequires},
+		{"Provides", &p.Provides},
+	}
+	for _, f := range fields {
+		v, err := redis.Bytes(c.Do("HGET", pkgKey, f.name))
+		if err != nil {
+			return err
+		}
+		*f.deps = dependenciesFromNames(strings.Fields(strings.Trim(string(v), "[]")))
+	}
+	return nil
 }
 
 // getRepoRedis retrieves all data associated with the given repo from the
-// running redis-server
-func getRepoRedis(c redis.Conn, repo *Repo) error {
-	repoKey := fmt.Sprintf("%s%s%s", repo.Name, repo.Version, repo.Type)
-	pkgsKey := fmt.Sprintf("%s:packages", repoKey)
-	pIdxs, err := redis.Strings(c.Do("SMEMBERS", pkgsKey))
+// running redis-server, using the repo keys k
+func getRepoRedis(c redis.Conn, k datasetKeys, repo *Repo) error {
+	pIdxs, err := redis.Strings(c.Do("SMEMBERS", k.packagesKey()))
 	if err != nil {
 		return err
 	}
 	if len(pIdxs) == 0 {
-		return fmt.Errorf(`no repo data found. Try running "diva fetch repo -v <version>" to populate database`)
+		return errNoRepoData
 	}
 
 	for _, pn := range pIdxs {
-		p, err := getRPMRedis(c, repo, pn)
+		p, err := getRPMRedis(c, k, pn)
 		if err != nil {
 			return err
 		}
-		repo.Packages = appendUniqueRPMName(repo.Packages, p)
+		repo.Packages = appendUniqueRPM(repo.Packages, p)
 	}
 
 	return nil
 }
 
+// getRPMVariantsRedis reads all packages named rpmName through the name index
+// of the repo keys k
+func getRPMVariantsRedis(c redis.Conn, k datasetKeys, repo *Repo, rpmName string) ([]*RPM, error) {
+	nvras, err := redis.Strings(c.Do("SMEMBERS", k.pkgNameKey(rpmName)))
+	if err != nil {
+		return nil, err
+	}
+	if len(nvras) == 0 {
+		return nil, errRPMNotFound(repo, rpmName)
+	}
+
+	rpms := []*RPM{}
+	for _, nvra := range nvras {
+		p, err := getRPMRedis(c, k, nvra)
+		if err != nil {
+			return nil, err
+		}
+		rpms = append(rpms, p)
+	}
+	sortRPMVariants(rpms, repoArch(repo.Type, repo.Arch))
+	return rpms, nil
+}
+
 func getBundleHeader(c redis.Conn, bundleName, bundleKey string) (bundle.Header, error) {
 	var err error
 	header := bundle.Header{}
@@ -168,7 +226,7 @@ func getBundleHeader(c redis.Conn, bundleName, bundleKey string) (bundle.Header,
 	return header, nil
 }
 
-func getBundleRedis(c redis.Conn, bundleInfo *BundleInfo, bundleName string) error {
+func getBundleRedis(c redis.Conn, k datasetKeys, bundleInfo *BundleInfo, bundleName string) error {
 	var err error
 
 	b := &bundle.Definition{
@@ -177,7 +235,7 @@ func getBundleRedis(c redis.Conn, bundleInfo *BundleInfo, bundleName string) err
 		AllPackages:    make(map[string]bool),
 	}
 
-	bundleKey := fmt.Sprintf("%s%sbundles:%s", bundleInfo.Name, bundleInfo.Version, bundleName)
+	bundleKey := k.bundleKey(bundleName)
 
 	b.Name, err = redis.String(c.Do("HGET", bundleKey, "Name"))
 	if err != nil {
@@ -219,29 +277,28 @@ func getBundleRedis(c redis.Conn, bundleInfo *BundleInfo, bundleName string) err
 	bundleInfo.BundleDefinitions[b.Name] = b
 
 	if len(bundleInfo.BundleDefinitions) == 0 {
-		return fmt.Errorf(`no bundle definitions found. Try running "diva fetch bundles -v <version>" to populate database`)
+		return errNoBundleData
 	}
 
 	return nil
 }
 
-func getBundlesRedis(c redis.Conn, bundleInfo *BundleInfo, bundleName string) error {
+func getBundlesRedis(c redis.Conn, k datasetKeys, bundleInfo *BundleInfo, bundleName string) error {
 	if bundleName != "" {
-		return getBundleRedis(c, bundleInfo, bundleName)
+		return getBundleRedis(c, k, bundleInfo, bundleName)
 	}
 
-	bundlesKey := fmt.Sprintf("%s%sbundles", bundleInfo.Name, bundleInfo.Version)
-	bIdxs, err := redis.Strings(c.Do("SMEMBERS", bundlesKey))
+	bIdxs, err := redis.Strings(c.Do("SMEMBERS", k.root))
 	if err != nil {
 		return err
 	}
 
 	if len(bIdxs) == 0 {
-		return fmt.Errorf(`no bundle definitions found. Try running "diva fetch bundles -v <version>" to populate database`)
+		return errNoBundleData
 	}
 
 	for _, bn := range bIdxs {
-		err := getBundleRedis(c, bundleInfo, bn)
+		err := getBundleRedis(c, k, bundleInfo, bn)
 		if err != nil {
 			return err
 		}
@@ -320,24 +377,24 @@ func getManifestRedis(c redis.Conn, manifestKey string) (*swupd.Manifest, error)
 	return &manifest, nil
 }
 
-func getManifestsRedis(c redis.Conn, mInfo *ManifestInfo) error {
-	mIdxs, err := redis.Strings(c.Do("SMEMBERS", fmt.Sprintf("%s%smanifests", mInfo.Name, mInfo.Version)))
+func getManifestsRedis(c redis.Conn, k datasetKeys, mInfo *ManifestInfo) error {
+	mIdxs, err := redis.Strings(c.Do("SMEMBERS", k.root))
 	if err != nil {
 		return err
 	}
 
 	if len(mIdxs) == 0 {
-		return fmt.Errorf(`no manifests found. Try running "diva fetch update -v <version>" to populate database`)
+		return errNoManifestsData
 	}
 
-	momKey := fmt.Sprintf("%s%smanifests:MoM", mInfo.Name, mInfo.Version)
+	momKey := k.manifestKey(mInfo.Version, "MoM")
 	mInfo.MoM, err = getManifestRedis(c, momKey)
 	if err != nil {
 		return err
 	}
 
 	for _, mFile := range mInfo.MoM.Files {
-		manifestKey := fmt.Sprintf("%s%smanifests:%s", mInfo.Name, fmt.Sprint(mFile.Version), mFile.Name)
+		manifestKey := k.manifestKey(fmt.Sprint(mFile.Version), mFile.Name)
 		m, err := getManifestRedis(c, manifestKey)
 		if err != nil {
 			return err
@@ -347,3 +404,29 @@ func getManifestsRedis(c redis.Conn, mInfo *ManifestInfo) error {
 
 	return nil
 }
+
+// getProvenanceRedis returns the provenance stored with the dataset keys k,
+// or an empty one if there is none
+func getProvenanceRedis(c redis.Conn, k datasetKeys) (Provenance, error) {
+	prov := Provenance{}
+	v, err := redis.Bytes(c.Do("GET", k.provenanceKey()))
+	if err == redis.ErrNil {
+		return prov, nil
+	}
+	if err != nil {
+		return prov, err
+	}
+
+	err = gob.NewDecoder(bytes.NewBuffer(v)).Decode(&prov)
+	return prov, err
+}
+
+// liveKeysRedis returns the keys of the generation of the committed import
+// of ds, or redis.ErrNil if ds was never imported
+func liveKeysRedis(c redis.Conn, ds Dataset) (datasetKeys, error) {
+	gen, err := redis.String(c.Do("HGET", completeKey, datasetName(ds)))
+	if err != nil {
+		return datasetKeys{}, err
+	}
+	return generationKeys(ds, gen), nil
+}
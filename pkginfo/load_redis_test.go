@@ -24,6 +24,7 @@ import (
 
 	"github.com/clearlinux/diva/bundle"
 	"github.com/clearlinux/mixer-tools/swupd"
+	"github.com/go-test/deep"
 	"github.com/rafaeljusto/redigomock"
 )
 
@@ -35,31 +36,46 @@ func TestGetRepoRedis(t *testing.T) {
 		},
 		Type: "B",
 	}
-	repoKey := fmt.Sprintf("%s%s%s", repo.Name, repo.Version, repo.Type)
-	pkgsKey := fmt.Sprintf("%s:packages", repoKey)
-	pkgKey := fmt.Sprintf("%s:testpkg", repoKey)
+	k := generationKeys(repo.Dataset(), generationKey("0123456789abcdef"))
+	pkgsKey := fmt.Sprintf("%s:packages", k.root)
+	pkgKey := k.pkgKey("testpkg-100-1.xTEST")
 	fIdxKey := fmt.Sprintf("%s:files", pkgKey)
 	fKey := fmt.Sprintf("%s:file", pkgKey)
 
+	deps := &RPM{
+		Requires: []Dependency{{Name: "libc.so.6()(64bit)"}, {Name: "bash", Flags: DepFlagGreater | DepFlagEqual, EVR: "4.4"}},
+		Provides: []Dependency{{Name: "testpkg", Flags: DepFlagEqual, EVR: "100-1"}},
+	}
+	args, err := dependencyArgs(deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requires, provides := args[1], args[5]
+
 	conn := redigomock.NewConn()
 	cmds := []*redigomock.Cmd{
-		conn.Command("SMEMBERS", pkgsKey).ExpectStringSlice("testpkg"),
+		conn.Command("SMEMBERS", pkgsKey).ExpectStringSlice("testpkg-100-1.xTEST"),
 		// this effectively tests getRPMRedis as well
 		conn.Command("HGET", pkgKey, "Name").Expect("testpkg"),
+		conn.Command("HGET", pkgKey, "Epoch").Expect(""),
 		conn.Command("HGET", pkgKey, "Version").Expect("100"),
 		conn.Command("HGET", pkgKey, "Release").Expect("1"),
 		conn.Command("HGET", pkgKey, "Architecture").Expect("xTEST"),
 		conn.Command("HGET", pkgKey, "SRPMName").Expect("testpkg.src.rpm"),
 		conn.Command("HGET", pkgKey, "License").Expect("license"),
-		conn.Command("HGET", pkgKey, "Requires").Expect([]byte("reqs")),
-		conn.Command("HGET", pkgKey, "BuildRequires").Expect([]byte("breqs")),
-		conn.Command("HGET", pkgKey, "Provides").Expect([]byte("provs")),
+		conn.Command("HGET", pkgKey, "Requires").Expect(requires),
+		conn.Command("HGET", pkgKey, "BuildRequires").Expect(nil),
+		conn.Command("HGET", pkgKey, "Provides").Expect(provides),
+		conn.Command("HGET", pkgKey, "Conflicts").Expect(nil),
+		conn.Command("HGET", pkgKey, "Obsoletes").Expect(nil),
+		conn.Command("HGET", pkgKey, "Recommends").Expect(nil),
+		conn.Command("HGET", pkgKey, "Suggests").Expect(nil),
 		// this effectively tests getFilesRedis as well
 		conn.Command("HVALS", fIdxKey).ExpectStringSlice([]string{"file1", "file2"}...),
 		conn.Command("HGETALL", fKey+"1").ExpectMap(map[string]string{"Name": "f1"}),
 		conn.Command("HGETALL", fKey+"2").ExpectMap(map[string]string{"Name": "f2"}),
 	}
-	err := getRepoRedis(conn, repo)
+	err = getRepoRedis(conn, k, repo)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -81,6 +97,16 @@ func TestGetRepoRedis(t *testing.T) {
 		t.Errorf("RPM was named '%s' but expected testpkg", p.Name)
 	}
 
+	if diff := deep.Equal(p.Requires, deps.Requires); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal(p.Provides, deps.Provides); diff != nil {
+		t.Error(diff)
+	}
+	if len(p.BuildRequires) != 0 {
+		t.Errorf("expected no build requirements but got %v", p.BuildRequires)
+	}
+
 	if len(p.Files) != 2 {
 		// fatal since we access via indices below
 		t.Fatalf("expected 2 files but got %d", len(p.Files))
@@ -100,12 +126,12 @@ func TestGetBundlesRedis(t *testing.T) {
 	bundleInfo.BundleDefinitions = make(bundle.DefinitionsSet)
 
 	bundleName := "testpkg"
-	bundlesKey := fmt.Sprintf("%s%sbundles", bundleInfo.Name, bundleInfo.Version)
-	bundleKey := fmt.Sprintf("%s:%s", bundlesKey, bundleName)
+	k := generationKeys(bundleInfo.Dataset(), generationKey("0123456789abcdef"))
+	bundleKey := k.bundleKey(bundleName)
 
 	conn := redigomock.NewConn()
 	cmds := []*redigomock.Cmd{
-		conn.Command("SMEMBERS", bundlesKey).ExpectStringSlice("testpkg"),
+		conn.Command("SMEMBERS", k.root).ExpectStringSlice("testpkg"),
 		conn.Command("HGET", bundleKey, "Name").Expect("testpkg"),
 		conn.Command("GET", bundleKey+":Title").Expect("testpkg"),
 		conn.Command("GET", bundleKey+":Description").Expect("testDesc"),
@@ -118,7 +144,7 @@ func TestGetBundlesRedis(t *testing.T) {
 	}
 
 	// test single bundle
-	err := getBundlesRedis(conn, bundleInfo, bundleName)
+	err := getBundlesRedis(conn, k, bundleInfo, bundleName)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -136,7 +162,7 @@ func TestGetBundlesRedis(t *testing.T) {
 	}
 
 	// test all bundles
-	err = getBundlesRedis(conn, bundleInfo, "")
+	err = getBundlesRedis(conn, k, bundleInfo, "")
 	if err != nil {
 		t.Fatal(err)
 	}
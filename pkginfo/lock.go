@@ -0,0 +1,234 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/clearlinux/diva/internal/helpers"
+)
+
+// defaultLockTimeout is the lease of an import lock when none is configured
+const defaultLockTimeout = 5 * time.Minute
+
+// lockPollInterval is how often an import waiting for a lock tries again
+var lockPollInterval = time.Second
+
+// lockOwner identifies the process holding the import lock of a dataset.
+// The Token tells apart processes that reuse a PID on the same host.
+type lockOwner struct {
+	Token string
+	PID   int
+	Host  string
+}
+
+func newLockOwner() (lockOwner, error) {
+	token, err := newStagingID()
+	if err != nil {
+		return lockOwner{}, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return lockOwner{}, err
+	}
+	return lockOwner{Token: token, PID: os.Getpid(), Host: host}, nil
+}
+
+func (o lockOwner) String() string {
+	return fmt.Sprintf("PID %d on host %s", o.PID, o.Host)
+}
+
+func encodeLockOwner(o lockOwner) ([]byte, error) {
+	b := bytes.Buffer{}
+	err := gob.NewEncoder(&b).Encode(&o)
+	return b.Bytes(), err
+}
+
+func decodeLockOwner(b []byte) (lockOwner, error) {
+	o := lockOwner{}
+	err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&o)
+	return o, err
+}
+
+func errLockLost(ds Dataset) error {
+	return fmt.Errorf("import lock of %s expired and was taken over by another process", ds)
+}
+
+// leaser takes, renews, and releases the leases of import locks in a Store
+// backend
+type leaser interface {
+	// tryLock takes the lease of ds for owner unless another owner holds a
+	// lease that has not expired, in which case that owner is returned
+	tryLock(ds Dataset, owner lockOwner, ttl time.Duration) (*lockOwner, error)
+	// renewLock extends the lease of ds held by owner, or returns an error if
+	// owner no longer holds it. It is called from a separate goroutine.
+	renewLock(ds Dataset, owner lockOwner, ttl time.Duration) error
+	// unlock releases the lease of ds if it is still held by owner
+	unlock(ds Dataset, owner lockOwner) error
+}
+
+// lease is an import lock held by this process. It is renewed in the
+// background until it is released, and lost if a renewal fails.
+type lease struct {
+	l       leaser
+	ds      Dataset
+	owner   lockOwner
+	ttl     time.Duration
+	done    chan struct{}
+	renewed chan error
+	lost    chan struct{}
+}
+
+// acquireLock waits until the lease of ds is free or has expired and takes
+// it. The lease is renewed in the background until it is released.
+func acquireLock(l leaser, ds Dataset, ttl time.Duration) (*lease, error) {
+	if ttl <= 0 {
+		ttl = defaultLockTimeout
+	}
+
+	owner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	var waitingFor lockOwner
+	for {
+		holder, err := l.tryLock(ds, owner, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if holder == nil {
+			break
+		}
+		if *holder != waitingFor {
+			helpers.PrintBegin("waiting for import of %s by %s", ds, holder)
+			waitingFor = *holder
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	ls := &lease{
+		l:       l,
+		ds:      ds,
+		owner:   owner,
+		ttl:     ttl,
+		done:    make(chan struct{}),
+		renewed: make(chan error, 1),
+		lost:    make(chan struct{}),
+	}
+	go func() {
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-ls.done:
+				ls.renewed <- nil
+				return
+			case <-t.C:
+				if err := l.renewLock(ds, owner, ttl); err != nil {
+					ls.renewed <- err
+					close(ls.lost)
+					return
+				}
+			}
+		}
+	}()
+	return ls, nil
+}
+
+// check returns an error if the lease was lost. Otherwise it renews the
+// lease, so it is still held by the time the commit that follows is done.
+func (ls *lease) check() error {
+	select {
+	case <-ls.lost:
+		return errLockLost(ls.ds)
+	default:
+	}
+	return ls.l.renewLock(ls.ds, ls.owner, ls.ttl)
+}
+
+// release stops renewing the lease and releases it. It returns the error of
+// a failed renewal, if any.
+func (ls *lease) release() error {
+	close(ls.done)
+	err := <-ls.renewed
+	if uerr := ls.l.unlock(ls.ds, ls.owner); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// leases are the import locks a Store holds, so it can check that an import
+// still holds its lock right before committing it
+type leases struct {
+	mu   sync.Mutex
+	held map[string]*lease
+}
+
+// lock takes the import lock of ds with l and returns the function releasing
+// it, see Store.Lock
+func (h *leases) lock(l leaser, ds Dataset, ttl time.Duration) (func() error, error) {
+	ls, err := acquireLock(l, ds, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	if h.held == nil {
+		h.held = make(map[string]*lease)
+	}
+	h.held[lockKey(ds)] = ls
+	h.mu.Unlock()
+
+	return func() error {
+		h.mu.Lock()
+		delete(h.held, lockKey(ds))
+		h.mu.Unlock()
+		return ls.release()
+	}, nil
+}
+
+// check returns an error if the import lock of ds held by the store was
+// lost, see lease.check. Imports of datasets the store holds no lock of, such
+// as migrations, are not checked.
+func (h *leases) check(ds Dataset) error {
+	h.mu.Lock()
+	ls := h.held[lockKey(ds)]
+	h.mu.Unlock()
+	if ls == nil {
+		return nil
+	}
+	return ls.check()
+}
+
+// withImportLock runs the import f of ds while holding its import lock, with
+// the lease configured for b
+func withImportLock(s Store, b *BaseInfo, ds Dataset, f func() error) error {
+	unlock, err := s.Lock(ds, seconds(b.Database.LockTimeout))
+	if err != nil {
+		return err
+	}
+
+	err = f()
+	if uerr := unlock(); err == nil {
+		err = uerr
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// renewLockScript extends the lease of KEYS[1] if it is still held by the
+// owner ARGV[1]
+var renewLockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// unlockScript deletes KEYS[1] if it is still held by the owner ARGV[1]
+var unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// tryLockRedis sets the lock key of ds to owner unless it exists. Redis
+// expires the key once the lease runs out.
+func tryLockRedis(c redis.Conn, ds Dataset, owner lockOwner, ttl time.Duration) (*lockOwner, error) {
+	v, err := encodeLockOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	key := lockKey(ds)
+	for {
+		_, err = redis.String(c.Do("SET", key, v, "NX", "PX", milliseconds(ttl)))
+		if err == nil {
+			return nil, nil
+		}
+		if err != redis.ErrNil {
+			return nil, err
+		}
+
+		b, err := redis.Bytes(c.Do("GET", key))
+		if err == redis.ErrNil {
+			// released or expired in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		holder, err := decodeLockOwner(b)
+		if err != nil {
+			return nil, err
+		}
+		return &holder, nil
+	}
+}
+
+func renewLockRedis(c redis.Conn, ds Dataset, owner lockOwner, ttl time.Duration) error {
+	v, err := encodeLockOwner(owner)
+	if err != nil {
+		return err
+	}
+
+	n, err := redis.Int(renewLockScript.Do(c, lockKey(ds), v, milliseconds(ttl)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errLockLost(ds)
+	}
+	return nil
+}
+
+func unlockRedis(c redis.Conn, ds Dataset, owner lockOwner) error {
+	v, err := encodeLockOwner(owner)
+	if err != nil {
+		return err
+	}
+	_, err = unlockScript.Do(c, lockKey(ds), v)
+	return err
+}
+
+func (s *redisStore) tryLock(ds Dataset, owner lockOwner, ttl time.Duration) (*lockOwner, error) {
+	return tryLockRedis(s.c, ds, owner, ttl)
+}
+
+// renewLock runs concurrently with the import, which uses s.c, so it uses a
+// connection of its own
+func (s *redisStore) renewLock(ds Dataset, owner lockOwner, ttl time.Duration) error {
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	return renewLockRedis(c, ds, owner, ttl)
+}
+
+func (s *redisStore) unlock(ds Dataset, owner lockOwner) error {
+	return unlockRedis(s.c, ds, owner)
+}
+
+func (s *redisStore) Lock(ds Dataset, ttl time.Duration) (func() error, error) {
+	return s.leases.lock(s, ds, ttl)
+}
@@ -0,0 +1,238 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestFileStoreLock(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ds := Dataset{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "B"}
+	unlock, err := s.Lock(ds, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := lockOwner{Token: "other", PID: 1, Host: "elsewhere"}
+	holder, err := s.tryLock(ds, other, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder == nil || holder.PID != os.Getpid() {
+		t.Fatalf("expected lock to be held by this process but got %v", holder)
+	}
+
+	// the lock is not part of the dataset
+	if ok, err := s.IsComplete(ds); err != nil || ok {
+		t.Errorf("expected incomplete dataset but got %v, %v", ok, err)
+	}
+
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err = s.tryLock(ds, other, time.Minute); err != nil || holder != nil {
+		t.Errorf("expected released lock to be taken but got %v, %v", holder, err)
+	}
+}
+
+func TestFileStoreStaleLock(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ds := Dataset{Kind: DatasetBundles, Name: "clear", Version: "100"}
+	dead := lockOwner{Token: "dead", PID: 1, Host: "elsewhere"}
+	if holder, err := s.tryLock(ds, dead, time.Minute); err != nil || holder != nil {
+		t.Fatalf("unable to take lock: %v, %v", holder, err)
+	}
+
+	// the lease of the dead process ran out an hour ago
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(s.lockPath(ds), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := s.Lock(ds, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the dead process lost its lease
+	if err = s.renewLock(ds, dead, time.Minute); err == nil {
+		t.Error("expected renewing a lost lock to fail")
+	}
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockWaits(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	interval := lockPollInterval
+	lockPollInterval = 10 * time.Millisecond
+	defer func() {
+		lockPollInterval = interval
+	}()
+
+	ds := Dataset{Kind: DatasetManifests, Name: "clear", Version: "100"}
+	unlock, err := s.Lock(ds, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		released <- time.Now()
+		_ = unlock()
+	}()
+
+	unlock, err = s.Lock(ds, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Now().Before(<-released) {
+		t.Error("expected lock to be taken after it was released")
+	}
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockRenews(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ds := Dataset{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "SRPM"}
+	unlock, err := s.Lock(ds, 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without renewals the lease would have expired several times over
+	time.Sleep(100 * time.Millisecond)
+	other := lockOwner{Token: "other", PID: 1, Host: "elsewhere"}
+	if holder, err := s.tryLock(ds, other, 30*time.Millisecond); err != nil || holder == nil {
+		t.Errorf("expected lock to still be held but got %v, %v", holder, err)
+	}
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockLostAbortsCommit(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	repo := &Repo{BaseInfo: BaseInfo{Name: "clear", Version: "100"}, Type: "B",
+		Packages: []*RPM{{Name: "bash", Version: "5.0", Release: "1", Architecture: "x86_64"}}}
+	ds := repo.Dataset()
+	unlock, err := s.Lock(ds, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lease expired while the import stalled and another process took it
+	old := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(s.lockPath(ds), old, old); err != nil {
+		t.Fatal(err)
+	}
+	other := lockOwner{Token: "other", PID: 1, Host: "elsewhere"}
+	if holder, err := s.tryLock(ds, other, time.Minute); err != nil || holder != nil {
+		t.Fatalf("unable to take over lock: %v, %v", holder, err)
+	}
+
+	if err = s.StoreRepo(repo); err == nil || err.Error() != errLockLost(ds).Error() {
+		t.Errorf("expected the commit to be aborted but got %v", err)
+	}
+	if ok, err := s.IsComplete(ds); err != nil || ok {
+		t.Errorf("expected the import not to be committed but got %v, %v", ok, err)
+	}
+	_ = unlock()
+}
+
+func TestTryLockRedis(t *testing.T) {
+	ds := Dataset{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "B"}
+	owner := lockOwner{Token: "mine", PID: 2, Host: "here"}
+	holder := lockOwner{Token: "theirs", PID: 1, Host: "there"}
+	ov, err := encodeLockOwner(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hv, err := encodeLockOwner(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := redigomock.NewConn()
+	conn.Command("SET", lockKey(ds), ov, "NX", "PX", int64(60000)).Expect(nil)
+	conn.Command("GET", lockKey(ds)).Expect(hv)
+
+	h, err := tryLockRedis(conn, ds, owner, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h == nil || *h != holder {
+		t.Errorf("expected lock to be held by %v but got %v", holder, h)
+	}
+
+	conn.Clear()
+	conn.Command("SET", lockKey(ds), ov, "NX", "PX", int64(60000)).Expect("OK")
+	if h, err = tryLockRedis(conn, ds, owner, time.Minute); err != nil || h != nil {
+		t.Errorf("expected lock to be taken but got %v, %v", h, err)
+	}
+}
+
+func TestRenewLockRedis(t *testing.T) {
+	ds := Dataset{Kind: DatasetBundles, Name: "clear", Version: "100"}
+	owner := lockOwner{Token: "mine", PID: 2, Host: "here"}
+	ov, err := encodeLockOwner(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := redigomock.NewConn()
+	script := []byte(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+	conn.Script(script, 1, lockKey(ds), ov, int64(60000)).Expect(int64(1))
+	if err = renewLockRedis(conn, ds, owner, time.Minute); err != nil {
+		t.Error(err)
+	}
+
+	conn.Clear()
+	conn.Script(script, 1, lockKey(ds), ov, int64(60000)).Expect(int64(0))
+	if err = renewLockRedis(conn, ds, owner, time.Minute); err == nil {
+		t.Error("expected renewing a lost lock to fail")
+	}
+
+	conn.Clear()
+	conn.Script(script, 1, lockKey(ds), ov, int64(60000)).ExpectError(redis.ErrNil)
+	if err = renewLockRedis(conn, ds, owner, time.Minute); err == nil {
+		t.Error("expected error to be returned")
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/mixer-tools/swupd"
+	"github.com/gomodule/redigo/redis"
+)
+
+// legacy dataset keys are <name><version><suffix>. Versions are numeric, so
+// the name and version are split somewhere in the digits before the suffix.
+// Where is ambiguous for names ending in digits and for versions of more
+// than one digit, which is why the schema was replaced.
+var (
+	legacyRepoRegex      = regexp.MustCompile(`^(.*?)(\d+)(B|SRPM|debug)$`)
+	legacyBundlesRegex   = regexp.MustCompile(`^(.*?)(\d+)bundles$`)
+	legacyManifestsRegex = regexp.MustCompile(`^(.*?)(\d+)manifests$`)
+)
+
+// MigrateStore rewrites all data in the database configured by dbConf into
+// the current layout. It returns the layout versions the database was
+// migrated from and to, which are the same if it was already current.
+func MigrateStore(dbConf *config.DatabaseConfig, cacheLoc string) (int, int, error) {
+	switch dbConf.Backend {
+	case "", BackendRedis:
+		c, err := initRedis(dbConf, cacheLoc)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		from, err := migrateRedis(c, cacheLoc, dbConf.BatchSize)
+		return int(from), int(currentSchema), err
+	case BackendFile:
+		from, err := migrateFileStore(fileStorePath(dbConf, cacheLoc))
+		return from, fileSchema, err
+	default:
+		return 0, 0, fmt.Errorf("unknown database backend %q", dbConf.Backend)
+	}
+}
+
+// migrateFileStore returns the layout of the file store at root. The file
+// store has only ever used the current layout, so there is nothing to
+// rewrite yet.
+func migrateFileStore(root string) (int, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return 0, err
+	}
+
+	s := &fileStore{root: root}
+	from, err := s.schema()
+	if err != nil || from == fileSchema {
+		return from, err
+	}
+	return from, fmt.Errorf("database at %s uses layout %d, but this version of diva only supports layout %d",
+		root, from, fileSchema)
+}
+
+// legacyKey is the key of a dataset stored with the legacy schema, split
+// into the parts around the digits of its name and version
+type legacyKey struct {
+	key    string
+	kind   string
+	prefix string
+	digits string
+	typ    string
+}
+
+// parseLegacyKey returns the legacy dataset key of key if key is a dataset
+// key or the packages set of a legacy repo, and nil otherwise
+func parseLegacyKey(key string) *legacyKey {
+	if inNamespace(key) {
+		return nil
+	}
+
+	if strings.HasSuffix(key, ":packages") {
+		dsKey := strings.TrimSuffix(key, ":packages")
+		if m := legacyRepoRegex.FindStringSubmatch(dsKey); m != nil {
+			return &legacyKey{key: dsKey, kind: DatasetRepo, prefix: m[1], digits: m[2], typ: m[3]}
+		}
+		return nil
+	}
+
+	// the sets listing bundles and manifests are the only keys ending in
+	// bundles and manifests that are not a field of something else
+	if strings.Contains(key, ":") {
+		return nil
+	}
+	if m := legacyBundlesRegex.FindStringSubmatch(key); m != nil {
+		return &legacyKey{key: key, kind: DatasetBundles, prefix: m[1], digits: m[2]}
+	}
+	if m := legacyManifestsRegex.FindStringSubmatch(key); m != nil {
+		return &legacyKey{key: key, kind: DatasetManifests, prefix: m[1], digits: m[2]}
+	}
+	return nil
+}
+
+// candidates returns every dataset the key may belong to. Names are not
+// empty and versions have no leading zeros.
+func (lk *legacyKey) candidates() []Dataset {
+	datasets := []Dataset{}
+	for i := 0; i < len(lk.digits); i++ {
+		name, version := lk.prefix+lk.digits[:i], lk.digits[i:]
+		if name == "" || (len(version) > 1 && version[0] == '0') {
+			continue
+		}
+		datasets = append(datasets, Dataset{Kind: lk.kind, Name: name, Version: version, Type: lk.typ})
+	}
+	return datasets
+}
+
+// legacyKeyPatterns are the SCAN patterns matching the keys parseLegacyKey
+// accepts
+var legacyKeyPatterns = []string{"*:packages", "*bundles", "*manifests"}
+
+// scanLegacyKeysRedis returns the keys in the database that parseLegacyKey
+// accepts
+func scanLegacyKeysRedis(c redis.Conn) ([]string, error) {
+	keys := []string{}
+	for _, pattern := range legacyKeyPatterns {
+		matches, err := scanKeys(c, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range matches {
+			if parseLegacyKey(key) != nil {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// knownLegacyDatasets returns the datasets whose name and version are known
+// despite the legacy keys: manifests, whose MoM records their version, and
+// repos, which were cached under <cacheLoc>/rpms/<name>/<version>
+func knownLegacyDatasets(c redis.Conn, keys []string, cacheLoc string) ([]Dataset, error) {
+	known := []Dataset{}
+	for _, key := range keys {
+		lk := parseLegacyKey(key)
+		if lk == nil || lk.kind != DatasetManifests {
+			continue
+		}
+		header, err := getManifestHeader(c, lk.key+":MoM")
+		if err != nil {
+			continue
+		}
+		version := fmt.Sprint(header.Version)
+		if !strings.HasSuffix(lk.digits, version) {
+			continue
+		}
+		name := lk.prefix + strings.TrimSuffix(lk.digits, version)
+		known = append(known, Dataset{Kind: DatasetManifests, Name: name, Version: version})
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(cacheLoc, "rpms", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		name, version := filepath.Base(filepath.Dir(dir)), filepath.Base(dir)
+		known = append(known, Dataset{Kind: DatasetRepo, Name: name, Version: version})
+	}
+	return known, nil
+}
+
+// findLegacyDatasets returns the datasets stored with the legacy schema
+// among keys, and the legacy dataset keys whose name and version cannot be
+// told apart. A key with more than one candidate dataset is only resolved if
+// exactly one of them has the name and version of one of the known datasets.
+func findLegacyDatasets(keys []string, known []Dataset) ([]Dataset, []string) {
+	isKnown := make(map[[2]string]bool)
+	for _, ds := range known {
+		isKnown[[2]string{ds.Name, ds.Version}] = true
+	}
+
+	datasets := []Dataset{}
+	ambiguous := []string{}
+	for _, key := range keys {
+		lk := parseLegacyKey(key)
+		if lk == nil {
+			continue
+		}
+
+		candidates := lk.candidates()
+		if len(candidates) > 1 {
+			fits := []Dataset{}
+			for _, ds := range candidates {
+				if isKnown[[2]string{ds.Name, ds.Version}] {
+					fits = append(fits, ds)
+				}
+			}
+			candidates = fits
+		}
+		if len(candidates) != 1 {
+			ambiguous = append(ambiguous, lk.key)
+			continue
+		}
+		datasets = append(datasets, candidates[0])
+	}
+
+	sort.Slice(datasets, func(i, j int) bool {
+		return datasets[i].String() < datasets[j].String()
+	})
+	sort.Strings(ambiguous)
+	return datasets, ambiguous
+}
+
+// migrateDatasetRedis reads ds with the legacy schema and imports it again
+// with the current schema. It returns the keys ds was read from, which are
+// removed with the keys below them once all datasets are migrated, as
+// manifests may be shared between datasets. The legacy schema had no
+// completion marker, so a dataset that can be read in full is considered
+// complete.
+func migrateDatasetRedis(c redis.Conn, ds Dataset, batchSize int) ([]string, error) {
+	from := legacyKeys(ds)
+	roots := []string{from.root}
+
+	var store func(p *redisPipeline, k datasetKeys) error
+	base := BaseInfo{Name: ds.Name, Version: ds.Version}
+	switch ds.Kind {
+	case DatasetRepo:
+		repo := &Repo{BaseInfo: base, Type: ds.Type, Arch: ds.Arch}
+		if err := getRepoRedis(c, from, repo); err != nil {
+			return roots, err
+		}
+		uri, err := redis.String(c.Do("GET", from.root))
+		if err != nil && err != redis.ErrNil {
+			return roots, err
+		}
+		repo.URI = uri
+		store = func(p *redisPipeline, k datasetKeys) error {
+			return storeRepoInfoRedis(p, k, repo)
+		}
+
+	case DatasetBundles:
+		bundleInfo := &BundleInfo{BaseInfo: base, BundleDefinitions: make(bundle.DefinitionsSet)}
+		if err := getBundlesRedis(c, from, bundleInfo, ""); err != nil {
+			return roots, err
+		}
+		store = func(p *redisPipeline, k datasetKeys) error {
+			return storeBundleInfoRedis(p, k, &bundleInfo.BundleDefinitions)
+		}
+
+	default:
+		mInfo := &ManifestInfo{
+			BundleInfo: BundleInfo{BaseInfo: base},
+			Manifests:  make(map[string]*swupd.Manifest),
+		}
+		if err := getManifestsRedis(c, from, mInfo); err != nil {
+			return roots, err
+		}
+
+		// files and deleted files of a manifest overwrote each other in the
+		// legacy schema, so those manifests cannot be recovered
+		manifests := []*swupd.Manifest{mInfo.MoM}
+		for _, m := range mInfo.Manifests {
+			if len(m.DeletedFiles) > 0 {
+				return roots, fmt.Errorf("manifest %s %d has deleted files, which the legacy schema did not store correctly",
+					m.Name, m.Header.Version)
+			}
+			manifests = append(manifests, m)
+		}
+		// the manifests listed by the MoM may be stored under other versions
+		for _, f := range mInfo.MoM.Files {
+			roots = append(roots, from.manifestKey(fmt.Sprint(f.Version), f.Name))
+		}
+		store = func(p *redisPipeline, k datasetKeys) error {
+			return storeManifestRedis(p, k, manifests)
+		}
+	}
+
+	return roots, importRedis(c, ds, batchSize, store, nil)
+}
+
+// removeKeysRedis removes each of roots and all keys below them, scanning
+// the database only once. Keys in the diva namespace are never removed.
+func removeKeysRedis(c redis.Conn, roots []string, batchSize int) error {
+	if len(roots) == 0 {
+		return nil
+	}
+	isRoot := make(map[string]bool, len(roots))
+	for _, r := range roots {
+		isRoot[r] = true
+	}
+
+	keys, err := scanKeys(c, "*")
+	if err != nil {
+		return err
+	}
+	p := newRedisPipeline(c, batchSize)
+	for _, key := range keys {
+		if inNamespace(key) {
+			continue
+		}
+		below := isRoot[key]
+		for i := strings.IndexByte(key, ':'); i >= 0 && !below; {
+			below = isRoot[key[:i]]
+			next := strings.IndexByte(key[i+1:], ':')
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+		if !below {
+			continue
+		}
+		if err = p.send("DEL", key); err != nil {
+			return err
+		}
+	}
+	return p.flush()
+}
+
+// migrateRedis rewrites the legacy datasets in the database with the current
+// schema, removes the keys they were stored under, and stores the new schema
+// version. Datasets whose keys cannot be told apart, or that cannot be
+// migrated, are reported and their keys are kept, as they cannot be
+// attributed to diva with certainty. They must be fetched again. It returns
+// the schema the database used before.
+func migrateRedis(c redis.Conn, cacheLoc string, batchSize int) (redisSchema, error) {
+	from, err := getSchemaRedis(c)
+	if err != nil {
+		return 0, err
+	}
+	if from == currentSchema {
+		return from, nil
+	}
+	if from != legacySchema {
+		return from, fmt.Errorf("database uses key schema %d, but this version of diva only supports schema %d", from, currentSchema)
+	}
+
+	keys, err := scanLegacyKeysRedis(c)
+	if err != nil {
+		return from, err
+	}
+	known, err := knownLegacyDatasets(c, keys, cacheLoc)
+	if err != nil {
+		return from, err
+	}
+	datasets, ambiguous := findLegacyDatasets(keys, known)
+	for _, key := range ambiguous {
+		helpers.PrintBegin("skipping %s", key)
+		helpers.PrintComplete("the name and version of the dataset are ambiguous, fetch it again")
+	}
+
+	roots := []string{}
+	for _, ds := range datasets {
+		helpers.PrintBegin("migrating %s", ds)
+		r, err := migrateDatasetRedis(c, ds, batchSize)
+		if err != nil {
+			helpers.PrintComplete("unable to migrate, fetch it again: %v", err)
+			continue
+		}
+		roots = append(roots, r...)
+	}
+	if err = removeKeysRedis(c, roots, batchSize); err != nil {
+		return from, err
+	}
+
+	_, err = c.Do("SET", schemaKey, int(currentSchema))
+	return from, err
+}
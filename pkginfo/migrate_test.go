@@ -0,0 +1,151 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestFindLegacyDatasets(t *testing.T) {
+	keys := []string{
+		"clear100B",
+		"clear100B:packages",
+		"clear100B:bash",
+		"clear100B:bash:files",
+		"mymix20SRPM:packages",
+		"clear100bundles",
+		"clear100bundles:os-core",
+		"clear100bundles:os-core:Title",
+		"clear100manifests",
+		"clear90manifests:os-core",
+		"mix7manifests",
+		"staging:0123:clear100B",
+		"repo:clear:100:B:packages",
+		"diva:repo:clear:100:B:x86_64:packages",
+		"datasets",
+	}
+	known := []Dataset{
+		{Kind: DatasetManifests, Name: "clear", Version: "100"},
+		{Kind: DatasetRepo, Name: "mymix", Version: "20"},
+	}
+
+	expected := []Dataset{
+		{Kind: DatasetBundles, Name: "clear", Version: "100"},
+		{Kind: DatasetManifests, Name: "clear", Version: "100"},
+		{Kind: DatasetManifests, Name: "mix", Version: "7"},
+		{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "B"},
+		{Kind: DatasetRepo, Name: "mymix", Version: "20", Type: "SRPM"},
+	}
+
+	datasets, ambiguous := findLegacyDatasets(keys, known)
+	if diff := deep.Equal(datasets, expected); diff != nil {
+		t.Error(diff)
+	}
+	if len(ambiguous) != 0 {
+		t.Errorf("expected no ambiguous keys but got %v", ambiguous)
+	}
+}
+
+func TestFindLegacyDatasetsAmbiguous(t *testing.T) {
+	// mix clear1 version 0 and mix clear version 10 share their keys
+	keys := []string{"clear10B:packages", "clear10bundles"}
+	tests := []struct {
+		name      string
+		known     []Dataset
+		expected  []Dataset
+		ambiguous []string
+	}{
+		{"unknown", nil, []Dataset{}, []string{"clear10B", "clear10bundles"}},
+		{"clear 10", []Dataset{{Kind: DatasetManifests, Name: "clear", Version: "10"}},
+			[]Dataset{
+				{Kind: DatasetBundles, Name: "clear", Version: "10"},
+				{Kind: DatasetRepo, Name: "clear", Version: "10", Type: "B"},
+			}, []string{}},
+		{"clear1 0", []Dataset{{Kind: DatasetRepo, Name: "clear1", Version: "0"}},
+			[]Dataset{
+				{Kind: DatasetBundles, Name: "clear1", Version: "0"},
+				{Kind: DatasetRepo, Name: "clear1", Version: "0", Type: "B"},
+			}, []string{}},
+		{"both", []Dataset{
+			{Kind: DatasetRepo, Name: "clear1", Version: "0"},
+			{Kind: DatasetRepo, Name: "clear", Version: "10"},
+		}, []Dataset{}, []string{"clear10B", "clear10bundles"}},
+	}
+
+	for _, tc := range tests {
+		datasets, ambiguous := findLegacyDatasets(keys, tc.known)
+		if diff := deep.Equal(datasets, tc.expected); diff != nil {
+			t.Errorf("%s: %v", tc.name, diff)
+		}
+		if diff := deep.Equal(ambiguous, tc.ambiguous); diff != nil {
+			t.Errorf("%s: %v", tc.name, diff)
+		}
+	}
+}
+
+func TestMigrateFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-migrate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	from, err := migrateFileStore(dir)
+	if err != nil || from != fileSchema {
+		t.Fatalf("expected new store to use layout %d but got %d, %v", fileSchema, from, err)
+	}
+
+	if err = writeGob(filepath.Join(dir, "schema.gob"), fileSchema+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrateFileStore(dir); err == nil {
+		t.Error("expected newer layout to be rejected")
+	}
+}
+
+func TestRemoveKeysRedis(t *testing.T) {
+	conn := redigomock.NewConn()
+	keys := []interface{}{}
+	for _, key := range []string{"clear10B", "clear10B:packages", "clear10B:bash:files", "repo:clear10B", "diva:schema"} {
+		keys = append(keys, []byte(key))
+	}
+	conn.Command("SCAN", 0, "MATCH", "*", "COUNT", 1000).Expect([]interface{}{[]byte("0"), keys})
+	dels := []*redigomock.Cmd{
+		conn.Command("DEL", "clear10B").Expect(int64(1)),
+		conn.Command("DEL", "clear10B:packages").Expect(int64(1)),
+		conn.Command("DEL", "clear10B:bash:files").Expect(int64(1)),
+	}
+	other := conn.Command("DEL", "repo:clear10B").Expect(int64(1))
+
+	if err := removeKeysRedis(conn, []string{"clear10B"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, del := range dels {
+		if conn.Stats(del) != 1 {
+			t.Errorf("expected %v to be removed", del.Args)
+		}
+	}
+	if conn.Stats(other) != 0 {
+		t.Error("expected keys of other applications to be kept")
+	}
+}
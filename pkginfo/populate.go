@@ -15,36 +15,87 @@
 package pkginfo
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cavaliercoder/go-rpm"
 	"github.com/clearlinux/diva/internal/helpers"
-	"github.com/gomodule/redigo/redis"
 )
 
-// PopulateRepo populates the repo struct with all RPMs from the database
+// PopulateRepo populates the repo struct with all RPMs and the provenance of
+// the repo from the database. The repo must have been completely imported.
 func PopulateRepo(repo *Repo) error {
-	var err error
-	var c redis.Conn
-	if c, err = initRedis(0); err != nil {
+	s, err := openCompleteStore(&repo.BaseInfo, repo.Dataset())
+	if err != nil {
 		return err
 	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
 
-	return getRepoRedis(c, repo)
+	if repo.Provenance, err = s.GetProvenance(repo.Dataset()); err != nil {
+		return err
+	}
+	return s.GetRepo(repo)
 }
 
-// PopulateBundles populates BundleInfo with bundle definitions from the database
+// PopulateRepoFromCache populates the repo struct with the RPMs read from the
+// headers of the RPM files anywhere under its RPMCache, and records the cache
+// as its provenance. It returns an error satisfying os.IsNotExist if the cache
+// holds no RPM files.
+func PopulateRepoFromCache(repo *Repo) error {
+	// RPMs copied into the cache from a repo may keep its Packages/<letter>
+	// layout, so the whole cache is searched
+	var files []string
+	err := filepath.Walk(repo.RPMCache, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".rpm") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(files) == 0 {
+		return &os.PathError{Op: "populate", Path: repo.RPMCache, Err: os.ErrNotExist}
+	}
+
+	repo.Provenance = newProvenance(repo.URI, repo.RPMCache)
+	for _, f := range files {
+		pkg, err := rpm.OpenPackageFile(f)
+		if err != nil {
+			return err
+		}
+		repo.Packages = appendUniqueRPM(repo.Packages, rpmFromPackage(pkg))
+	}
+	if err = repo.Provenance.readRepomd(repomdPath(repo)); err != nil {
+		return err
+	}
+	repo.Provenance.Finished = time.Now()
+	return nil
+}
+
+// PopulateBundles populates BundleInfo with bundle definitions and their
+// provenance from the database. The bundle definitions must have been
+// completely imported.
 func PopulateBundles(bundleInfo *BundleInfo, bundleName string) error {
-	var err error
-	var c redis.Conn
-	if c, err = initRedis(0); err != nil {
+	s, err := openCompleteStore(&bundleInfo.BaseInfo, bundleInfo.Dataset())
+	if err != nil {
 		return err
 	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
 
-	return getBundlesRedis(c, bundleInfo, bundleName)
+	if bundleInfo.Provenance, err = s.GetProvenance(bundleInfo.Dataset()); err != nil {
+		return err
+	}
+	return s.GetBundles(bundleInfo, bundleName)
 }
 
 // PopulateRepoFromBundles populates a repo object with the rpms from the
@@ -62,10 +113,23 @@ func PopulateRepoFromBundles(bundleInfo *BundleInfo, repo *Repo) error {
 	if err != nil {
 		return err
 	}
+
+	s, err := openCompleteStore(&repo.BaseInfo, repo.Dataset())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+
+	if repo.Provenance, err = s.GetProvenance(repo.Dataset()); err != nil {
+		return err
+	}
+
 	// iterate bundle packages, get the rpm from the database, and append it to
 	// the repo packages slice
 	for pkg := range bundleRPMs {
-		rpm, err := GetRPM(repo, pkg)
+		rpm, err := s.GetRPM(repo, pkg)
 		helpers.FailIfErr(err)
 		repo.Packages = append(repo.Packages, rpm)
 	}
@@ -73,17 +137,20 @@ func PopulateRepoFromBundles(bundleInfo *BundleInfo, repo *Repo) error {
 	return nil
 }
 
-// PopulateManifests queries the database for manifest information and stores
-// it into the mInfo object
+// PopulateManifests queries the database for manifest information and its
+// provenance and stores it into the mInfo object. The manifests must have
+// been completely imported.
 func PopulateManifests(mInfo *ManifestInfo) error {
-	var err error
-	var c redis.Conn
-	if c, err = initRedis(0); err != nil {
+	s, err := openCompleteStore(&mInfo.BaseInfo, mInfo.Dataset())
+	if err != nil {
 		return err
 	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
 
-	return getManifestsRedis(c, mInfo)
+	if mInfo.Provenance, err = s.GetProvenance(mInfo.Dataset()); err != nil {
+		return err
+	}
+	return s.GetManifests(mInfo)
 }
@@ -0,0 +1,198 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/diva/internal/helpers"
+)
+
+// DivaVersion is the version of diva recorded in the provenance of imports.
+// It is set by the command line tool.
+var DivaVersion = "unknown"
+
+// Provenance records where the data of an imported dataset came from and
+// what imported it. Fields that do not apply to a kind of dataset are empty.
+type Provenance struct {
+	// SourceURI is the location the cached data was fetched from
+	SourceURI string
+	// CachePath is the local copy the dataset was imported from
+	CachePath string
+	// Started and Finished are the times the import began and finished
+	// reading the cache
+	Started  time.Time
+	Finished time.Time
+	// RepomdRevision and RepomdSHA256 identify the repomd.xml a repo was
+	// fetched with
+	RepomdRevision string
+	RepomdSHA256   string
+	// BundlesCommit is the clr-bundles commit the definitions were read at
+	BundlesCommit string
+	// MoMHash is the SHA-256 of the Manifest.MoM the manifests were read from
+	MoMHash string
+	// Repodata is set for repos imported from their repo metadata, which
+	// lacks the sizes, digests, modes, and owners of files and does not
+	// tell symlinks from regular files, see LoadPayload
+	Repodata bool
+	// Mirrors are the upstream mirrors that served the cached files, in the
+	// order they were first seen
+	Mirrors []string
+	// DivaVersion is the version of diva that ran the import
+	DivaVersion string
+}
+
+func (p Provenance) String() string {
+	if p.DivaVersion == "" {
+		return "no provenance recorded"
+	}
+
+	parts := []string{fmt.Sprintf("from %s", p.SourceURI)}
+	if p.CachePath != "" {
+		parts = append(parts, fmt.Sprintf("cache %s", p.CachePath))
+	}
+	if p.RepomdRevision != "" {
+		parts = append(parts, fmt.Sprintf("repomd revision %s", p.RepomdRevision))
+	}
+	if p.RepomdSHA256 != "" {
+		parts = append(parts, fmt.Sprintf("repomd sha256 %s", p.RepomdSHA256))
+	}
+	if p.Repodata {
+		parts = append(parts, "metadata only")
+	}
+	if len(p.Mirrors) > 0 {
+		parts = append(parts, fmt.Sprintf("served by %s", strings.Join(p.Mirrors, ", ")))
+	}
+	if p.BundlesCommit != "" {
+		parts = append(parts, fmt.Sprintf("commit %s", p.BundlesCommit))
+	}
+	if p.MoMHash != "" {
+		parts = append(parts, fmt.Sprintf("MoM sha256 %s", p.MoMHash))
+	}
+	parts = append(parts, fmt.Sprintf("read %s to %s by diva %s",
+		p.Started.Format(time.RFC3339), p.Finished.Format(time.RFC3339), p.DivaVersion))
+	return strings.Join(parts, ", ")
+}
+
+// newProvenance starts the provenance record of an import from cachePath
+func newProvenance(sourceURI, cachePath string) Provenance {
+	return Provenance{
+		SourceURI:   sourceURI,
+		CachePath:   cachePath,
+		Started:     time.Now(),
+		DivaVersion: DivaVersion,
+	}
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// repomdPath returns the location repo.RPMCache's repomd.xml is downloaded
+// to, next to the packages directory
+func repomdPath(repo *Repo) string {
+	return filepath.Join(filepath.Dir(repo.RPMCache), "repomd.xml")
+}
+
+// readRepomd records the revision and checksum of the repomd.xml the repo
+// was fetched with. A repo imported from a local cache may not have one.
+func (p *Provenance) readRepomd(path string) error {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	v := struct {
+		XMLName  xml.Name `xml:"repomd"`
+		Revision string   `xml:"revision"`
+	}{}
+	if err = xml.Unmarshal(d, &v); err != nil {
+		return fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+
+	p.RepomdRevision = v.Revision
+	p.RepomdSHA256, err = fileSHA256(path)
+	return err
+}
+
+// readMirrors records the mirrors the names in dir were downloaded from, or
+// that any file in dir was downloaded from if no names are given. Files that
+// were not downloaded by diva have no mirror.
+func (p *Provenance) readMirrors(dir string, names ...string) error {
+	log, err := helpers.ReadMirrorLog(dir)
+	if err != nil {
+		return err
+	}
+
+	var mirrors []string
+	if len(names) == 0 {
+		for _, m := range log {
+			mirrors = append(mirrors, m)
+		}
+		sort.Strings(mirrors)
+	}
+	for _, name := range names {
+		if m, ok := log[name]; ok {
+			mirrors = append(mirrors, m)
+		}
+	}
+
+	for _, m := range mirrors {
+		seen := false
+		for _, s := range p.Mirrors {
+			seen = seen || s == m
+		}
+		if !seen {
+			p.Mirrors = append(p.Mirrors, m)
+		}
+	}
+	return nil
+}
+
+// readBundlesCommit records the commit checked out in the bundles repo
+func (p *Provenance) readBundlesCommit(repoPath string) error {
+	out, err := helpers.RunCommandOutput("git", "-C", repoPath, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	p.BundlesCommit = strings.TrimSpace(out.String())
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testRepomd = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1544646000</revision>
+  <data type="filelists">
+    <location href="repodata/abc-filelists.xml.gz"/>
+  </data>
+</repomd>
+`
+
+func TestReadRepomd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	repo := &Repo{RPMCache: filepath.Join(dir, "packages")}
+	p := Provenance{}
+
+	// a repo imported from a local cache has no repomd.xml
+	if err = p.readRepomd(repomdPath(repo)); err != nil {
+		t.Fatal(err)
+	}
+	if p.RepomdRevision != "" || p.RepomdSHA256 != "" {
+		t.Errorf("expected no repomd provenance but got %+v", p)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "repomd.xml"), []byte(testRepomd), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.readRepomd(repomdPath(repo)); err != nil {
+		t.Fatal(err)
+	}
+	if p.RepomdRevision != "1544646000" {
+		t.Errorf("expected revision 1544646000 but got %q", p.RepomdRevision)
+	}
+	if len(p.RepomdSHA256) != 64 {
+		t.Errorf("expected sha256 checksum but got %q", p.RepomdSHA256)
+	}
+}
+
+func TestProvenanceString(t *testing.T) {
+	if s := (Provenance{}).String(); s != "no provenance recorded" {
+		t.Errorf("unexpected empty provenance %q", s)
+	}
+
+	p := newProvenance("https://example.com/update", "/cache/update")
+	p.MoMHash = "abc"
+	p.Finished = p.Started
+	expected := "from https://example.com/update, cache /cache/update, MoM sha256 abc, read " +
+		p.Started.Format(time.RFC3339) + " to " + p.Started.Format(time.RFC3339) +
+		" by diva " + DivaVersion
+	if s := p.String(); s != expected {
+		t.Errorf("expected %q but got %q", expected, s)
+	}
+}
+
+func TestReadMirrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diva-provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	p := Provenance{}
+	// files not downloaded by diva have no mirror log
+	if err = p.readMirrors(dir); err != nil || len(p.Mirrors) != 0 {
+		t.Fatalf("expected no mirrors but got %v, %v", p.Mirrors, err)
+	}
+
+	log := "Manifest.MoM\thttps://b.example.com\n" +
+		"Manifest.os-core\t/srv/mix/www\n" +
+		"Manifest.MoM\thttps://a.example.com\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, ".mirrors"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.readMirrors(dir, "Manifest.MoM", "Manifest.editors"); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Mirrors) != 1 || p.Mirrors[0] != "https://a.example.com" {
+		t.Errorf("expected the last mirror of Manifest.MoM but got %v", p.Mirrors)
+	}
+
+	if err = p.readMirrors(dir); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"https://a.example.com", "/srv/mix/www"}
+	if len(p.Mirrors) != len(expected) || p.Mirrors[0] != expected[0] || p.Mirrors[1] != expected[1] {
+		t.Errorf("expected mirrors %v but got %v", expected, p.Mirrors)
+	}
+}
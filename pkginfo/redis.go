@@ -16,18 +16,326 @@ package pkginfo
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/mixer-tools/swupd"
 	"github.com/gomodule/redigo/redis"
 )
 
-// initRedis checks whether a redis-server is running and initializes it if
-// needed.
-// TODO: actually start the redis server if not running, right now it just
-// returns an error if there isn't one running.
-func initRedis(port int) (redis.Conn, error) {
-	p := ":6379"
-	if port != 0 {
-		p = fmt.Sprintf(":%d", port)
+// pools holds one connection pool per database configuration so every
+// pkginfo call in a command run reuses the same connections
+var (
+	pools   = make(map[config.DatabaseConfig]*redis.Pool)
+	poolsMu sync.Mutex
+	startMu sync.Mutex
+)
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// dialRedis opens a new connection to the redis-server configured by dbConf
+func dialRedis(dbConf config.DatabaseConfig) (redis.Conn, error) {
+	network, address := "tcp", dbConf.Address
+	if dbConf.Socket != "" {
+		network, address = "unix", dbConf.Socket
+	}
+	if address == "" {
+		address = ":6379"
+	}
+
+	return redis.Dial(network, address,
+		redis.DialConnectTimeout(seconds(dbConf.ConnectTimeout)),
+		redis.DialReadTimeout(seconds(dbConf.ReadTimeout)),
+		redis.DialWriteTimeout(seconds(dbConf.WriteTimeout)),
+		redis.DialPassword(dbConf.Password),
+		redis.DialDatabase(dbConf.DB),
+	)
+}
+
+func getPool(dbConf config.DatabaseConfig) *redis.Pool {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	if p, ok := pools[dbConf]; ok {
+		return p
+	}
+
+	p := &redis.Pool{
+		MaxIdle:     8,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return dialRedis(dbConf)
+		},
+		// only ping connections that have been idle for a while, checking
+		// every borrow would double the round trips
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	pools[dbConf] = p
+	return p
+}
+
+// ClosePools closes all redis connection pools opened during this run
+func ClosePools() error {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	var err error
+	for k, p := range pools {
+		if e := p.Close(); e != nil {
+			err = e
+		}
+		delete(pools, k)
+	}
+	return err
+}
+
+func getConn(dbConf config.DatabaseConfig) (redis.Conn, error) {
+	c := getPool(dbConf).Get()
+	if err := c.Err(); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// initRedis returns a connection from the shared pool for dbConf. The
+// connection must be closed to return it to the pool. If the configured
+// server is not reachable and dbConf.AutoStart is set, the private
+// redis-server under cacheLoc is used instead, and started if needed.
+func initRedis(dbConf *config.DatabaseConfig, cacheLoc string) (redis.Conn, error) {
+	c, err := getConn(*dbConf)
+	if err == nil || !dbConf.AutoStart {
+		return c, err
+	}
+
+	// only one caller may check for and start the private server at a time
+	startMu.Lock()
+	defer startMu.Unlock()
+
+	private := privateRedisConfig(*dbConf, cacheLoc)
+	if c, err = getConn(private); err == nil {
+		return c, nil
+	}
+
+	if err = startPrivateRedis(private, cacheLoc); err != nil {
+		return nil, err
+	}
+	return getConn(private)
+}
+
+// getSchemaRedis returns the key schema version of the database. A database
+// without a stored version uses the legacy schema if it holds legacy diva
+// datasets. Otherwise it is empty or used by other applications only, and is
+// set to the current schema.
+func getSchemaRedis(c redis.Conn) (redisSchema, error) {
+	v, err := redis.Int(c.Do("GET", schemaKey))
+	if err == nil {
+		return redisSchema(v), nil
+	}
+	if err != redis.ErrNil {
+		return 0, err
+	}
+
+	keys, err := scanLegacyKeysRedis(c)
+	if err != nil {
+		return 0, err
+	}
+	if len(keys) > 0 {
+		return legacySchema, nil
+	}
+
+	_, err = c.Do("SETNX", schemaKey, int(currentSchema))
+	return currentSchema, err
+}
+
+// checkSchemaRedis returns an error if the database does not use the
+// current key schema
+func checkSchemaRedis(c redis.Conn) error {
+	v, err := getSchemaRedis(c)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v > currentSchema:
+		return fmt.Errorf("database uses key schema %d, but this version of diva only supports schema %d", v, currentSchema)
+	case v < currentSchema:
+		return fmt.Errorf(`database uses key schema %d. Run "diva db migrate" to update it to schema %d`, v, currentSchema)
+	}
+	return nil
+}
+
+// redisStore is the Store implementation backed by a running redis-server
+type redisStore struct {
+	c         redis.Conn
+	dial      func() (redis.Conn, error)
+	batchSize int
+	// leases are the import locks held by the store
+	leases leases
+}
+
+// newRedisStore connects to the redis-server configured by dbConf and checks
+// that the database uses the current key schema
+func newRedisStore(dbConf *config.DatabaseConfig, cacheLoc string) (*redisStore, error) {
+	c, err := initRedis(dbConf, cacheLoc)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSchemaRedis(c); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	dial := func() (redis.Conn, error) {
+		return initRedis(dbConf, cacheLoc)
 	}
-	return redis.Dial("tcp", p)
+	return &redisStore{c: c, dial: dial, batchSize: dbConf.BatchSize}, nil
+}
+
+// checkLease returns the check of the import lock of ds before a commit
+func (s *redisStore) checkLease(ds Dataset) func() error {
+	return func() error {
+		return s.leases.check(ds)
+	}
+}
+
+func (s *redisStore) StoreRepo(repo *Repo) error {
+	return importRedis(s.c, repo.Dataset(), s.batchSize, func(p *redisPipeline, k datasetKeys) error {
+		if err := storeRepoInfoRedis(p, k, repo); err != nil {
+			return err
+		}
+		return storeProvenanceRedis(p, k, &repo.Provenance)
+	}, s.checkLease(repo.Dataset()))
+}
+
+// StoreRPM adds the rpm to the committed import of an existing repo in a
+// single transaction
+func (s *redisStore) StoreRPM(repo *Repo, rpm *RPM) error {
+	if err := s.leases.check(repo.Dataset()); err != nil {
+		return err
+	}
+	k, err := liveKeysRedis(s.c, repo.Dataset())
+	if err == redis.ErrNil {
+		return fmt.Errorf("%s not found in database", repo.Dataset())
+	}
+	if err != nil {
+		return err
+	}
+
+	p := newRedisPipeline(s.c, s.batchSize)
+	if err = p.send("MULTI"); err != nil {
+		return err
+	}
+	if err = storeRPMInfoRedis(p, k, rpm); err != nil {
+		_, _ = s.c.Do("DISCARD")
+		return err
+	}
+	return p.exec()
+}
+
+func (s *redisStore) StoreBundles(bundleInfo *BundleInfo, bundles *bundle.DefinitionsSet) error {
+	return importRedis(s.c, bundleInfo.Dataset(), s.batchSize, func(p *redisPipeline, k datasetKeys) error {
+		if err := storeBundleInfoRedis(p, k, bundles); err != nil {
+			return err
+		}
+		return storeProvenanceRedis(p, k, &bundleInfo.Provenance)
+	}, s.checkLease(bundleInfo.Dataset()))
+}
+
+func (s *redisStore) StoreManifests(mInfo *ManifestInfo, manifests []*swupd.Manifest) error {
+	return importRedis(s.c, mInfo.Dataset(), s.batchSize, func(p *redisPipeline, k datasetKeys) error {
+		if err := storeManifestRedis(p, k, manifests); err != nil {
+			return err
+		}
+		return storeProvenanceRedis(p, k, &mInfo.Provenance)
+	}, s.checkLease(mInfo.Dataset()))
+}
+
+func (s *redisStore) IsComplete(ds Dataset) (bool, error) {
+	return isCompleteRedis(s.c, ds)
+}
+
+func (s *redisStore) ListDatasets() ([]DatasetInfo, error) {
+	return listDatasetsRedis(s.c)
+}
+
+func (s *redisStore) RemoveDataset(ds Dataset) error {
+	return removeDatasetRedis(s.c, ds, s.batchSize)
+}
+
+func (s *redisStore) GetRepo(repo *Repo) error {
+	k, err := liveKeysRedis(s.c, repo.Dataset())
+	if err == redis.ErrNil {
+		return errNoRepoData
+	}
+	if err != nil {
+		return err
+	}
+	return getRepoRedis(s.c, k, repo)
+}
+
+func (s *redisStore) GetRPM(repo *Repo, rpmName string) (*RPM, error) {
+	rpms, err := s.GetRPMVariants(repo, rpmName)
+	if err != nil {
+		return nil, err
+	}
+	return rpms[0], nil
+}
+
+func (s *redisStore) GetRPMVariants(repo *Repo, rpmName string) ([]*RPM, error) {
+	k, err := liveKeysRedis(s.c, repo.Dataset())
+	if err == redis.ErrNil {
+		return nil, errRPMNotFound(repo, rpmName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getRPMVariantsRedis(s.c, k, repo, rpmName)
+}
+
+func (s *redisStore) GetBundles(bundleInfo *BundleInfo, bundleName string) error {
+	k, err := liveKeysRedis(s.c, bundleInfo.Dataset())
+	if err == redis.ErrNil {
+		return errNoBundleData
+	}
+	if err != nil {
+		return err
+	}
+	return getBundlesRedis(s.c, k, bundleInfo, bundleName)
+}
+
+func (s *redisStore) GetManifests(mInfo *ManifestInfo) error {
+	k, err := liveKeysRedis(s.c, mInfo.Dataset())
+	if err == redis.ErrNil {
+		return errNoManifestsData
+	}
+	if err != nil {
+		return err
+	}
+	return getManifestsRedis(s.c, k, mInfo)
+}
+
+func (s *redisStore) GetProvenance(ds Dataset) (Provenance, error) {
+	k, err := liveKeysRedis(s.c, ds)
+	if err == redis.ErrNil {
+		return Provenance{}, nil
+	}
+	if err != nil {
+		return Provenance{}, err
+	}
+	return getProvenanceRedis(s.c, k)
+}
+
+func (s *redisStore) Close() error {
+	return s.c.Close()
 }
@@ -0,0 +1,151 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/gomodule/redigo/redis"
+)
+
+// the private redis-server keeps its socket, configuration, log, and
+// persisted data in this directory under the cache location
+const privateRedisDir = "redis"
+
+// privateRedisConf is the configuration of the redis-server started by diva.
+// It only listens on a unix socket and persists its data with both RDB
+// snapshots and an append only file so imported data survives restarts.
+const privateRedisConf = `# generated by diva, changes will be overwritten
+port 0
+unixsocket %[1]s/redis.sock
+unixsocketperm 700
+daemonize yes
+pidfile %[1]s/redis.pid
+logfile %[1]s/redis.log
+dir %[1]s
+dbfilename dump.rdb
+save 900 1
+save 300 10
+save 60 10000
+appendonly yes
+appendfsync everysec
+`
+
+// PrivateRedisSocket returns the path of the unix socket used by the private
+// redis-server for the given cache location.
+func PrivateRedisSocket(cacheLoc string) string {
+	return filepath.Join(cacheLoc, privateRedisDir, "redis.sock")
+}
+
+// privateRedisConfig returns a copy of dbConf pointing at the private
+// redis-server socket under cacheLoc
+func privateRedisConfig(dbConf config.DatabaseConfig, cacheLoc string) config.DatabaseConfig {
+	dbConf.Address = ""
+	dbConf.Password = ""
+	dbConf.Socket = PrivateRedisSocket(cacheLoc)
+	return dbConf
+}
+
+// startPrivateRedis writes the private redis-server configuration under
+// cacheLoc and launches a daemonized redis-server with it. It waits for the
+// server to accept connections on its socket and load its persisted data
+// before returning.
+func startPrivateRedis(dbConf config.DatabaseConfig, cacheLoc string) error {
+	dir := filepath.Join(cacheLoc, privateRedisDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	confPath := filepath.Join(dir, "redis.conf")
+	err := ioutil.WriteFile(confPath, []byte(fmt.Sprintf(privateRedisConf, dir)), 0600)
+	if err != nil {
+		return err
+	}
+
+	// a stale socket left behind by a killed server prevents startup
+	_ = os.Remove(PrivateRedisSocket(cacheLoc))
+
+	helpers.PrintBegin("no redis-server reachable, starting one at %s", PrivateRedisSocket(cacheLoc))
+	if err = helpers.RunCommandSilent("redis-server", confPath); err != nil {
+		return err
+	}
+
+	// the daemonized server needs a moment to open the socket, and accepts
+	// connections while it is still loading its data
+	logPath := filepath.Join(dir, "redis.log")
+	for i := 0; i < 50; i++ {
+		c, err := dialRedis(dbConf)
+		if err == nil {
+			defer func() {
+				_ = c.Close()
+			}()
+			if err = waitLoaded(c, 100*time.Millisecond, loadTimeoutFactor*seconds(dbConf.ConnectTimeout)); err != nil {
+				return fmt.Errorf("%v, see %s", err, logPath)
+			}
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("redis-server did not start, see %s", logPath)
+}
+
+// loadTimeoutFactor is how many connect timeouts a starting redis-server is
+// given to load its persisted data
+const loadTimeoutFactor = 6
+
+// waitLoaded pings the server on c every interval until it stops replying
+// LOADING, which it does until its persisted data is loaded, or until the
+// timeout has passed. A timeout of 0 waits indefinitely.
+func waitLoaded(c redis.Conn, interval, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := c.Do("PING")
+		if e, ok := err.(redis.Error); !ok || !strings.HasPrefix(string(e), "LOADING") {
+			return err
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return fmt.Errorf("redis-server is still loading its data after %v", timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// StopPrivateRedis shuts down the private redis-server started for cacheLoc,
+// saving its data first. It returns an error if no server is running.
+func StopPrivateRedis(cacheLoc string) error {
+	dbConf := privateRedisConfig(config.DatabaseConfig{ConnectTimeout: 5}, cacheLoc)
+	c, err := dialRedis(dbConf)
+	if err != nil {
+		return fmt.Errorf("no private redis-server running at %s", dbConf.Socket)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	// a successful shutdown closes the connection without replying, so the
+	// only error worth reporting is one sent back by the server
+	_, err = c.Do("SHUTDOWN", "SAVE")
+	if err != nil && c.Err() == nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestGetPoolShared(t *testing.T) {
+	defer func() {
+		_ = ClosePools()
+	}()
+
+	a := config.DatabaseConfig{Address: "localhost:6379"}
+	b := config.DatabaseConfig{Address: "localhost:6379", DB: 1}
+
+	if getPool(a) != getPool(a) {
+		t.Error("expected the same pool for the same configuration")
+	}
+
+	if getPool(a) == getPool(b) {
+		t.Error("expected different pools for different configurations")
+	}
+
+	if err := ClosePools(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) != 0 {
+		t.Errorf("expected no pools after ClosePools but found %d", len(pools))
+	}
+}
+
+func TestPrivateRedisConfig(t *testing.T) {
+	dbConf := config.DatabaseConfig{
+		Address:  "remote:6379",
+		Password: "secret",
+		DB:       2,
+	}
+
+	private := privateRedisConfig(dbConf, "/cache")
+	if private.Socket != "/cache/redis/redis.sock" {
+		t.Errorf("expected socket under cache location but got %s", private.Socket)
+	}
+
+	if private.Address != "" || private.Password != "" {
+		t.Error("expected remote address and password to be cleared")
+	}
+
+	if private.DB != 2 {
+		t.Errorf("expected DB index to be kept but got %d", private.DB)
+	}
+}
+
+func TestWaitLoaded(t *testing.T) {
+	loading := redis.Error("LOADING Redis is loading the dataset in memory")
+	tests := []struct {
+		name  string
+		pings int
+		last  error
+	}{
+		{"loaded", 1, nil},
+		{"loading", 3, nil},
+		{"failed", 2, errors.New("connection closed")},
+	}
+
+	for _, tc := range tests {
+		conn := redigomock.NewConn()
+		cmd := conn.Command("PING")
+		for i := 1; i < tc.pings; i++ {
+			cmd.ExpectError(loading)
+		}
+		if tc.last != nil {
+			cmd.ExpectError(tc.last)
+		} else {
+			cmd.Expect("PONG")
+		}
+
+		if err := waitLoaded(conn, time.Millisecond, time.Minute); err != tc.last {
+			t.Errorf("%s: expected error %v but got %v", tc.name, tc.last, err)
+		}
+		if n := conn.Stats(cmd); n != tc.pings {
+			t.Errorf("%s: expected %d pings but got %d", tc.name, tc.pings, n)
+		}
+	}
+
+	// a server that keeps loading is given up on after the timeout
+	conn := redigomock.NewConn()
+	cmd := conn.Command("PING")
+	for i := 0; i < 1000; i++ {
+		cmd.ExpectError(loading)
+	}
+	err := waitLoaded(conn, time.Millisecond, 10*time.Millisecond)
+	if err == nil || !strings.HasPrefix(err.Error(), "redis-server is still loading") {
+		t.Errorf("expected timeout but got %v", err)
+	}
+	if n := conn.Stats(cmd); n >= 1000 {
+		t.Errorf("expected to stop pinging after the timeout but pinged %d times", n)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"sort"
+	"strings"
+)
+
+// Resolver finds the packages among a set of RPMs that satisfy dependencies,
+// by what they provide and the files they contain
+type Resolver struct {
+	provides map[string][]provider
+	files    map[string][]*RPM
+}
+
+type provider struct {
+	dep Dependency
+	rpm *RPM
+}
+
+// NewResolver indexes the provides and files of rpms
+func NewResolver(rpms []*RPM) *Resolver {
+	r := &Resolver{
+		provides: make(map[string][]provider),
+		files:    make(map[string][]*RPM),
+	}
+	for _, rpm := range rpms {
+		for _, d := range rpm.Provides {
+			r.provides[d.Name] = append(r.provides[d.Name], provider{d, rpm})
+		}
+		for _, f := range rpm.Files {
+			r.files[f.Name] = append(r.files[f.Name], rpm)
+		}
+	}
+	return r
+}
+
+// WhatProvides returns the RPMs that satisfy d, either by a provide whose
+// version range overlaps with d or, for a path, by containing the file
+func (r *Resolver) WhatProvides(d Dependency) []*RPM {
+	found := []*RPM{}
+	seen := make(map[*RPM]bool)
+	add := func(rpm *RPM) {
+		if !seen[rpm] {
+			seen[rpm] = true
+			found = append(found, rpm)
+		}
+	}
+
+	for _, p := range r.provides[d.Name] {
+		if d.Overlaps(p.dep) {
+			add(p.rpm)
+		}
+	}
+	if strings.HasPrefix(d.Name, "/") {
+		for _, rpm := range r.files[d.Name] {
+			add(rpm)
+		}
+	}
+	return found
+}
+
+// Satisfied reports whether some RPM satisfies d. The rpmlib() features rpm
+// provides itself and rich dependencies are always considered satisfied.
+func (r *Resolver) Satisfied(d Dependency) bool {
+	if d.IsRpmlib() || d.IsRich() {
+		return true
+	}
+	return len(r.WhatProvides(d)) > 0
+}
+
+// SatisfiedWithin reports whether one of the RPMs named in names satisfies d,
+// see Satisfied
+func (r *Resolver) SatisfiedWithin(d Dependency, names map[string]bool) bool {
+	if d.IsRpmlib() || d.IsRich() {
+		return true
+	}
+	for _, rpm := range r.WhatProvides(d) {
+		if names[rpm.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// UnresolvedDependency is a dependency of RPM that nothing satisfies
+type UnresolvedDependency struct {
+	RPM        *RPM
+	Dependency Dependency
+}
+
+func (u UnresolvedDependency) String() string {
+	return u.RPM.Name + " requires " + u.Dependency.String()
+}
+
+// Unresolved returns the dependencies of rpms, as selected by deps, that the
+// resolver cannot satisfy, ordered by package name and dependency
+func (r *Resolver) Unresolved(rpms []*RPM, deps func(*RPM) []Dependency) []UnresolvedDependency {
+	unresolved := []UnresolvedDependency{}
+	for _, rpm := range rpms {
+		for _, d := range deps(rpm) {
+			if !r.Satisfied(d) {
+				unresolved = append(unresolved, UnresolvedDependency{rpm, d})
+			}
+		}
+	}
+
+	sort.SliceStable(unresolved, func(i, j int) bool {
+		a, b := unresolved[i], unresolved[j]
+		if a.RPM.Name != b.RPM.Name {
+			return a.RPM.Name < b.RPM.Name
+		}
+		return a.Dependency.String() < b.Dependency.String()
+	})
+	return unresolved
+}
+
+// RuntimeRequires returns the Requires of rpm, for use with Unresolved
+func RuntimeRequires(rpm *RPM) []Dependency {
+	return rpm.Requires
+}
+
+// BuildRequirements returns the BuildRequires of a source rpm, for use with
+// Unresolved
+func BuildRequirements(rpm *RPM) []Dependency {
+	return rpm.BuildRequires
+}
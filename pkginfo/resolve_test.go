@@ -0,0 +1,86 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestResolver(t *testing.T) {
+	ge := DepFlagGreater | DepFlagEqual
+	rpms := []*RPM{
+		{
+			Name:     "bash",
+			Provides: []Dependency{{Name: "bash", Flags: DepFlagEqual, EVR: "5.0-1"}},
+			Requires: []Dependency{
+				{Name: "glibc", Flags: ge, EVR: "2.30"},
+				{Name: "rpmlib(CompressedFileNames)", Flags: DepFlagRpmlib | DepFlagLess | DepFlagEqual, EVR: "3.0.4-1"},
+				{Name: "(readline if bash-completion)"},
+			},
+			Files: []*File{{Name: "/usr/bin/bash"}},
+		},
+		{
+			Name:     "glibc",
+			Provides: []Dependency{{Name: "glibc", Flags: DepFlagEqual, EVR: "2.29-3"}, {Name: "libc.so.6()(64bit)"}},
+			Requires: []Dependency{{Name: "/usr/bin/bash"}, {Name: "/bin/sh"}},
+		},
+		{
+			Name:     "tool",
+			Requires: []Dependency{{Name: "libc.so.6()(64bit)"}, {Name: "libmissing.so.1()(64bit)"}},
+		},
+	}
+
+	r := NewResolver(rpms)
+	if got := r.WhatProvides(Dependency{Name: "/usr/bin/bash"}); len(got) != 1 || got[0] != rpms[0] {
+		t.Errorf("expected bash to provide /usr/bin/bash but got %v", got)
+	}
+	if got := r.WhatProvides(Dependency{Name: "glibc", Flags: ge, EVR: "2.29"}); len(got) != 1 || got[0] != rpms[1] {
+		t.Errorf("expected glibc to satisfy glibc >= 2.29 but got %v", got)
+	}
+
+	expected := []string{
+		"bash requires glibc >= 2.30",
+		"glibc requires /bin/sh",
+		"tool requires libmissing.so.1()(64bit)",
+	}
+	got := []string{}
+	for _, u := range r.Unresolved(rpms, RuntimeRequires) {
+		got = append(got, u.String())
+	}
+	if diff := deep.Equal(got, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSatisfiedWithin(t *testing.T) {
+	rpms := []*RPM{
+		{Name: "libfoo", Provides: []Dependency{{Name: "libfoo.so.1()(64bit)"}}},
+		{Name: "libfoo-compat", Provides: []Dependency{{Name: "libfoo.so.1()(64bit)"}}},
+	}
+	r := NewResolver(rpms)
+	d := Dependency{Name: "libfoo.so.1()(64bit)"}
+
+	if !r.SatisfiedWithin(d, map[string]bool{"libfoo-compat": true}) {
+		t.Error("expected requirement to be satisfied by libfoo-compat")
+	}
+	if r.SatisfiedWithin(d, map[string]bool{"bash": true}) {
+		t.Error("expected requirement not to be satisfied without a provider")
+	}
+	if !r.SatisfiedWithin(Dependency{Name: "rpmlib(PayloadIsXz)"}, map[string]bool{}) {
+		t.Error("expected rpmlib() requirement to be ignored")
+	}
+}
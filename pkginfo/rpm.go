@@ -15,36 +15,180 @@
 package pkginfo
 
 import (
-	"github.com/gomodule/redigo/redis"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-// getRPMFromRepo returns a pointer to the RPM that matches the rpm name. If
-// the repo does not contain the rpm, returns nil
-func getRPMFromRepo(repo *Repo, rpm string) *RPM {
+const (
+	// defaultArch is the architecture repos are built for unless another
+	// one is chosen
+	defaultArch = "x86_64"
+	// srcArch is the architecture of source RPM repos
+	srcArch = "src"
+)
+
+// repoArch returns the architecture of a repo of type typ built for arch.
+// Source RPMs are the same for every architecture, so SRPM repos always use
+// srcArch.
+func repoArch(typ, arch string) string {
+	switch {
+	case typ == "SRPM":
+		return srcArch
+	case arch == "":
+		return defaultArch
+	}
+	return arch
+}
+
+// NVRA returns the name-version-release.arch the rpm is stored under, which
+// is unique within a repo
+func (rpm *RPM) NVRA() string {
+	return NVRA(rpm.Name, rpm.Version, rpm.Release, rpm.Architecture)
+}
+
+// NVRA joins the name, version, release, and architecture of a package into
+// its name-version-release.arch
+func NVRA(name, version, release, arch string) string {
+	return fmt.Sprintf("%s-%s-%s.%s", name, version, release, arch)
+}
+
+// nameFromNVRA returns the package name of an NVRA. Versions and releases
+// cannot contain '-', and architectures cannot contain '.', so everything
+// before the last two '-' of the part before the last '.' is the name.
+func nameFromNVRA(nvra string) (string, error) {
+	nvr := nvra
+	if i := strings.LastIndex(nvr, "."); i >= 0 {
+		nvr = nvr[:i]
+	}
+	r := strings.LastIndex(nvr, "-")
+	if r <= 0 {
+		return "", fmt.Errorf("invalid package NVRA %q", nvra)
+	}
+	v := strings.LastIndex(nvr[:r], "-")
+	if v <= 0 {
+		return "", fmt.Errorf("invalid package NVRA %q", nvra)
+	}
+	return nvr[:v], nil
+}
+
+func errRPMNotFound(repo *Repo, rpmName string) error {
+	return fmt.Errorf("%s not found in %s repo", rpmName, repo.Name)
+}
+
+// epochString formats an epoch read from an rpm header, where no epoch and
+// epoch 0 are the same
+func epochString(epoch int) string {
+	if epoch == 0 {
+		return ""
+	}
+	return strconv.Itoa(epoch)
+}
+
+// archRank orders the architectures of variants of a package, preferring arch
+// over noarch over any other
+func archRank(a, arch string) int {
+	switch a {
+	case arch:
+		return 0
+	case "noarch":
+		return 1
+	}
+	return 2
+}
+
+// sortRPMVariants orders rpms from best to worst: newest version first, and
+// of the same version by archRank, then by architecture and NVRA so the order
+// is always the same
+func sortRPMVariants(rpms []*RPM, arch string) {
+	sort.SliceStable(rpms, func(i, j int) bool {
+		a, b := rpms[i], rpms[j]
+		if c := a.EVR().Compare(b.EVR()); c != 0 {
+			return c > 0
+		}
+		if ra, rb := archRank(a.Architecture, arch), archRank(b.Architecture, arch); ra != rb {
+			return ra < rb
+		}
+		if a.Architecture != b.Architecture {
+			return a.Architecture < b.Architecture
+		}
+		return a.NVRA() < b.NVRA()
+	})
+}
+
+// BestRPMs returns the best variant of every package in the repo by name, as
+// ordered by GetRPMVariants
+func BestRPMs(repo *Repo) map[string]*RPM {
+	variants := make(map[string][]*RPM)
+	for _, r := range repo.Packages {
+		variants[r.Name] = append(variants[r.Name], r)
+	}
+
+	best := make(map[string]*RPM, len(variants))
+	for name, rpms := range variants {
+		sortRPMVariants(rpms, repoArch(repo.Type, repo.Arch))
+		best[name] = rpms[0]
+	}
+	return best
+}
+
+// getRPMVariantsFromRepo returns the RPMs in the repo named rpm, the best
+// first
+func getRPMVariantsFromRepo(repo *Repo, rpm string) []*RPM {
+	rpms := []*RPM{}
 	for _, r := range repo.Packages {
 		if r.Name == rpm {
-			return r
+			rpms = append(rpms, r)
 		}
 	}
+	sortRPMVariants(rpms, repoArch(repo.Type, repo.Arch))
+	return rpms
+}
+
+// getRPMFromRepo returns a pointer to the best RPM that matches the rpm name.
+// If the repo does not contain the rpm, returns nil
+func getRPMFromRepo(repo *Repo, rpm string) *RPM {
+	if rpms := getRPMVariantsFromRepo(repo, rpm); len(rpms) > 0 {
+		return rpms[0]
+	}
 	return nil
 }
 
-// GetRPM fetches information about an RPM in a repo. Returns a pointer to the
-// associated RPM struct.
+// GetRPM fetches information about an RPM in a repo. If the repo holds several
+// versions or architectures of it, the best is returned, see GetRPMVariants.
+// Returns a pointer to the associated RPM struct.
 func GetRPM(repo *Repo, rpm string) (*RPM, error) {
 	if r := getRPMFromRepo(repo, rpm); r != nil {
 		return r, nil
 	}
 
-	var c redis.Conn
-	var err error
-	if c, err = initRedis(0); err != nil {
+	s, err := openCompleteStore(&repo.BaseInfo, repo.Dataset())
+	if err != nil {
 		return nil, err
 	}
 	defer func() {
-		_ = c.Close()
+		_ = s.Close()
 	}()
-	return getRPMRedis(c, repo, rpm)
+	return s.GetRPM(repo, rpm)
+}
+
+// GetRPMVariants fetches all versions and architectures of an RPM in a repo.
+// They are ordered from best to worst: the newest version first, and of the
+// same version the repo architecture before noarch before any other.
+func GetRPMVariants(repo *Repo, rpm string) ([]*RPM, error) {
+	if rpms := getRPMVariantsFromRepo(repo, rpm); len(rpms) > 0 {
+		return rpms, nil
+	}
+
+	s, err := openCompleteStore(&repo.BaseInfo, repo.Dataset())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	return s.GetRPMVariants(repo, rpm)
 }
 
 // GetSRPMName returns the SRPMName field of the given rpm. The rpm specified
@@ -59,12 +203,32 @@ func GetSRPMName(repo *Repo, rpm string) (string, error) {
 	return r.SRPMName, nil
 }
 
+// ParseSRPMName splits the SRPMName of a binary RPM, e.g.
+// "bash-5.0-12.src.rpm", into the name, version, and release of the source
+// RPM.
+func ParseSRPMName(srpmName string) (string, string, string, error) {
+	nvr := strings.TrimSuffix(srpmName, ".src.rpm")
+	if nvr == srpmName {
+		nvr = strings.TrimSuffix(srpmName, ".nosrc.rpm")
+	}
+
+	r := strings.LastIndex(nvr, "-")
+	if r <= 0 {
+		return "", "", "", fmt.Errorf("invalid source rpm name %q", srpmName)
+	}
+	v := strings.LastIndex(nvr[:r], "-")
+	if v <= 0 {
+		return "", "", "", fmt.Errorf("invalid source rpm name %q", srpmName)
+	}
+	return nvr[:v], nvr[v+1 : r], nvr[r+1:], nil
+}
+
 // GetRequires gets all runtime requirements for the given RPM. If the RPM is a
 // source RPM an error is returned.
-func GetRequires(repo *Repo, rpm string) ([]string, error) {
+func GetRequires(repo *Repo, rpm string) ([]Dependency, error) {
 	r, err := GetRPM(repo, rpm)
 	if err != nil {
-		return []string{}, nil
+		return []Dependency{}, nil
 	}
 
 	return r.Requires, nil
@@ -72,20 +236,20 @@ func GetRequires(repo *Repo, rpm string) ([]string, error) {
 
 // GetBuildRequires gets all build requirements for the given source RPM. If
 // the RPM is a binary or debuginfo RPM an error is returned.
-func GetBuildRequires(repo *Repo, rpm string) ([]string, error) {
+func GetBuildRequires(repo *Repo, rpm string) ([]Dependency, error) {
 	r, err := GetRPM(repo, rpm)
 	if err != nil {
-		return []string{}, nil
+		return []Dependency{}, nil
 	}
 
 	return r.BuildRequires, nil
 }
 
 // GetProvides gets all symbols provided by the given RPM.
-func GetProvides(repo *Repo, rpm string) ([]string, error) {
+func GetProvides(repo *Repo, rpm string) ([]Dependency, error) {
 	r, err := GetRPM(repo, rpm)
 	if err != nil {
-		return []string{}, nil
+		return []Dependency{}, nil
 	}
 
 	return r.Provides, nil
@@ -37,3 +37,124 @@ func TestGetRPMFromRepo(t *testing.T) {
 		t.Error("getRPMFromRepo did not return nil on non-existent RPM name")
 	}
 }
+
+func TestParseSRPMName(t *testing.T) {
+	tests := []struct {
+		srpm, name, version, release string
+	}{
+		{"bash-5.0-12.src.rpm", "bash", "5.0", "12"},
+		{"perl-Test-Simple-1.302-3.src.rpm", "perl-Test-Simple", "1.302", "3"},
+		{"firmware-20190620-1.nosrc.rpm", "firmware", "20190620", "1"},
+	}
+
+	for _, tc := range tests {
+		n, v, r, err := ParseSRPMName(tc.srpm)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %v", tc.srpm, err)
+			continue
+		}
+		if n != tc.name || v != tc.version || r != tc.release {
+			t.Errorf("expected %s to parse as %s %s %s but got %s %s %s",
+				tc.srpm, tc.name, tc.version, tc.release, n, v, r)
+		}
+	}
+
+	for _, srpm := range []string{"", "bash.src.rpm", "bash-5.0.src.rpm", "-5.0-1.src.rpm"} {
+		if _, _, _, err := ParseSRPMName(srpm); err == nil {
+			t.Errorf("expected error parsing %q", srpm)
+		}
+	}
+}
+
+func TestSortRPMVariants(t *testing.T) {
+	rpms := []*RPM{
+		{Name: "glibc", Version: "2.29", Release: "1", Architecture: "i686"},
+		{Name: "glibc", Version: "2.30", Release: "1", Architecture: "i686"},
+		{Name: "glibc", Version: "2.30", Release: "1", Architecture: "noarch"},
+		{Name: "glibc", Version: "2.30", Release: "1", Architecture: "x86_64"},
+		{Name: "glibc", Epoch: "1", Version: "2.1", Release: "1", Architecture: "x86_64"},
+	}
+	sortRPMVariants(rpms, "x86_64")
+
+	expected := []string{
+		"glibc-2.1-1.x86_64",
+		"glibc-2.30-1.x86_64",
+		"glibc-2.30-1.noarch",
+		"glibc-2.30-1.i686",
+		"glibc-2.29-1.i686",
+	}
+	for i, r := range rpms {
+		if r.NVRA() != expected[i] {
+			t.Errorf("expected %s at %d but got %s", expected[i], i, r.NVRA())
+		}
+	}
+}
+
+func TestBestRPMs(t *testing.T) {
+	repo := &Repo{
+		Type: "B",
+		Arch: "x86_64",
+		Packages: []*RPM{
+			{Name: "glibc", Version: "2.30", Release: "1", Architecture: "i686"},
+			{Name: "bash", Version: "5.0", Release: "1", Architecture: "x86_64"},
+			{Name: "glibc", Version: "2.30", Release: "1", Architecture: "x86_64"},
+			{Name: "glibc", Version: "2.29", Release: "1", Architecture: "x86_64"},
+			{Name: "bash", Version: "5.0", Release: "2", Architecture: "x86_64"},
+		},
+	}
+
+	expected := map[string]string{
+		"glibc": "glibc-2.30-1.x86_64",
+		"bash":  "bash-5.0-2.x86_64",
+	}
+	best := BestRPMs(repo)
+	if len(best) != len(expected) {
+		t.Fatalf("expected %d packages but got %d", len(expected), len(best))
+	}
+	for name, nvra := range expected {
+		if r := best[name]; r == nil || r.NVRA() != nvra {
+			t.Errorf("expected %s for %s but got %v", nvra, name, r)
+		}
+	}
+}
+
+func TestNameFromNVRA(t *testing.T) {
+	tests := map[string]string{
+		"bash-5.0-12.x86_64":                  "bash",
+		"perl-Test-Simple-1.302-3.noarch":     "perl-Test-Simple",
+		"python3-3.7.4-1.x86_64":              "python3",
+		"kernel-native-5.2.1-800.x86_64":      "kernel-native",
+		"libstdc++-9.1.1-1.i686":              "libstdc++",
+		"compat-readline5-5.2-1.x86_64":       "compat-readline5",
+		"lib32-glibc-2.30-330.x86_64":         "lib32-glibc",
+		"R-data.table-1.12.2-58.x86_64":       "R-data.table",
+		"gobject-introspection-1.60-1.x86_64": "gobject-introspection",
+	}
+	for nvra, name := range tests {
+		got, err := nameFromNVRA(nvra)
+		if err != nil || got != name {
+			t.Errorf("expected %s to have name %s but got %s, %v", nvra, name, got, err)
+		}
+	}
+
+	if _, err := nameFromNVRA("bash.x86_64"); err == nil {
+		t.Error("expected error for NVRA without version and release")
+	}
+}
+
+func TestRepoArch(t *testing.T) {
+	tests := []struct {
+		typ, arch, expected string
+	}{
+		{"B", "", defaultArch},
+		{"B", "aarch64", "aarch64"},
+		{"debug", "aarch64", "aarch64"},
+		{"SRPM", "aarch64", srcArch},
+	}
+
+	for _, tc := range tests {
+		if got := repoArch(tc.typ, tc.arch); got != tc.expected {
+			t.Errorf("expected %s %s repo arch to be %s but got %s", tc.typ, tc.arch, tc.expected, got)
+		}
+	}
+}
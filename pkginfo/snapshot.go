@@ -0,0 +1,212 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+// snapshotFormat is the version of the snapshot layout. A snapshot is a gzip
+// compressed stream of gob values: a snapshotHeader followed by one
+// snapshotDataset per dataset. Format 2 stores the dependencies of packages
+// with their flags and versions. Format 3 adds the architecture of repos,
+// repos of format 2 snapshots are of the default architecture of their type.
+const (
+	snapshotFormat    = 3
+	minSnapshotFormat = 2
+)
+
+// snapshotMagic identifies a stream as a diva snapshot
+const snapshotMagic = "diva-snapshot"
+
+type snapshotHeader struct {
+	Magic       string
+	Format      int
+	Created     time.Time
+	DivaVersion string
+}
+
+// snapshotDataset holds the data of one dataset. Only the slice matching
+// the kind of the dataset is set.
+type snapshotDataset struct {
+	Dataset    Dataset
+	Provenance Provenance
+	Packages   []*RPM
+	Bundles    []*bundle.Definition
+	Manifests  []*swupd.Manifest
+}
+
+// readSnapshotDataset reads ds from s
+func readSnapshotDataset(s Store, ds Dataset) (*snapshotDataset, error) {
+	var err error
+	sd := &snapshotDataset{Dataset: ds}
+	if sd.Provenance, err = s.GetProvenance(ds); err != nil {
+		return nil, err
+	}
+
+	base := BaseInfo{Name: ds.Name, Version: ds.Version}
+	switch ds.Kind {
+	case DatasetRepo:
+		repo := &Repo{BaseInfo: base, Type: ds.Type, Arch: ds.Arch}
+		if err = s.GetRepo(repo); err != nil {
+			return nil, err
+		}
+		sd.Packages = repo.Packages
+
+	case DatasetBundles:
+		bundleInfo := &BundleInfo{BaseInfo: base, BundleDefinitions: make(bundle.DefinitionsSet)}
+		if err = s.GetBundles(bundleInfo, ""); err != nil {
+			return nil, err
+		}
+		sd.Bundles = bundle.SetToSlice(bundleInfo.BundleDefinitions)
+
+	default:
+		mInfo := &ManifestInfo{
+			BundleInfo: BundleInfo{BaseInfo: base},
+			Manifests:  make(map[string]*swupd.Manifest),
+		}
+		if err = s.GetManifests(mInfo); err != nil {
+			return nil, err
+		}
+		sd.Manifests = append(sd.Manifests, mInfo.MoM)
+		for _, f := range mInfo.MoM.Files {
+			if m, ok := mInfo.Manifests[f.Name]; ok {
+				sd.Manifests = append(sd.Manifests, m)
+			}
+		}
+	}
+	return sd, nil
+}
+
+// storeSnapshotDataset replaces the dataset of sd in s with its data
+func storeSnapshotDataset(s Store, sd *snapshotDataset) error {
+	ds := sd.Dataset
+	base := BaseInfo{Name: ds.Name, Version: ds.Version, Provenance: sd.Provenance}
+	switch ds.Kind {
+	case DatasetRepo:
+		repo := &Repo{BaseInfo: base, URI: sd.Provenance.SourceURI, Type: ds.Type, Arch: ds.Arch, Packages: sd.Packages}
+		return s.StoreRepo(repo)
+
+	case DatasetBundles:
+		bundles := make(bundle.DefinitionsSet)
+		for _, b := range sd.Bundles {
+			bundles[b.Name] = b
+		}
+		return s.StoreBundles(&BundleInfo{BaseInfo: base}, &bundles)
+
+	case DatasetManifests:
+		if len(sd.Manifests) == 0 {
+			return fmt.Errorf("snapshot of %s has no manifests", ds)
+		}
+		return s.StoreManifests(&ManifestInfo{BundleInfo: BundleInfo{BaseInfo: base}}, sd.Manifests)
+
+	default:
+		return fmt.Errorf("snapshot contains unknown dataset kind %q", ds.Kind)
+	}
+}
+
+// ExportSnapshot writes the datasets, which must have been completely
+// imported into s, to w as a compressed snapshot that ImportSnapshot loads
+// into any backend
+func ExportSnapshot(s Store, datasets []Dataset, w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	enc := gob.NewEncoder(zw)
+
+	header := snapshotHeader{
+		Magic:       snapshotMagic,
+		Format:      snapshotFormat,
+		Created:     time.Now(),
+		DivaVersion: DivaVersion,
+	}
+	if err := enc.Encode(&header); err != nil {
+		return err
+	}
+
+	for _, ds := range datasets {
+		ok, err := s.IsComplete(ds)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errIncompleteData(ds)
+		}
+
+		sd, err := readSnapshotDataset(s, ds)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", ds, err)
+		}
+		if err = enc.Encode(sd); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// ImportSnapshot loads every dataset of the snapshot read from r into s,
+// replacing datasets that already exist, and returns the loaded datasets
+func ImportSnapshot(s Store, r io.Reader) ([]Dataset, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a diva snapshot: %v", err)
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+	dec := gob.NewDecoder(zr)
+
+	header := snapshotHeader{}
+	if err = dec.Decode(&header); err != nil || header.Magic != snapshotMagic {
+		return nil, errors.New("not a diva snapshot")
+	}
+	if header.Format < minSnapshotFormat || header.Format > snapshotFormat {
+		return nil, fmt.Errorf("snapshot uses format %d, but this version of diva only supports formats %d to %d",
+			header.Format, minSnapshotFormat, snapshotFormat)
+	}
+
+	datasets := []Dataset{}
+	for {
+		sd := &snapshotDataset{}
+		err = dec.Decode(sd)
+		if err == io.EOF {
+			return datasets, nil
+		}
+		if err != nil {
+			return datasets, err
+		}
+
+		// snapshots are not tied to a configuration, so the default lease is used
+		unlock, err := s.Lock(sd.Dataset, defaultLockTimeout)
+		if err != nil {
+			return datasets, err
+		}
+		err = storeSnapshotDataset(s, sd)
+		if uerr := unlock(); err == nil {
+			err = uerr
+		}
+		if err != nil {
+			return datasets, fmt.Errorf("unable to load %s: %v", sd.Dataset, err)
+		}
+		datasets = append(datasets, sd.Dataset)
+	}
+}
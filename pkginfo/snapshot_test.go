@@ -0,0 +1,118 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/mixer-tools/swupd"
+	"github.com/go-test/deep"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	src, cleanupSrc := newTestFileStore(t)
+	defer cleanupSrc()
+	dst, cleanupDst := newTestFileStore(t)
+	defer cleanupDst()
+
+	base := BaseInfo{
+		Name:       "clear",
+		Version:    "100",
+		Provenance: Provenance{SourceURI: "https://example.com", DivaVersion: "1.0"},
+	}
+
+	repo := &Repo{
+		BaseInfo: base,
+		URI:      "https://example.com",
+		Type:     "B",
+		Arch:     "aarch64",
+		Packages: []*RPM{{Name: "bash", Version: "5.0", Files: []*File{{Name: "/usr/bin/bash"}}}},
+	}
+	bundleInfo := &BundleInfo{BaseInfo: base}
+	bundles := bundle.DefinitionsSet{
+		"os-core": &bundle.Definition{Name: "os-core", AllPackages: map[string]bool{"bash": true}},
+	}
+	mInfo := &ManifestInfo{BundleInfo: BundleInfo{BaseInfo: base}}
+	manifests := []*swupd.Manifest{
+		{
+			Name:   "MoM",
+			Header: swupd.ManifestHeader{Version: 100},
+			Files:  []*swupd.File{{Name: "os-core", Version: 90}},
+		},
+		{Name: "os-core", Header: swupd.ManifestHeader{Version: 90}},
+	}
+
+	if err := src.StoreRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.StoreBundles(bundleInfo, &bundles); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.StoreManifests(mInfo, manifests); err != nil {
+		t.Fatal(err)
+	}
+
+	datasets := []Dataset{repo.Dataset(), bundleInfo.Dataset(), mInfo.Dataset()}
+	b := bytes.Buffer{}
+	if err := ExportSnapshot(src, datasets, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ImportSnapshot(dst, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(loaded, datasets); diff != nil {
+		t.Error(diff)
+	}
+
+	for _, ds := range datasets {
+		expected, err := readSnapshotDataset(src, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := readSnapshotDataset(dst, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(actual, expected); diff != nil {
+			t.Errorf("%s: %v", ds, diff)
+		}
+	}
+}
+
+func TestSnapshotIncomplete(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ds := Dataset{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "B"}
+	err := ExportSnapshot(s, []Dataset{ds}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "no complete import") {
+		t.Errorf("expected incomplete data error but got %v", err)
+	}
+}
+
+func TestImportSnapshotInvalid(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	_, err := ImportSnapshot(s, strings.NewReader("not a snapshot"))
+	if err == nil || !strings.Contains(err.Error(), "not a diva snapshot") {
+		t.Errorf("expected invalid snapshot error but got %v", err)
+	}
+}
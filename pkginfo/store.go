@@ -0,0 +1,274 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+const (
+	// BackendRedis stores imported data in a redis-server
+	BackendRedis = "redis"
+	// BackendFile stores imported data in gob encoded files on disk
+	BackendFile = "file"
+)
+
+const (
+	// DatasetRepo is a repo of binary or source RPMs
+	DatasetRepo = "repo"
+	// DatasetBundles is a set of bundle definitions
+	DatasetBundles = "bundles"
+	// DatasetManifests is the MoM and bundle manifests of an update
+	DatasetManifests = "manifests"
+)
+
+// fetchCmds maps dataset kinds to the fetch subcommand that imports them
+var fetchCmds = map[string]string{
+	DatasetRepo:      "repo",
+	DatasetBundles:   "bundles",
+	DatasetManifests: "update",
+}
+
+var (
+	errNoRepoData      = errors.New(`no repo data found. Try running "diva fetch repo -v <version>" to populate database`)
+	errNoBundleData    = errors.New(`no bundle definitions found. Try running "diva fetch bundles -v <version>" to populate database`)
+	errNoManifestsData = errors.New(`no manifests found. Try running "diva fetch update -v <version>" to populate database`)
+)
+
+// Dataset identifies the data written by a single import. Type and Arch are
+// only set for repos. An empty Arch is the default architecture of the repo
+// type, see repoArch.
+type Dataset struct {
+	Kind    string
+	Name    string
+	Version string
+	Type    string
+	Arch    string
+}
+
+func (ds Dataset) String() string {
+	if ds.Type != "" {
+		return fmt.Sprintf("%s %s %s %s %s", ds.Kind, ds.Name, ds.Version, ds.Type, repoArch(ds.Type, ds.Arch))
+	}
+	return fmt.Sprintf("%s %s %s", ds.Kind, ds.Name, ds.Version)
+}
+
+// Dataset returns the dataset the repo is imported as
+func (repo *Repo) Dataset() Dataset {
+	return Dataset{
+		Kind:    DatasetRepo,
+		Name:    repo.Name,
+		Version: repo.Version,
+		Type:    repo.Type,
+		Arch:    repoArch(repo.Type, repo.Arch),
+	}
+}
+
+// Dataset returns the dataset the bundle definitions are imported as
+func (bundleInfo *BundleInfo) Dataset() Dataset {
+	return Dataset{Kind: DatasetBundles, Name: bundleInfo.Name, Version: bundleInfo.Version}
+}
+
+// Dataset returns the dataset the manifests are imported as
+func (mInfo *ManifestInfo) Dataset() Dataset {
+	return Dataset{Kind: DatasetManifests, Name: mInfo.Name, Version: mInfo.Version}
+}
+
+// DatasetInfo describes a completely imported dataset. Count is the number
+// of packages, bundles, or manifests it contains.
+type DatasetInfo struct {
+	Dataset
+	Imported   time.Time
+	Count      int
+	Provenance Provenance
+}
+
+// lessVersion orders numeric versions by value and all others as strings
+func lessVersion(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// sortDatasets orders datasets by name, then kind, version, type, and arch
+func sortDatasets(datasets []DatasetInfo) {
+	sort.Slice(datasets, func(i, j int) bool {
+		a, b := datasets[i], datasets[j]
+		switch {
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.Version != b.Version:
+			return lessVersion(a.Version, b.Version)
+		case a.Type != b.Type:
+			return a.Type < b.Type
+		}
+		return a.Arch < b.Arch
+	})
+}
+
+func errIncompleteData(ds Dataset) error {
+	args := ""
+	if ds.Kind == DatasetRepo && repoArch(ds.Type, ds.Arch) != repoArch(ds.Type, "") {
+		args = " --arch " + ds.Arch
+	}
+	return fmt.Errorf(`no complete import of %s found. Try running "diva fetch %s -v %s%s" to populate database`,
+		ds, fetchCmds[ds.Kind], ds.Version, args)
+}
+
+// Store is a database backend that imported repo, bundle, and manifest data
+// are written to and read from.
+//
+// StoreRepo, StoreBundles, and StoreManifests replace a whole dataset. They
+// write it to a staging area first and only swap it in once everything is
+// written, then mark the dataset complete. A failed import leaves the
+// previous data, if any, in place. The Provenance of the info struct is
+// stored along with the dataset.
+type Store interface {
+	// StoreRepo stores the repo and all of its packages
+	StoreRepo(repo *Repo) error
+	// StoreRPM stores a single rpm under the given repo
+	StoreRPM(repo *Repo, rpm *RPM) error
+	// StoreBundles stores the bundle definitions under the bundleInfo version
+	StoreBundles(bundleInfo *BundleInfo, bundles *bundle.DefinitionsSet) error
+	// StoreManifests stores the manifests under the mInfo version
+	StoreManifests(mInfo *ManifestInfo, manifests []*swupd.Manifest) error
+
+	// IsComplete reports whether ds was completely imported
+	IsComplete(ds Dataset) (bool, error)
+	// ListDatasets returns all completely imported datasets
+	ListDatasets() ([]DatasetInfo, error)
+	// RemoveDataset deletes ds and everything stored under it
+	RemoveDataset(ds Dataset) error
+	// Lock takes the import lock of ds, leased for ttl and renewed until the
+	// returned function releases it. It waits while another process holds
+	// the lock, unless its lease has expired. Imports of ds check that the
+	// lock is still held right before they commit, and fail otherwise.
+	Lock(ds Dataset, ttl time.Duration) (func() error, error)
+
+	// GetRepo populates repo.Packages with all stored packages
+	GetRepo(repo *Repo) error
+	// GetRPM returns the best of the stored rpms named rpmName, see
+	// GetRPMVariants
+	GetRPM(repo *Repo, rpmName string) (*RPM, error)
+	// GetRPMVariants returns all stored rpms named rpmName, the best first:
+	// newest version first, and of the same version the repo architecture
+	// before noarch before any other
+	GetRPMVariants(repo *Repo, rpmName string) ([]*RPM, error)
+	// GetBundles populates bundleInfo.BundleDefinitions with bundleName, or
+	// all bundles if bundleName is empty
+	GetBundles(bundleInfo *BundleInfo, bundleName string) error
+	// GetManifests populates mInfo.MoM and mInfo.Manifests
+	GetManifests(mInfo *ManifestInfo) error
+	// GetProvenance returns the provenance recorded by the import of ds, which
+	// is empty for datasets imported before it was recorded
+	GetProvenance(ds Dataset) (Provenance, error)
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// NewStore opens the Store configured by dbConf. The cacheLoc is used to
+// build the default location for backends that keep their data on disk.
+func NewStore(dbConf *config.DatabaseConfig, cacheLoc string) (Store, error) {
+	switch dbConf.Backend {
+	case "", BackendRedis:
+		return newRedisStore(dbConf, cacheLoc)
+	case BackendFile:
+		return newFileStore(fileStorePath(dbConf, cacheLoc))
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", dbConf.Backend)
+	}
+}
+
+// newStagingID returns a random identifier for the staging area or
+// generation of an import
+func newStagingID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// manifestsInUse returns the names of the manifests stored under the version
+// of ds that are also listed by the MoM of one of the other datasets. Those
+// must be kept when ds is removed.
+func manifestsInUse(ds Dataset, datasets []DatasetInfo, getMoM func(version string) (*swupd.Manifest, error)) (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	for _, other := range datasets {
+		if other.Kind != DatasetManifests || other.Name != ds.Name || other.Version == ds.Version {
+			continue
+		}
+
+		mom, err := getMoM(other.Version)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range mom.Files {
+			if fmt.Sprint(f.Version) == ds.Version {
+				inUse[f.Name] = true
+			}
+		}
+	}
+	return inUse, nil
+}
+
+// fileStorePath returns the directory of the file backend
+func fileStorePath(dbConf *config.DatabaseConfig, cacheLoc string) string {
+	if dbConf.Path != "" {
+		return dbConf.Path
+	}
+	return filepath.Join(cacheLoc, "db")
+}
+
+// openStore opens the Store configured for the data described by b
+func openStore(b *BaseInfo) (Store, error) {
+	return NewStore(&b.Database, b.CacheLoc)
+}
+
+// openCompleteStore opens the Store configured for b and checks that ds was
+// completely imported, so partially written data is never read
+func openCompleteStore(b *BaseInfo, ds Dataset) (Store, error) {
+	s, err := openStore(b)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.IsComplete(ds)
+	if err == nil && !ok {
+		err = errIncompleteData(ds)
+	}
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
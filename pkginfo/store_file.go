@@ -0,0 +1,714 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/internal/helpers"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+// fileStore is the Store implementation that keeps gob encoded data in a
+// directory tree on disk, so no database server is needed. The layout is:
+//
+//	<root>/schema.gob
+//	<root>/repos/<name>/<version>/<type>/<arch>/repo.gob
+//	<root>/repos/<name>/<version>/<type>/<arch>/packages/<rpm nvra>.gob
+//	<root>/bundles/<name>/<version>/<bundle>.gob
+//	<root>/manifests/<name>/<version>/manifests.gob
+//	<root>/manifests/<name>/<manifest version>/Manifest.<manifest>.gob
+//
+// The repo, bundles, and manifests.gob directories of a completely imported
+// dataset also hold a .complete file with the unix time the import finished,
+// and a .provenance file with the Provenance of the import. While a dataset
+// is imported, a .lock.<dir> file next to its directory holds the owner of
+// the import lock, and its modification time is the start of the lease.
+type fileStore struct {
+	root string
+	// names caches the NVRAs of the packages of each packages directory by
+	// package name
+	names map[string]map[string][]string
+	// leases are the import locks held by the store
+	leases leases
+}
+
+// fileSchema is the version of the file backend layout. A store without a
+// stored version is new and uses the current layout.
+const fileSchema = 1
+
+func newFileStore(root string) (*fileStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &fileStore{root: root}
+	v, err := s.schema()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case v > fileSchema:
+		return nil, fmt.Errorf("database at %s uses layout %d, but this version of diva only supports layout %d",
+			root, v, fileSchema)
+	case v < fileSchema:
+		return nil, fmt.Errorf(`database at %s uses layout %d. Run "diva db migrate" to update it to layout %d`,
+			root, v, fileSchema)
+	}
+	return s, nil
+}
+
+// schema returns the layout version of the store, storing the current
+// version if there is none yet
+func (s *fileStore) schema() (int, error) {
+	var v int
+	path := filepath.Join(s.root, "schema.gob")
+	err := readGob(path, &v)
+	if os.IsNotExist(err) {
+		return fileSchema, writeGob(path, fileSchema)
+	}
+	return v, err
+}
+
+// writeGob encodes v to a temporary file next to path and then moves it into
+// place so readers never see a partially written file
+func writeGob(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	b := bytes.Buffer{}
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return err
+	}
+
+	tmpFile := filepath.Join(filepath.Dir(path), ".tmp."+filepath.Base(path))
+	if err := ioutil.WriteFile(tmpFile, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
+func readGob(path string, v interface{}) error {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewBuffer(d)).Decode(v)
+}
+
+// listGobs returns the names, without the .gob extension, of all gob files in
+// dir. A missing dir is treated as empty.
+func listGobs(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := []string{}
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") || !strings.HasSuffix(fi.Name(), ".gob") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(fi.Name(), ".gob"))
+	}
+	return names, nil
+}
+
+// completeFile marks the directory of a completely imported dataset
+const completeFile = ".complete"
+
+// provenanceFile holds the provenance of the dataset in its directory
+const provenanceFile = ".provenance"
+
+func markComplete(dir string) error {
+	return writeGob(filepath.Join(dir, completeFile), time.Now().Unix())
+}
+
+// importDir writes a dataset with write into a staging directory next to
+// dir, marks it complete, and then swaps it in for dir unless check, if any,
+// fails.
+// The old directory is only removed once the new one is in place.
+func importDir(dir string, write func(staging string) error, check func() error) error {
+	id, err := newStagingID()
+	if err != nil {
+		return err
+	}
+
+	parent, base := filepath.Split(dir)
+	staging := filepath.Join(parent, ".staging."+base+"."+id)
+	old := filepath.Join(parent, ".old."+base+"."+id)
+	if err = os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+
+	if err = write(staging); err == nil {
+		err = markComplete(staging)
+	}
+	if err == nil && check != nil {
+		err = check()
+	}
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+
+	// there is nothing to move aside on the first import
+	if err = os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+	if err = os.Rename(staging, dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// kindDirs maps dataset kinds to the directory their datasets are stored in
+var kindDirs = map[string]string{
+	DatasetRepo:      "repos",
+	DatasetBundles:   "bundles",
+	DatasetManifests: "manifests",
+}
+
+func (s *fileStore) datasetDir(ds Dataset) string {
+	switch ds.Kind {
+	case DatasetBundles:
+		return filepath.Join(s.root, kindDirs[DatasetBundles], ds.Name, ds.Version)
+	case DatasetManifests:
+		return s.manifestsDir(ds.Name, ds.Version)
+	default:
+		return filepath.Join(s.root, kindDirs[DatasetRepo], ds.Name, ds.Version, ds.Type, repoArch(ds.Type, ds.Arch))
+	}
+}
+
+func (s *fileStore) repoDir(repo *Repo) string {
+	return s.datasetDir(repo.Dataset())
+}
+
+func (s *fileStore) bundlesDir(bundleInfo *BundleInfo) string {
+	return s.datasetDir(bundleInfo.Dataset())
+}
+
+func (s *fileStore) manifestsDir(name, version string) string {
+	return filepath.Join(s.root, kindDirs[DatasetManifests], name, version)
+}
+
+func writeRPM(dir string, rpm *RPM) error {
+	return writeGob(filepath.Join(dir, "packages", rpm.NVRA()+".gob"), rpm)
+}
+
+// packageNames returns the NVRAs of the packages in dir by package name
+func (s *fileStore) packageNames(dir string) (map[string][]string, error) {
+	if names, ok := s.names[dir]; ok {
+		return names, nil
+	}
+
+	nvras, err := listGobs(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string][]string)
+	for _, nvra := range nvras {
+		name, err := nameFromNVRA(nvra)
+		if err != nil {
+			return nil, err
+		}
+		names[name] = append(names[name], nvra)
+	}
+
+	if s.names == nil {
+		s.names = make(map[string]map[string][]string)
+	}
+	s.names[dir] = names
+	return names, nil
+}
+
+// checkLease returns the check of the import lock of ds before a commit
+func (s *fileStore) checkLease(ds Dataset) func() error {
+	return func() error {
+		return s.leases.check(ds)
+	}
+}
+
+func (s *fileStore) StoreRepo(repo *Repo) error {
+	delete(s.names, filepath.Join(s.repoDir(repo), "packages"))
+	return importDir(s.repoDir(repo), func(dir string) error {
+		if err := writeGob(filepath.Join(dir, "repo.gob"), repo.URI); err != nil {
+			return err
+		}
+		if err := writeGob(filepath.Join(dir, provenanceFile), &repo.Provenance); err != nil {
+			return err
+		}
+
+		for i := range repo.Packages {
+			if err := writeRPM(dir, repo.Packages[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, s.checkLease(repo.Dataset()))
+}
+
+func (s *fileStore) StoreRPM(repo *Repo, rpm *RPM) error {
+	if err := s.leases.check(repo.Dataset()); err != nil {
+		return err
+	}
+	delete(s.names, filepath.Join(s.repoDir(repo), "packages"))
+	return writeRPM(s.repoDir(repo), rpm)
+}
+
+func (s *fileStore) StoreBundles(bundleInfo *BundleInfo, bundleset *bundle.DefinitionsSet) error {
+	return importDir(s.bundlesDir(bundleInfo), func(dir string) error {
+		if err := writeGob(filepath.Join(dir, provenanceFile), &bundleInfo.Provenance); err != nil {
+			return err
+		}
+		for _, b := range bundle.SetToSlice(*bundleset) {
+			if err := writeGob(filepath.Join(dir, b.Name+".gob"), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, s.checkLease(bundleInfo.Dataset()))
+}
+
+// manifests are stored by the version they were created/changed in, not
+// necessarily the version of the MoM. Those directories are shared between imports, so they cannot be swapped in like
+// the other datasets. A manifest never changes once it is released, though,
+// so rewriting it in place is harmless, and the manifests.gob list that makes
+// the new files reachable is only replaced once all of them are written.
+func (s *fileStore) StoreManifests(mInfo *ManifestInfo, manifests []*swupd.Manifest) error {
+	names := []string{}
+	for _, m := range manifests {
+		names = append(names, m.Name)
+
+		// only the fields read back by GetManifests are stored
+		stored := swupd.Manifest{
+			Name:         m.Name,
+			Header:       m.Header,
+			Files:        m.Files,
+			DeletedFiles: m.DeletedFiles,
+		}
+		dir := s.manifestsDir(mInfo.Name, fmt.Sprint(m.Header.Version))
+		if err := writeGob(filepath.Join(dir, "Manifest."+m.Name+".gob"), &stored); err != nil {
+			return err
+		}
+	}
+
+	if err := s.leases.check(mInfo.Dataset()); err != nil {
+		return err
+	}
+	dir := s.manifestsDir(mInfo.Name, mInfo.Version)
+	if err := writeGob(filepath.Join(dir, provenanceFile), &mInfo.Provenance); err != nil {
+		return err
+	}
+	if err := writeGob(filepath.Join(dir, "manifests.gob"), names); err != nil {
+		return err
+	}
+	return markComplete(dir)
+}
+
+func (s *fileStore) IsComplete(ds Dataset) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.datasetDir(ds), completeFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *fileStore) GetRepo(repo *Repo) error {
+	dir := filepath.Join(s.repoDir(repo), "packages")
+	nvras, err := listGobs(dir)
+	if err != nil {
+		return err
+	}
+	if len(nvras) == 0 {
+		return errNoRepoData
+	}
+
+	for _, nvra := range nvras {
+		p := &RPM{}
+		if err = readGob(filepath.Join(dir, nvra+".gob"), p); err != nil {
+			return err
+		}
+		repo.Packages = appendUniqueRPM(repo.Packages, p)
+	}
+	return nil
+}
+
+func (s *fileStore) GetRPM(repo *Repo, rpmName string) (*RPM, error) {
+	rpms, err := s.GetRPMVariants(repo, rpmName)
+	if err != nil {
+		return nil, err
+	}
+	return rpms[0], nil
+}
+
+func (s *fileStore) GetRPMVariants(repo *Repo, rpmName string) ([]*RPM, error) {
+	dir := filepath.Join(s.repoDir(repo), "packages")
+	names, err := s.packageNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names[rpmName]) == 0 {
+		return nil, errRPMNotFound(repo, rpmName)
+	}
+
+	rpms := []*RPM{}
+	for _, nvra := range names[rpmName] {
+		p := &RPM{}
+		if err = readGob(filepath.Join(dir, nvra+".gob"), p); err != nil {
+			return nil, err
+		}
+		rpms = append(rpms, p)
+	}
+	sortRPMVariants(rpms, repoArch(repo.Type, repo.Arch))
+	return rpms, nil
+}
+
+func (s *fileStore) getBundle(bundleInfo *BundleInfo, bundleName string) error {
+	b := &bundle.Definition{}
+	err := readGob(filepath.Join(s.bundlesDir(bundleInfo), bundleName+".gob"), b)
+	if os.IsNotExist(err) {
+		return errNoBundleData
+	}
+	if err != nil {
+		return err
+	}
+
+	// gob does not transmit empty maps, make sure callers get usable sets
+	if b.Includes == nil {
+		b.Includes = make(map[string]bool)
+	}
+	if b.DirectPackages == nil {
+		b.DirectPackages = make(map[string]bool)
+	}
+	if b.AllPackages == nil {
+		b.AllPackages = make(map[string]bool)
+	}
+
+	bundleInfo.BundleDefinitions[b.Name] = b
+	return nil
+}
+
+func (s *fileStore) GetBundles(bundleInfo *BundleInfo, bundleName string) error {
+	if bundleName != "" {
+		return s.getBundle(bundleInfo, bundleName)
+	}
+
+	names, err := listGobs(s.bundlesDir(bundleInfo))
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errNoBundleData
+	}
+
+	for _, bn := range names {
+		if err = s.getBundle(bundleInfo, bn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileStore) getManifest(name, version, manifestName string) (*swupd.Manifest, error) {
+	m := &swupd.Manifest{}
+	path := filepath.Join(s.manifestsDir(name, version), "Manifest."+manifestName+".gob")
+	return m, readGob(path, m)
+}
+
+func (s *fileStore) GetManifests(mInfo *ManifestInfo) error {
+	var names []string
+	err := readGob(filepath.Join(s.manifestsDir(mInfo.Name, mInfo.Version), "manifests.gob"), &names)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(names) == 0 {
+		return errNoManifestsData
+	}
+
+	mInfo.MoM, err = s.getManifest(mInfo.Name, mInfo.Version, "MoM")
+	if err != nil {
+		return err
+	}
+
+	for _, mFile := range mInfo.MoM.Files {
+		m, err := s.getManifest(mInfo.Name, fmt.Sprint(mFile.Version), mFile.Name)
+		if err != nil {
+			return err
+		}
+		mInfo.Manifests[m.Name] = m
+	}
+	return nil
+}
+
+// datasetDirs returns the directories of all completely imported datasets of
+// one kind. Their path below dir is depth directories long.
+func datasetDirs(dir string, depth int) ([]string, error) {
+	pattern := dir
+	for i := 0; i < depth; i++ {
+		pattern = filepath.Join(pattern, "*")
+	}
+	markers, err := filepath.Glob(filepath.Join(pattern, completeFile))
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, m := range markers {
+		// staging and old directories of an import in progress are hidden
+		rel, err := filepath.Rel(dir, filepath.Dir(m))
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(string(filepath.Separator)+rel, string(filepath.Separator)+".") {
+			continue
+		}
+		dirs = append(dirs, rel)
+	}
+	return dirs, nil
+}
+
+func (s *fileStore) ListDatasets() ([]DatasetInfo, error) {
+	datasets := []DatasetInfo{}
+	for _, kind := range []string{DatasetRepo, DatasetBundles, DatasetManifests} {
+		top := filepath.Join(s.root, kindDirs[kind])
+		depth := 2
+		if kind == DatasetRepo {
+			depth = 4
+		}
+
+		dirs, err := datasetDirs(top, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rel := range dirs {
+			parts := strings.Split(rel, string(filepath.Separator))
+			info := DatasetInfo{Dataset: Dataset{Kind: kind, Name: parts[0], Version: parts[1]}}
+			if kind == DatasetRepo {
+				info.Type = parts[2]
+				info.Arch = parts[3]
+			}
+
+			dir := filepath.Join(top, rel)
+			var t int64
+			if err = readGob(filepath.Join(dir, completeFile), &t); err != nil {
+				return nil, err
+			}
+			info.Imported = time.Unix(t, 0)
+
+			var names []string
+			switch kind {
+			case DatasetRepo:
+				names, err = listGobs(filepath.Join(dir, "packages"))
+			case DatasetBundles:
+				names, err = listGobs(dir)
+			default:
+				err = readGob(filepath.Join(dir, "manifests.gob"), &names)
+			}
+			if err != nil {
+				return nil, err
+			}
+			info.Count = len(names)
+
+			if info.Provenance, err = s.GetProvenance(info.Dataset); err != nil {
+				return nil, err
+			}
+
+			datasets = append(datasets, info)
+		}
+	}
+
+	sortDatasets(datasets)
+	return datasets, nil
+}
+
+func (s *fileStore) RemoveDataset(ds Dataset) error {
+	dir := s.datasetDir(ds)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s not found in database", ds)
+		}
+		return err
+	}
+
+	if ds.Kind != DatasetManifests {
+		return os.RemoveAll(dir)
+	}
+
+	// the manifests directory is shared with the datasets listing manifests
+	// of this version, unmark the dataset first so it is not used meanwhile
+	for _, f := range []string{completeFile, "manifests.gob", provenanceFile} {
+		if err := os.Remove(filepath.Join(dir, f)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	datasets, err := s.ListDatasets()
+	if err != nil {
+		return err
+	}
+	inUse, err := manifestsInUse(ds, datasets, func(version string) (*swupd.Manifest, error) {
+		return s.getManifest(ds.Name, version, "MoM")
+	})
+	if err != nil {
+		return err
+	}
+
+	names, err := listGobs(dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if inUse[strings.TrimPrefix(n, "Manifest.")] {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, n+".gob")); err != nil {
+			return err
+		}
+	}
+
+	// only remove the directory once nothing is left in it
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil || len(fis) > 0 {
+		return err
+	}
+	return os.Remove(dir)
+}
+
+func (s *fileStore) GetProvenance(ds Dataset) (Provenance, error) {
+	prov := Provenance{}
+	err := readGob(filepath.Join(s.datasetDir(ds), provenanceFile), &prov)
+	if os.IsNotExist(err) {
+		return prov, nil
+	}
+	return prov, err
+}
+
+func (s *fileStore) lockPath(ds Dataset) string {
+	dir := s.datasetDir(ds)
+	return filepath.Join(filepath.Dir(dir), ".lock."+filepath.Base(dir))
+}
+
+// readLock returns the owner of the lock file at path and when its lease was
+// last renewed
+func readLock(path string) (lockOwner, time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return lockOwner{}, time.Time{}, err
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return lockOwner{}, time.Time{}, err
+	}
+	owner, err := decodeLockOwner(d)
+	return owner, fi.ModTime(), err
+}
+
+// tryLock links a file holding owner to the lock path, which fails if the
+// lock is held, so readers never see a partially written lock. A lock that
+// has not been renewed for ttl is removed and taken over.
+func (s *fileStore) tryLock(ds Dataset, owner lockOwner, ttl time.Duration) (*lockOwner, error) {
+	v, err := encodeLockOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.lockPath(ds)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	tmpFile := fmt.Sprintf("%s.%s", path, owner.Token)
+	if err = ioutil.WriteFile(tmpFile, v, 0644); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(tmpFile)
+	}()
+
+	for {
+		err = os.Link(tmpFile, path)
+		if err == nil {
+			return nil, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		holder, renewed, err := readLock(path)
+		if os.IsNotExist(err) {
+			// released in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(renewed) < ttl {
+			return &holder, nil
+		}
+
+		helpers.PrintBegin("taking over stale import lock of %s held by %s", ds, holder)
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+func (s *fileStore) renewLock(ds Dataset, owner lockOwner, ttl time.Duration) error {
+	path := s.lockPath(ds)
+	holder, _, err := readLock(path)
+	if os.IsNotExist(err) || (err == nil && holder.Token != owner.Token) {
+		return errLockLost(ds)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+func (s *fileStore) unlock(ds Dataset, owner lockOwner) error {
+	path := s.lockPath(ds)
+	holder, _, err := readLock(path)
+	if os.IsNotExist(err) || (err == nil && holder.Token != owner.Token) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s *fileStore) Lock(ds Dataset, ttl time.Duration) (func() error, error) {
+	return s.leases.lock(s, ds, ttl)
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
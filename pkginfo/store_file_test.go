@@ -0,0 +1,471 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/mixer-tools/swupd"
+	"github.com/go-test/deep"
+)
+
+func newTestFileStore(t *testing.T) (*fileStore, func()) {
+	dir, err := ioutil.TempDir("", "diva-filestore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestFileStoreRepo(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	repo := &Repo{
+		BaseInfo: BaseInfo{
+			Name:    "testrepo",
+			Version: "100",
+		},
+		Type: "B",
+		Packages: []*RPM{
+			{
+				Name:     "testpkg",
+				Version:  "100",
+				Release:  "1",
+				Provides: []Dependency{{Name: "one"}, {Name: "two", Flags: DepFlagEqual, EVR: "1.0-1"}},
+				Files: []*File{
+					{Name: "f1"},
+					{Name: "f2"},
+				},
+			},
+		},
+	}
+
+	if err := s.StoreRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &Repo{BaseInfo: repo.BaseInfo, Type: repo.Type}
+	if err := s.GetRepo(got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Packages) != 1 {
+		t.Fatalf("expected 1 package but got %d", len(got.Packages))
+	}
+
+	p := got.Packages[0]
+	if p.Name != "testpkg" || len(p.Files) != 2 {
+		t.Errorf("package was not stored correctly: %+v", p)
+	}
+	if diff := deep.Equal(p.Provides, repo.Packages[0].Provides); diff != nil {
+		t.Error(diff)
+	}
+
+	if ok, err := s.IsComplete(repo.Dataset()); err != nil || !ok {
+		t.Errorf("expected repo to be complete, got %v, %v", ok, err)
+	}
+
+	if _, err := s.GetRPM(got, "notthere"); err == nil {
+		t.Error("expected error getting non-existent RPM")
+	}
+
+	other := &Repo{BaseInfo: BaseInfo{Name: "testrepo", Version: "101"}, Type: "B"}
+	if err := s.GetRepo(other); err != errNoRepoData {
+		t.Errorf("expected errNoRepoData but got %v", err)
+	}
+
+	if ok, _ := s.IsComplete(other.Dataset()); ok {
+		t.Error("expected missing repo not to be complete")
+	}
+}
+
+func TestFileStoreRPMVariants(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	repo := &Repo{
+		BaseInfo: BaseInfo{Name: "testrepo", Version: "100"},
+		Type:     "B",
+		Packages: []*RPM{
+			{Name: "testpkg", Version: "1", Release: "1", Architecture: "x86_64"},
+			{Name: "testpkg", Version: "2", Release: "1", Architecture: "i686"},
+			{Name: "testpkg", Version: "2", Release: "1", Architecture: "x86_64"},
+			{Name: "testpkg-extras", Version: "3", Release: "1", Architecture: "x86_64"},
+		},
+	}
+	if err := s.StoreRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &Repo{BaseInfo: repo.BaseInfo, Type: repo.Type}
+	if err := s.GetRepo(got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Packages) != 4 {
+		t.Errorf("expected 4 packages but got %d", len(got.Packages))
+	}
+
+	rpms, err := s.GetRPMVariants(got, "testpkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nvras := []string{}
+	for _, r := range rpms {
+		nvras = append(nvras, r.NVRA())
+	}
+	expected := []string{"testpkg-2-1.x86_64", "testpkg-2-1.i686", "testpkg-1-1.x86_64"}
+	if diff := deep.Equal(nvras, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	p, err := s.GetRPM(got, "testpkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.NVRA() != expected[0] {
+		t.Errorf("expected GetRPM to return %s but got %s", expected[0], p.NVRA())
+	}
+
+	// a package stored later is found by the cached name index as well
+	extra := &RPM{Name: "testpkg", Version: "3", Release: "1", Architecture: "noarch"}
+	if err = s.StoreRPM(got, extra); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = s.GetRPM(got, "testpkg"); err != nil || p.NVRA() != extra.NVRA() {
+		t.Errorf("expected GetRPM to return %s but got %v, %v", extra.NVRA(), p, err)
+	}
+}
+
+func TestFileStoreArches(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	base := BaseInfo{Name: "clear", Version: "100"}
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		repo := &Repo{
+			BaseInfo: base,
+			Type:     "B",
+			Arch:     arch,
+			Packages: []*RPM{{Name: "bash", Version: "5.0", Release: "1", Architecture: arch}},
+		}
+		if err := s.StoreRepo(repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		got := &Repo{BaseInfo: base, Type: "B", Arch: arch}
+		p, err := s.GetRPM(got, "bash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Architecture != arch {
+			t.Errorf("expected bash of the %s repo to be %s but got %s", arch, arch, p.Architecture)
+		}
+	}
+
+	datasets, err := s.ListDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	arches := []string{}
+	for _, ds := range datasets {
+		arches = append(arches, ds.Arch)
+	}
+	if diff := deep.Equal(arches, []string{"aarch64", "x86_64"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// an empty arch is the default arch
+	if ok, err := s.IsComplete(Dataset{Kind: DatasetRepo, Name: "clear", Version: "100", Type: "B"}); err != nil || !ok {
+		t.Errorf("expected default arch repo to be complete, got %v, %v", ok, err)
+	}
+}
+
+func TestFileStoreImportReplaces(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	repo := &Repo{
+		BaseInfo: BaseInfo{Name: "testrepo", Version: "100"},
+		Type:     "B",
+		Packages: []*RPM{{Name: "old"}},
+	}
+	if err := s.StoreRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.Packages = []*RPM{{Name: "new"}}
+	if err := s.StoreRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &Repo{BaseInfo: repo.BaseInfo, Type: repo.Type}
+	if err := s.GetRepo(got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Packages) != 1 || got.Packages[0].Name != "new" {
+		t.Errorf("expected only the new package but got %+v", got.Packages)
+	}
+
+	// nothing but the dataset itself may be left behind by the swap
+	fis, err := ioutil.ReadDir(filepath.Dir(s.repoDir(repo)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Errorf("expected staging directories to be removed, found %d entries", len(fis))
+	}
+}
+
+func TestFileStoreImportFailure(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	dir := filepath.Join(s.root, "repos", "testrepo", "100", "B")
+	err := importDir(dir, func(staging string) error {
+		return errors.New("import failed")
+	}, nil)
+	if err == nil {
+		t.Fatal("expected import error to be returned")
+	}
+
+	repo := &Repo{BaseInfo: BaseInfo{Name: "testrepo", Version: "100"}, Type: "B"}
+	if ok, _ := s.IsComplete(repo.Dataset()); ok {
+		t.Error("expected failed import not to be complete")
+	}
+
+	fis, err := ioutil.ReadDir(filepath.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 0 {
+		t.Errorf("expected staging directory to be removed, found %d entries", len(fis))
+	}
+}
+
+func TestFileStoreBundles(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	bundleInfo := &BundleInfo{
+		BaseInfo: BaseInfo{
+			Name:       "clear",
+			Version:    "22000",
+			Provenance: Provenance{BundlesCommit: "0123abc", DivaVersion: "1.0"},
+		},
+	}
+
+	bundles := bundle.DefinitionsSet{
+		"TestBundle": &bundle.Definition{
+			Name: "TestBundle",
+			Header: bundle.Header{
+				Title: "TestBundle",
+			},
+			AllPackages: map[string]bool{"pkg": true},
+		},
+	}
+
+	if err := s.StoreBundles(bundleInfo, &bundles); err != nil {
+		t.Fatal(err)
+	}
+
+	bundleInfo.BundleDefinitions = make(bundle.DefinitionsSet)
+	if err := s.GetBundles(bundleInfo, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	b, ok := bundleInfo.BundleDefinitions["TestBundle"]
+	if !ok {
+		t.Fatal("TestBundle was not loaded")
+	}
+
+	if b.Header.Title != "TestBundle" || !b.AllPackages["pkg"] {
+		t.Errorf("bundle was not stored correctly: %+v", b)
+	}
+
+	if b.Includes == nil || b.DirectPackages == nil {
+		t.Error("expected empty sets to be initialized")
+	}
+
+	// the provenance is stored with the bundles but is not one of them
+	if len(bundleInfo.BundleDefinitions) != 1 {
+		t.Errorf("expected 1 bundle but got %d", len(bundleInfo.BundleDefinitions))
+	}
+	prov, err := s.GetProvenance(bundleInfo.Dataset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov.BundlesCommit != "0123abc" {
+		t.Errorf("provenance was not stored correctly: %+v", prov)
+	}
+}
+
+func TestFileStoreManifests(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	mInfo := &ManifestInfo{
+		BundleInfo: BundleInfo{
+			BaseInfo: BaseInfo{
+				Name:    "clear",
+				Version: "22000",
+			},
+		},
+		Manifests: make(map[string]*swupd.Manifest),
+	}
+
+	manifests := []*swupd.Manifest{
+		{
+			Name:   "MoM",
+			Header: swupd.ManifestHeader{Version: 22000},
+			Files: []*swupd.File{
+				{Name: "bundleOne", Version: 21000},
+			},
+		},
+		{
+			Name:   "bundleOne",
+			Header: swupd.ManifestHeader{Version: 21000},
+			Files: []*swupd.File{
+				{Name: "/usr/bin/one", Version: 21000},
+			},
+		},
+	}
+
+	if err := s.StoreManifests(mInfo, manifests); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.GetManifests(mInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := s.IsComplete(mInfo.Dataset()); err != nil || !ok {
+		t.Errorf("expected manifests to be complete, got %v, %v", ok, err)
+	}
+
+	if mInfo.MoM == nil || mInfo.MoM.Name != "MoM" {
+		t.Fatal("MoM was not loaded")
+	}
+
+	m, ok := mInfo.Manifests["bundleOne"]
+	if !ok {
+		t.Fatal("bundleOne manifest was not loaded")
+	}
+
+	if len(m.Files) != 1 || m.Files[0].Name != "/usr/bin/one" {
+		t.Errorf("manifest files were not stored correctly: %+v", m.Files)
+	}
+}
+
+func TestFileStoreSchema(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	if v, err := s.schema(); err != nil || v != fileSchema {
+		t.Fatalf("expected layout %d to be stored but got %d, %v", fileSchema, v, err)
+	}
+
+	if err := writeGob(filepath.Join(s.root, "schema.gob"), fileSchema+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFileStore(s.root); err == nil {
+		t.Error("expected newer layout to be rejected")
+	}
+}
+
+func TestFileStoreListAndRemove(t *testing.T) {
+	s, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	repo := &Repo{
+		BaseInfo: BaseInfo{Name: "clear", Version: "100"},
+		Type:     "B",
+		Packages: []*RPM{{Name: "one"}, {Name: "two"}},
+	}
+	if err := s.StoreRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	// version 200 lists bundleOne from version 100
+	momVersions := map[string]uint32{"100": 100, "200": 200}
+	for _, v := range []string{"100", "200"} {
+		mInfo := &ManifestInfo{BundleInfo: BundleInfo{BaseInfo: BaseInfo{Name: "clear", Version: v}}}
+		manifests := []*swupd.Manifest{
+			{
+				Name:   "MoM",
+				Header: swupd.ManifestHeader{Version: momVersions[v]},
+				Files:  []*swupd.File{{Name: "bundleOne", Version: 100}},
+			},
+			{Name: "bundleOne", Header: swupd.ManifestHeader{Version: 100}},
+		}
+		if err := s.StoreManifests(mInfo, manifests); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	datasets, err := s.ListDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datasets) != 3 {
+		t.Fatalf("expected 3 datasets but got %+v", datasets)
+	}
+	if datasets[2].Kind != DatasetRepo || datasets[2].Count != 2 {
+		t.Errorf("unexpected repo dataset %+v", datasets[2])
+	}
+
+	if err = s.RemoveDataset(repo.Dataset()); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RemoveDataset(repo.Dataset()); err == nil {
+		t.Error("expected error removing missing dataset")
+	}
+
+	ds := Dataset{Kind: DatasetManifests, Name: "clear", Version: "100"}
+	if err = s.RemoveDataset(ds); err != nil {
+		t.Fatal(err)
+	}
+
+	// bundleOne is still listed by version 200, its own MoM is not
+	dir := s.manifestsDir("clear", "100")
+	if _, err = os.Stat(filepath.Join(dir, "Manifest.bundleOne.gob")); err != nil {
+		t.Errorf("expected manifest in use to be kept: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "Manifest.MoM.gob")); !os.IsNotExist(err) {
+		t.Error("expected unused MoM to be removed")
+	}
+
+	datasets, err = s.ListDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datasets) != 1 || datasets[0].Version != "200" {
+		t.Errorf("expected only manifests 200 to be left but got %+v", datasets)
+	}
+}
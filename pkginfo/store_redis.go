@@ -19,6 +19,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/clearlinux/diva/bundle"
 	"github.com/clearlinux/diva/internal/helpers"
@@ -26,89 +27,329 @@ import (
 	"github.com/gomodule/redigo/redis"
 )
 
-func storeIterableRedisSet(c redis.Conn, key string, value []string) error {
-	for i := range value {
-		if _, err := c.Do("SADD", key, value[i]); err != nil {
+// defaultBatchSize is the number of write commands queued before the
+// pipeline is flushed when no batch size is configured
+const defaultBatchSize = 1000
+
+// retiredGrace is how long a generation replaced by a newer import is kept
+// for readers that looked it up before it was replaced
+const retiredGrace = time.Hour
+
+// redisPipeline queues write commands on a connection and sends them to the
+// redis-server in batches, trading one round trip per command for one round
+// trip per batch. Replies are read back on every flush so errors are still
+// reported to the caller.
+type redisPipeline struct {
+	c       redis.Conn
+	size    int
+	pending int
+}
+
+func newRedisPipeline(c redis.Conn, size int) *redisPipeline {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	return &redisPipeline{c: c, size: size}
+}
+
+// send queues the command and flushes the pipeline once it is full
+func (p *redisPipeline) send(cmd string, args ...interface{}) error {
+	if err := p.c.Send(cmd, args...); err != nil {
+		return err
+	}
+	p.pending++
+	if p.pending >= p.size {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush writes all queued commands and reads back their replies, returning
+// the first error reply received
+func (p *redisPipeline) flush() error {
+	if p.pending == 0 {
+		return nil
+	}
+	if err := p.c.Flush(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for ; p.pending > 0; p.pending-- {
+		_, err := p.c.Receive()
+		if err == nil {
+			continue
+		}
+		// a failed connection will not deliver the remaining replies
+		if p.c.Err() != nil {
+			p.pending = 0
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// exec sends EXEC for a transaction queued on the pipeline and returns the
+// first error of the commands it ran. If queueing failed the transaction is
+// discarded so the connection can be returned to the pool.
+func (p *redisPipeline) exec() error {
+	if err := p.flush(); err != nil {
+		_, _ = p.c.Do("DISCARD")
+		return err
+	}
+
+	replies, err := redis.Values(p.c.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	for _, r := range replies {
+		if err, ok := r.(redis.Error); ok {
 			return err
 		}
 	}
 	return nil
 }
 
-// storeRepoInfoRedis stores all data in repo to the running redis-server
-func storeRepoInfoRedis(c redis.Conn, repo *Repo) error {
-	repoKey := fmt.Sprintf("%s%s%s", repo.Name, repo.Version, repo.Type)
-	if _, err := c.Do("SET", repoKey, repo.URI); err != nil {
+// importRedis writes the dataset ds with store into a new generation and
+// commits it once store succeeds, unless check, if any, fails. The
+// generation of a failed import is removed again, and so is the generation
+// of an earlier import of ds that never finished.
+func importRedis(c redis.Conn, ds Dataset, batchSize int, store func(p *redisPipeline, k datasetKeys) error, check func() error) error {
+	if err := collectRetiredRedis(c, batchSize); err != nil {
 		return err
 	}
 
-	for i := range repo.Packages {
-		if err := storeRPMInfoRedis(c, repo, repo.Packages[i]); err != nil {
+	name := datasetName(ds)
+	abandoned, err := redis.String(c.Do("HGET", importingKey, name))
+	if err == nil {
+		err = removeGenerationRedis(c, abandoned, batchSize)
+	}
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+
+	id, err := newStagingID()
+	if err != nil {
+		return err
+	}
+	k := generationKeys(ds, generationKey(id))
+	if _, err = c.Do("HSET", importingKey, name, k.root); err != nil {
+		return err
+	}
+
+	p := newRedisPipeline(c, batchSize)
+	err = store(p, k)
+	if err == nil {
+		err = p.send("SET", k.importedKey(), time.Now().Unix())
+	}
+	if err == nil {
+		err = p.flush()
+	}
+	if err == nil && check != nil {
+		err = check()
+	}
+	if err == nil {
+		err = commitRedis(c, ds, k.root)
+	}
+	if err != nil {
+		// a generation that cannot be removed now is removed by the next
+		// import of ds, it is never read either way
+		_ = removeGenerationRedis(c, k.root, batchSize)
+		return err
+	}
+	return nil
+}
+
+// commitRedis points ds at the generation gen with a single HSET, so readers
+// only ever see the old or the new dataset. The generation it replaces is
+// retired rather than removed, as readers may still be reading it.
+func commitRedis(c redis.Conn, ds Dataset, gen string) error {
+	name := datasetName(ds)
+	old, err := redis.String(c.Do("HGET", completeKey, name))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+
+	p := newRedisPipeline(c, 0)
+	if err = p.send("MULTI"); err != nil {
+		return err
+	}
+	if err = p.send("HSET", completeKey, name, gen); err != nil {
+		return err
+	}
+	if err = p.send("HDEL", importingKey, name); err != nil {
+		return err
+	}
+	if old != "" {
+		if err = p.send("ZADD", retiredKey, time.Now().Unix(), old); err != nil {
 			return err
 		}
 	}
+	return p.exec()
+}
 
+// collectRetiredRedis removes the generations retired longer than
+// retiredGrace ago
+func collectRetiredRedis(c redis.Conn, batchSize int) error {
+	before := time.Now().Add(-retiredGrace).Unix()
+	gens, err := redis.Strings(c.Do("ZRANGEBYSCORE", retiredKey, "-inf", before))
+	if err != nil {
+		return err
+	}
+	for _, gen := range gens {
+		if err = removeGenerationRedis(c, gen, batchSize); err != nil {
+			return err
+		}
+		if _, err = c.Do("ZREM", retiredKey, gen); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// storeRPMInfoRedis stores the rpm under the constructed repo key in the
-// running redis-server
-func storeRPMInfoRedis(c redis.Conn, repo *Repo, rpm *RPM) error {
-	repoKey := fmt.Sprintf("%s%s%s", repo.Name, repo.Version, repo.Type)
-	if _, err := c.Do("SADD", repoKey+":packages", rpm.Name); err != nil {
+// removeGenerationRedis deletes the generation gen and all keys below it
+func removeGenerationRedis(c redis.Conn, gen string, batchSize int) error {
+	keys, err := scanKeys(c, subkeysPattern(gen))
+	if err != nil {
 		return err
 	}
-	pkgKey := fmt.Sprintf("%s:%s", repoKey, rpm.Name)
-	_, err := c.Do("HMSET", redis.Args{}.Add(pkgKey).AddFlat(rpm)...)
+
+	p := newRedisPipeline(c, batchSize)
+	for _, key := range append(keys, gen) {
+		if err = p.send("DEL", key); err != nil {
+			return err
+		}
+	}
+	return p.flush()
+}
+
+// isCompleteRedis reports whether the import of ds was committed
+func isCompleteRedis(c redis.Conn, ds Dataset) (bool, error) {
+	return redis.Bool(c.Do("HEXISTS", completeKey, datasetName(ds)))
+}
+
+func storeIterableRedisSet(p *redisPipeline, key string, value []string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	return p.send("SADD", redis.Args{}.Add(key).AddFlat(value)...)
+}
+
+// storeRepoInfoRedis stores all data in repo under the repo keys k through
+// the pipeline
+func storeRepoInfoRedis(p *redisPipeline, k datasetKeys, repo *Repo) error {
+	if err := p.send("SET", k.root, repo.URI); err != nil {
+		return err
+	}
+
+	for i := range repo.Packages {
+		if err := storeRPMInfoRedis(p, k, repo.Packages[i]); err != nil {
+			return err
+		}
+	}
+
+	return p.flush()
+}
+
+// storeRPMInfoRedis queues the rpm under the repo keys k on the pipeline.
+// The caller is responsible for flushing the pipeline.
+func storeRPMInfoRedis(p *redisPipeline, k datasetKeys, rpm *RPM) error {
+	nvra := rpm.NVRA()
+	if err := p.send("SADD", k.packagesKey(), nvra); err != nil {
+		return err
+	}
+	if err := p.send("SADD", k.pkgNameKey(rpm.Name), nvra); err != nil {
+		return err
+	}
+	pkgKey := k.pkgKey(nvra)
+	args := redis.Args{}.Add(pkgKey).AddFlat(rpm)
+	depArgs, err := dependencyArgs(rpm)
 	if err != nil {
 		return err
 	}
+	if err = p.send("HMSET", append(args, depArgs...)...); err != nil {
+		return err
+	}
 
-	// store file index mapping at reponame:packagename:files
+	if len(rpm.Files) == 0 {
+		return nil
+	}
+
+	// store file index mapping at pkgKey:files
 	//             filename -> fileN
-	// store each file map at reponame:packagename:fileN
+	// store each file map at pkgKey:fileN
 	//             fileN -> File{}
-	fKey := pkgKey + ":files"
+	fMap := make(map[string]string, len(rpm.Files))
 	for fIdx, f := range rpm.Files {
-		val := fmt.Sprintf("file%d", fIdx)
-		fMap := map[string]string{f.Name: val}
-		_, err := c.Do("HMSET", redis.Args{}.Add(fKey).AddFlat(fMap)...)
-		if err != nil {
-			return err
-		}
+		fMap[f.Name] = fmt.Sprintf("file%d", fIdx)
 		fIdxKey := fmt.Sprintf("%s:file%d", pkgKey, fIdx)
-		_, err = c.Do("HMSET", redis.Args{}.Add(fIdxKey).AddFlat(f)...)
-		if err != nil {
+		if err := p.send("HMSET", redis.Args{}.Add(fIdxKey).AddFlat(f)...); err != nil {
 			return err
 		}
 	}
-	return nil
+	return p.send("HMSET", redis.Args{}.Add(pkgKey+":files").AddFlat(fMap)...)
 }
 
-func storeMapAsSliceRedis(c redis.Conn, key string, val map[string]bool) error {
+// dependencyField is an RPM field holding dependencies. Those do not flatten
+// into a hash, so each field is stored gob encoded.
+type dependencyField struct {
+	name string
+	deps *[]Dependency
+}
+
+func dependencyFields(rpm *RPM) []dependencyField {
+	return []dependencyField{
+		{"Requires", &rpm.Requires},
+		{"BuildRequires", &rpm.BuildRequires},
+		{"Provides", &rpm.Provides},
+		{"Conflicts", &rpm.Conflicts},
+		{"Obsoletes", &rpm.Obsoletes},
+		{"Recommends", &rpm.Recommends},
+		{"Suggests", &rpm.Suggests},
+	}
+}
+
+// dependencyArgs returns the field/value pairs of the gob encoded
+// dependencies of rpm
+func dependencyArgs(rpm *RPM) (redis.Args, error) {
+	args := redis.Args{}
+	for _, f := range dependencyFields(rpm) {
+		b := bytes.Buffer{}
+		if err := gob.NewEncoder(&b).Encode(*f.deps); err != nil {
+			return nil, err
+		}
+		args = args.Add(f.name, b.Bytes())
+	}
+	return args, nil
+}
+
+func storeMapAsSliceRedis(p *redisPipeline, key string, val map[string]bool) error {
 	valSlice, err := helpers.HashmapToSortedSlice(val)
 	if err != nil {
 		return err
 	}
-	return storeIterableRedisSet(c, key, valSlice)
+	return storeIterableRedisSet(p, key, valSlice)
 }
 
-func storeBundleInfoRedis(c redis.Conn, bundleInfo *BundleInfo, bundleset *bundle.DefinitionsSet) error {
-	bundlesKey := fmt.Sprintf("%s%sbundles", bundleInfo.Name, bundleInfo.Version)
-
+// storeBundleInfoRedis stores all bundle definitions under the bundles keys
+// k through the pipeline
+func storeBundleInfoRedis(p *redisPipeline, k datasetKeys, bundleset *bundle.DefinitionsSet) error {
 	// convert bundle definition set to slice for flat data store
 	bundles := bundle.SetToSlice(*bundleset)
 
 	// store list of all bundles
 	for _, bundle := range bundles {
-		_, err := c.Do("SADD", bundlesKey, bundle.Name)
+		err := p.send("SADD", k.root, bundle.Name)
 		if err != nil {
 			return err
 		}
 
 		// store bundle definitions
-		definitionKey := fmt.Sprintf("%s:%s", bundlesKey, bundle.Name)
-		_, err = c.Do("HMSET", redis.Args{}.Add(definitionKey).AddFlat(bundle)...)
+		definitionKey := k.bundleKey(bundle.Name)
+		err = p.send("HMSET", redis.Args{}.Add(definitionKey).AddFlat(bundle)...)
 		if err != nil {
 			return err
 		}
@@ -118,54 +359,52 @@ func storeBundleInfoRedis(c redis.Conn, bundleInfo *BundleInfo, bundleset *bundl
 		for i := 0; i < header.NumField(); i++ {
 			headerKey := header.Type().Field(i).Name
 			headerValue := header.Field(i).Interface()
-			if _, err = c.Do("SET", definitionKey+":"+headerKey, headerValue); err != nil {
+			if err = p.send("SET", definitionKey+":"+headerKey, headerValue); err != nil {
 				return err
 			}
 		}
 
-		if err = storeMapAsSliceRedis(c, definitionKey+":includes", bundle.Includes); err != nil {
+		if err = storeMapAsSliceRedis(p, definitionKey+":includes", bundle.Includes); err != nil {
 			return err
 		}
-		if err = storeMapAsSliceRedis(c, definitionKey+":directPackages", bundle.DirectPackages); err != nil {
+		if err = storeMapAsSliceRedis(p, definitionKey+":directPackages", bundle.DirectPackages); err != nil {
 			return err
 		}
-		if err = storeMapAsSliceRedis(c, definitionKey+":allPackages", bundle.AllPackages); err != nil {
+		if err = storeMapAsSliceRedis(p, definitionKey+":allPackages", bundle.AllPackages); err != nil {
 			return err
 		}
 	}
-	return nil
+	return p.flush()
 }
 
-func storeManifestFile(c redis.Conn, key, ftype string, files []*swupd.File) error {
-	// store file index mapping at key:itemname:files
-	//             filename -> fileN
-	// store each file map at key:itemname:fileN
-	//             fileN -> File{}
-	fKey := key + ftype
+func storeManifestFile(p *redisPipeline, key, ftype, idxPrefix string, files []*swupd.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	// store file index mapping at key:ftype
+	//             filename -> <idxPrefix>N
+	// store each file map at key:<idxPrefix>N
+	//             <idxPrefix>N -> File{}
+	fMap := make(map[string]string, len(files))
 	for fIdx, f := range files {
-		val := fmt.Sprintf("file%d", fIdx)
-		fMap := map[string]string{f.Name: val}
-		_, err := c.Do("HMSET", redis.Args{}.Add(fKey).AddFlat(fMap)...)
-		if err != nil {
-			return err
-		}
+		fMap[f.Name] = fmt.Sprintf("%s%d", idxPrefix, fIdx)
 
 		// Encode the file struct prior to storing it in the redis database
 		b := bytes.Buffer{}
-		fIdxKey := fmt.Sprintf("%s:file%d", key, fIdx)
-		err = gob.NewEncoder(&b).Encode(f)
+		fIdxKey := fmt.Sprintf("%s:%s%d", key, idxPrefix, fIdx)
+		err := gob.NewEncoder(&b).Encode(f)
 		if err != nil {
 			return err
 		}
-		_, err = c.Do("SET", fIdxKey, b.Bytes())
-		if err != nil {
+		if err = p.send("SET", fIdxKey, b.Bytes()); err != nil {
 			return err
 		}
 	}
-	return nil
+	return p.send("HMSET", redis.Args{}.Add(key+ftype).AddFlat(fMap)...)
 }
 
-func storeManifestHeader(c redis.Conn, header *swupd.ManifestHeader, key string) error {
+func storeManifestHeader(p *redisPipeline, header *swupd.ManifestHeader, key string) error {
 	var err error
 	b := bytes.Buffer{}
 
@@ -174,53 +413,58 @@ func storeManifestHeader(c redis.Conn, header *swupd.ManifestHeader, key string)
 	if err != nil {
 		return err
 	}
-	_, err = c.Do("SET", fmt.Sprintf("%s:Header", key), b.Bytes())
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return p.send("SET", fmt.Sprintf("%s:Header", key), b.Bytes())
 }
 
-// manifests are stored by the version they were created/changed in, not necessarily
-// the version of the MoM, or the version requested.
-func storeManifestRedis(c redis.Conn, mInfo *ManifestInfo, manifests []*swupd.Manifest) error {
-	momKey := fmt.Sprintf("%s%smanifests", mInfo.Name, mInfo.Version)
-
+// storeManifestRedis stores the manifests under the manifests keys k. The
+// writes are sent through the pipeline.
+func storeManifestRedis(p *redisPipeline, k datasetKeys, manifests []*swupd.Manifest) error {
 	for _, manifest := range manifests {
 		// store list of all manifest names
-		_, err := c.Do("SADD", momKey, manifest.Name)
+		err := p.send("SADD", k.root, manifest.Name)
 		if err != nil {
 			return err
 		}
 
-		// manifest should be stored with version of that bundle
-		manifestKey := fmt.Sprintf("%s%smanifests:%s", mInfo.Name, fmt.Sprint(manifest.Header.Version), manifest.Name)
+		manifestKey := k.manifestKey(fmt.Sprint(manifest.Header.Version), manifest.Name)
 
 		// store the entire manifest object
-		_, err = c.Do("HMSET", redis.Args{}.Add(manifestKey).AddFlat(manifest)...)
+		err = p.send("HMSET", redis.Args{}.Add(manifestKey).AddFlat(manifest)...)
 		if err != nil {
 			return err
 		}
 
 		// store manifest header
-		err = storeManifestHeader(c, &manifest.Header, manifestKey)
+		err = storeManifestHeader(p, &manifest.Header, manifestKey)
 		if err != nil {
 			return err
 		}
 
 		// store manifest files
-		err = storeManifestFile(c, manifestKey, ":Files", manifest.Files)
+		err = storeManifestFile(p, manifestKey, ":Files", "file", manifest.Files)
 		if err != nil {
 			return err
 		}
 
 		// store manifest deleted files
-		err = storeManifestFile(c, manifestKey, ":DeletedFiles", manifest.DeletedFiles)
+		err = storeManifestFile(p, manifestKey, ":DeletedFiles", "deleted", manifest.DeletedFiles)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return p.flush()
+}
+
+// storeProvenanceRedis stores the gob encoded provenance under the dataset
+// keys k through the pipeline
+func storeProvenanceRedis(p *redisPipeline, k datasetKeys, prov *Provenance) error {
+	b := bytes.Buffer{}
+	if err := gob.NewEncoder(&b).Encode(prov); err != nil {
+		return err
+	}
+	if err := p.send("SET", k.provenanceKey(), b.Bytes()); err != nil {
+		return err
+	}
+	return p.flush()
 }
@@ -15,6 +15,9 @@
 package pkginfo
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -42,7 +45,7 @@ func TestStoreRPMInfoRedis(t *testing.T) {
 				Name:     "testpkg",
 				Version:  "100",
 				Release:  "1",
-				Provides: []string{"one", "two"},
+				Provides: []Dependency{{Name: "one"}, {Name: "two"}},
 				Files: []*File{
 					{Name: "f1"},
 					{Name: "f2"},
@@ -51,7 +54,8 @@ func TestStoreRPMInfoRedis(t *testing.T) {
 		},
 	}
 
-	if err := storeRepoInfoRedis(conn, repo); err != nil {
+	k := generationKeys(repo.Dataset(), generationKey("0123456789abcdef"))
+	if err := storeRepoInfoRedis(newRedisPipeline(conn, defaultBatchSize), k, repo); err != nil {
 		t.Fatal(err)
 	}
 
@@ -86,7 +90,8 @@ func TestStoreBundleInfoRedis(t *testing.T) {
 		},
 	}
 
-	err := storeBundleInfoRedis(conn, bundleInfo, &BundleDefinitions)
+	k := generationKeys(bundleInfo.Dataset(), generationKey("0123456789abcdef"))
+	err := storeBundleInfoRedis(newRedisPipeline(conn, defaultBatchSize), k, &BundleDefinitions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,11 +124,16 @@ func TestStoreManifestHeaderRedis(t *testing.T) {
 		},
 	}
 
-	err := storeManifestHeader(conn, &header, "keymanifests")
+	p := newRedisPipeline(conn, defaultBatchSize)
+	err := storeManifestHeader(p, &header, "keymanifests")
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if err = p.flush(); err != nil {
+		t.Fatal(err)
+	}
+
 	for _, c := range cmds {
 		if conn.Stats(c) == 0 {
 			t.Errorf("expected command %s %s was not called", c.Name, c.Args)
@@ -179,7 +189,8 @@ func TestStoreManifestInfoRedis(t *testing.T) {
 		},
 	}
 
-	err := storeManifestRedis(conn, mInfo, manifests)
+	k := generationKeys(mInfo.Dataset(), generationKey("0123456789abcdef"))
+	err := storeManifestRedis(newRedisPipeline(conn, defaultBatchSize), k, manifests)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -190,3 +201,270 @@ func TestStoreManifestInfoRedis(t *testing.T) {
 		}
 	}
 }
+
+func TestRedisPipelineBatches(t *testing.T) {
+	conn := redigomock.NewConn()
+	cmd := conn.GenericCommand("SET").Expect("ok")
+
+	p := newRedisPipeline(conn, 2)
+	for i := 0; i < 3; i++ {
+		if err := p.send("SET", fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first two commands fill a batch and are flushed automatically
+	if conn.Stats(cmd) != 2 {
+		t.Errorf("expected 2 commands sent before flush but got %d", conn.Stats(cmd))
+	}
+
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.Stats(cmd) != 3 {
+		t.Errorf("expected 3 commands sent after flush but got %d", conn.Stats(cmd))
+	}
+}
+
+func TestRedisPipelineError(t *testing.T) {
+	conn := redigomock.NewConn()
+	conn.GenericCommand("SET").ExpectError(errors.New("write failed"))
+
+	p := newRedisPipeline(conn, 10)
+	if err := p.send("SET", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.flush(); err == nil {
+		t.Error("expected error reply to be returned by flush")
+	}
+
+	if p.pending != 0 {
+		t.Errorf("expected all replies to be read but %d are pending", p.pending)
+	}
+}
+
+// emptyScan is the reply to a SCAN that matched no keys
+var emptyScan = []interface{}{[]byte("0"), []interface{}{}}
+
+func TestImportRedisCommit(t *testing.T) {
+	repo := &Repo{
+		BaseInfo: BaseInfo{
+			Version: "100",
+			Name:    "testrepo",
+		},
+		Type: "B",
+		Packages: []*RPM{
+			{Name: "testpkg"},
+		},
+	}
+	name := datasetName(repo.Dataset())
+	old := generationKey("0000000000000001")
+
+	conn := redigomock.NewConn()
+	conn.GenericCommand("ZRANGEBYSCORE").ExpectSlice()
+	conn.Command("HGET", importingKey, name).Expect(nil)
+	conn.GenericCommand("HSET").Expect(int64(1))
+	conn.GenericCommand("SET").Expect("ok")
+	conn.GenericCommand("SADD").Expect("ok")
+	conn.GenericCommand("HMSET").Expect("ok")
+	conn.Command("HGET", completeKey, name).Expect([]byte(old))
+	conn.Command("MULTI").Expect("OK")
+	conn.Command("HDEL", importingKey, name).Expect("QUEUED")
+	retire := conn.GenericCommand("ZADD").Expect("QUEUED")
+	exec := conn.Command("EXEC").ExpectSlice(int64(0), int64(1), int64(1))
+	del := conn.GenericCommand("DEL").Expect(int64(1))
+
+	var gen string
+	err := importRedis(conn, repo.Dataset(), defaultBatchSize, func(p *redisPipeline, k datasetKeys) error {
+		gen = k.root
+		return storeRepoInfoRedis(p, k, repo)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gen == old || !strings.HasPrefix(gen, generationNamespace) {
+		t.Errorf("expected the import to write a new generation but got %s", gen)
+	}
+	if conn.Stats(exec) != 1 {
+		t.Error("expected the import to be committed")
+	}
+	// the replaced generation may still be read, so it is only retired
+	if conn.Stats(retire) != 1 || conn.Stats(del) != 0 {
+		t.Errorf("expected the old generation to be retired, not removed")
+	}
+}
+
+func TestImportRedisRemovesAbandoned(t *testing.T) {
+	ds := Dataset{Kind: DatasetBundles, Name: "clear", Version: "100"}
+	abandoned := generationKey("0000000000000001")
+	retired := generationKey("0000000000000002")
+
+	conn := redigomock.NewConn()
+	conn.GenericCommand("ZRANGEBYSCORE").ExpectStringSlice(retired)
+	conn.Command("SCAN", 0, "MATCH", subkeysPattern(retired), "COUNT", 1000).ExpectSlice(emptyScan...)
+	delRetired := conn.Command("DEL", retired).Expect(int64(1))
+	unretire := conn.Command("ZREM", retiredKey, retired).Expect(int64(1))
+	conn.Command("HGET", importingKey, datasetName(ds)).Expect([]byte(abandoned))
+	conn.Command("SCAN", 0, "MATCH", subkeysPattern(abandoned), "COUNT", 1000).ExpectSlice(
+		[]byte("0"), []interface{}{[]byte(abandoned + ":provenance")})
+	delAbandoned := []*redigomock.Cmd{
+		conn.Command("DEL", abandoned).Expect(int64(1)),
+		conn.Command("DEL", abandoned+":provenance").Expect(int64(1)),
+	}
+	conn.GenericCommand("HSET").Expect(int64(1))
+	conn.GenericCommand("SCAN").ExpectSlice(emptyScan...)
+	conn.GenericCommand("DEL").Expect(int64(1))
+
+	err := importRedis(conn, ds, defaultBatchSize, func(p *redisPipeline, k datasetKeys) error {
+		return errors.New("import failed")
+	}, nil)
+	if err == nil {
+		t.Fatal("expected import error to be returned")
+	}
+
+	if conn.Stats(delRetired) != 1 || conn.Stats(unretire) != 1 {
+		t.Error("expected the retired generation to be removed")
+	}
+	for _, del := range delAbandoned {
+		if conn.Stats(del) != 1 {
+			t.Errorf("expected %v of the abandoned import to be removed", del.Args)
+		}
+	}
+}
+
+func TestImportRedisCommitFailed(t *testing.T) {
+	repo := &Repo{BaseInfo: BaseInfo{Version: "100", Name: "testrepo"}, Type: "B"}
+
+	conn := redigomock.NewConn()
+	conn.GenericCommand("ZRANGEBYSCORE").ExpectSlice()
+	conn.GenericCommand("HGET").Expect(nil)
+	conn.GenericCommand("HSET").Expect(int64(1))
+	conn.GenericCommand("SET").Expect("ok")
+	conn.Command("MULTI").Expect("OK")
+	conn.GenericCommand("HDEL").Expect("QUEUED")
+	conn.Command("EXEC").ExpectError(errors.New("connection reset"))
+	conn.GenericCommand("SCAN").ExpectSlice(emptyScan...)
+	del := conn.GenericCommand("DEL").Expect(int64(1))
+
+	err := importRedis(conn, repo.Dataset(), defaultBatchSize, func(p *redisPipeline, k datasetKeys) error {
+		return p.send("SET", k.root, "uri")
+	}, nil)
+	if err == nil {
+		t.Fatal("expected commit error to be returned")
+	}
+
+	if conn.Stats(del) != 1 {
+		t.Error("expected the generation to be removed after the failed commit")
+	}
+}
+
+func TestImportRedisCheckFailed(t *testing.T) {
+	conn := redigomock.NewConn()
+	conn.GenericCommand("ZRANGEBYSCORE").ExpectSlice()
+	conn.GenericCommand("HGET").Expect(nil)
+	conn.GenericCommand("HSET").Expect(int64(1))
+	conn.GenericCommand("SET").Expect("ok")
+	conn.GenericCommand("SCAN").ExpectSlice(emptyScan...)
+	del := conn.GenericCommand("DEL").Expect(int64(1))
+	exec := conn.Command("EXEC").ExpectSlice()
+
+	repo := &Repo{BaseInfo: BaseInfo{Version: "100", Name: "testrepo"}, Type: "B"}
+	err := importRedis(conn, repo.Dataset(), defaultBatchSize, func(p *redisPipeline, k datasetKeys) error {
+		return p.send("SET", k.root, "uri")
+	}, func() error {
+		return errors.New("lock lost")
+	})
+	if err == nil {
+		t.Fatal("expected check error to be returned")
+	}
+
+	if conn.Stats(del) != 1 {
+		t.Error("expected the generation to be removed")
+	}
+	if conn.Stats(exec) != 0 {
+		t.Error("expected failed import not to be committed")
+	}
+}
+
+func benchmarkRepo(nPkgs, nFiles int) *Repo {
+	repo := &Repo{
+		BaseInfo: BaseInfo{
+			Version: "100",
+			Name:    "benchrepo",
+		},
+		Type: "B",
+	}
+
+	for i := 0; i < nPkgs; i++ {
+		rpm := &RPM{
+			Name:     fmt.Sprintf("pkg%d", i),
+			Version:  "1",
+			Release:  "1",
+			Provides: []Dependency{{Name: fmt.Sprintf("pkg%d", i)}},
+		}
+		for j := 0; j < nFiles; j++ {
+			rpm.Files = append(rpm.Files, &File{Name: fmt.Sprintf("/usr/share/pkg%d/f%d", i, j)})
+		}
+		repo.Packages = append(repo.Packages, rpm)
+	}
+	return repo
+}
+
+func benchmarkStoreRepoInfoRedis(b *testing.B, batchSize int) {
+	repo := benchmarkRepo(100, 20)
+	conn := redigomock.NewConn()
+	conn.GenericCommand("SET").Expect("ok")
+	conn.GenericCommand("SADD").Expect("ok")
+	conn.GenericCommand("HMSET").Expect("ok")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := generationKeys(repo.Dataset(), generationKey("0123456789abcdef"))
+		if err := storeRepoInfoRedis(newRedisPipeline(conn, batchSize), k, repo); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoreRepoInfoRedisUnbatched(b *testing.B) {
+	benchmarkStoreRepoInfoRedis(b, 1)
+}
+
+func BenchmarkStoreRepoInfoRedisBatched(b *testing.B) {
+	benchmarkStoreRepoInfoRedis(b, defaultBatchSize)
+}
+
+func BenchmarkStoreManifestRedis(b *testing.B) {
+	mInfo := &ManifestInfo{
+		BundleInfo: BundleInfo{
+			BaseInfo: BaseInfo{
+				Name:    "clear",
+				Version: "22000",
+			},
+		},
+	}
+
+	manifest := &swupd.Manifest{
+		Name:   "bundleOne",
+		Header: swupd.ManifestHeader{Version: 22000},
+	}
+	for i := 0; i < 2000; i++ {
+		manifest.Files = append(manifest.Files, &swupd.File{Name: fmt.Sprintf("/usr/f%d", i), Version: 22000})
+	}
+
+	conn := redigomock.NewConn()
+	conn.GenericCommand("SET").Expect("ok")
+	conn.GenericCommand("SADD").Expect("ok")
+	conn.GenericCommand("HMSET").Expect("ok")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := generationKeys(mInfo.Dataset(), generationKey("0123456789abcdef"))
+		if err := storeManifestRedis(newRedisPipeline(conn, defaultBatchSize), k, []*swupd.Manifest{manifest}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -30,6 +30,8 @@ type BaseInfo struct {
 	Version     string
 	UpstreamURL string
 	CacheLoc    string
+	Database    config.DatabaseConfig
+	Provenance  Provenance
 }
 
 func (b *BaseInfo) updateBaseInfo(u *config.UInfo) error {
@@ -50,17 +52,23 @@ func defaultBaseInfo(conf *config.Config, u *config.UInfo) BaseInfo {
 		Version:     u.Ver,
 		UpstreamURL: conf.UpstreamURL,
 		CacheLoc:    conf.Paths.CacheLocation,
+		Database:    conf.Database,
 	}
 }
 
 // Repo defines the location, name, type, and other metadata about an RPM
 // repository, a slice of pointers to RPMs, as well as an update function
-// to modify the BaseInfo struct with any recent information
+// to modify the BaseInfo struct with any recent information. Arch is the
+// architecture the repo is built for, see repoArch. Keyring is the file of
+// armored public keys the signatures of downloaded RPMs are checked against,
+// signatures are not checked if it is empty.
 type Repo struct {
 	BaseInfo
 	URI      string
 	RPMCache string
 	Type     string
+	Arch     string
+	Keyring  string
 	Priority uint
 	Packages []*RPM
 }
@@ -74,8 +82,9 @@ func updateRepoURI(repo *Repo, loc string) {
 	}
 
 	for _, url := range urls {
-		_, err := helpers.CheckStatus(url)
+		resp, err := helpers.CheckStatus(url)
 		if err == nil {
+			_ = resp.Body.Close()
 			repo.URI = url
 			return
 		}
@@ -96,13 +105,20 @@ func (repo *Repo) updateRepo(u *config.UInfo) error {
 		repo.URI = repo.UpstreamURL
 	}
 
+	repo.Arch = repoArch(repo.Type, repo.Arch)
 	if repo.Type == "SRPM" {
 		updateRepoURI(repo, "source/SRPMS")
 	} else {
-		updateRepoURI(repo, "x86_64/os")
+		updateRepoURI(repo, repo.Arch+"/os")
 	}
 	if repo.RPMCache == config.DefaultConf().Paths.LocalRPMRepo {
+		// repos of the default architecture keep the cache they used before
+		// repos had an architecture, so existing caches are still found
 		repo.RPMCache = fmt.Sprintf("%s/rpms/%s/%s/%s/packages", repo.CacheLoc, repo.Name, repo.Version, repo.Type)
+		if repo.Arch != repoArch(repo.Type, "") {
+			repo.RPMCache = fmt.Sprintf("%s/rpms/%s/%s/%s/%s/packages",
+				repo.CacheLoc, repo.Name, repo.Version, repo.Type, repo.Arch)
+		}
 	}
 	return nil
 }
@@ -111,8 +127,10 @@ func defaultRepo(conf *config.Config, u *config.UInfo) Repo {
 	return Repo{
 		BaseInfo: defaultBaseInfo(conf, u),
 		RPMCache: conf.Paths.LocalRPMRepo,
+		Keyring:  conf.Paths.GPGKeyring,
 		URI:      u.RepoURL,
 		Type:     u.RPMType,
+		Arch:     u.Arch,
 	}
 }
 
@@ -128,16 +146,23 @@ func NewRepo(conf *config.Config, u *config.UInfo) (Repo, error) {
 // and assorted metadata. An RPM can be either a binary or source RPM. If
 // SRPMName is empty this indicates the RPM is already a source RPM. For binary
 // RPMs it will be populated with that RPMs associated source RPM name.
+// Recommends and Suggests are the weak dependencies of the RPM. Source RPMs
+// have BuildRequires instead of Requires. Epoch is empty for epoch 0.
 type RPM struct {
 	Name          string
+	Epoch         string
 	Version       string
 	Release       string
 	Architecture  string
 	SRPMName      string
 	License       string
-	Requires      []string
-	BuildRequires []string
-	Provides      []string
+	Requires      []Dependency `redis:"-"`
+	BuildRequires []Dependency `redis:"-"`
+	Provides      []Dependency `redis:"-"`
+	Conflicts     []Dependency `redis:"-"`
+	Obsoletes     []Dependency `redis:"-"`
+	Recommends    []Dependency `redis:"-"`
+	Suggests      []Dependency `redis:"-"`
 	Files         []*File
 }
 
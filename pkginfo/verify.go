@@ -0,0 +1,306 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/mixer-tools/swupd"
+)
+
+// sortedKeys returns the keys of all given sets in sorted order
+func sortedKeys(sets ...map[string]bool) []string {
+	all := make(map[string]bool)
+	for _, set := range sets {
+		for k := range set {
+			all[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toSet(s []string) map[string]bool {
+	set := make(map[string]bool, len(s))
+	for _, v := range s {
+		set[v] = true
+	}
+	return set
+}
+
+// diffSets describes the members of stored and cached that are only in one
+// of them
+func diffSets(item, what string, stored, cached map[string]bool) []string {
+	drift := []string{}
+	for _, k := range sortedKeys(stored, cached) {
+		switch {
+		case !cached[k]:
+			drift = append(drift, fmt.Sprintf("%s: %s %s is only in the database", item, what, k))
+		case !stored[k]:
+			drift = append(drift, fmt.Sprintf("%s: %s %s is only in the cache", item, what, k))
+		}
+	}
+	return drift
+}
+
+func diffField(item, what string, stored, cached interface{}) []string {
+	if stored == cached {
+		return []string{}
+	}
+	return []string{fmt.Sprintf("%s: %s is %v in the database but %v in the cache", item, what, stored, cached)}
+}
+
+func diffDependencies(item, what string, stored, cached []Dependency) []string {
+	return diffSets(item, what, toSet(dependencyStrings(stored)), toSet(dependencyStrings(cached)))
+}
+
+// diffRPMs describes the differences between two RPMs of the same NVRA
+func diffRPMs(stored, cached *RPM) []string {
+	item := "rpm " + stored.NVRA()
+	drift := []string{}
+	drift = append(drift, diffField(item, "epoch", stored.Epoch, cached.Epoch)...)
+	drift = append(drift, diffField(item, "source rpm", stored.SRPMName, cached.SRPMName)...)
+	drift = append(drift, diffField(item, "license", stored.License, cached.License)...)
+	drift = append(drift, diffDependencies(item, "requirement", stored.Requires, cached.Requires)...)
+	drift = append(drift, diffDependencies(item, "build requirement", stored.BuildRequires, cached.BuildRequires)...)
+	drift = append(drift, diffDependencies(item, "provide", stored.Provides, cached.Provides)...)
+	drift = append(drift, diffDependencies(item, "conflict", stored.Conflicts, cached.Conflicts)...)
+	drift = append(drift, diffDependencies(item, "obsolete", stored.Obsoletes, cached.Obsoletes)...)
+	drift = append(drift, diffDependencies(item, "recommendation", stored.Recommends, cached.Recommends)...)
+	drift = append(drift, diffDependencies(item, "suggestion", stored.Suggests, cached.Suggests)...)
+
+	storedFiles := make(map[string]*File)
+	for _, f := range stored.Files {
+		storedFiles[f.Name] = f
+	}
+	cachedFiles := make(map[string]*File)
+	for _, f := range cached.Files {
+		cachedFiles[f.Name] = f
+	}
+	for _, name := range sortedKeys(fileNameSet(stored.Files), fileNameSet(cached.Files)) {
+		s, c := storedFiles[name], cachedFiles[name]
+		switch {
+		case c == nil:
+			drift = append(drift, fmt.Sprintf("%s: file %s is only in the database", item, name))
+		case s == nil:
+			drift = append(drift, fmt.Sprintf("%s: file %s is only in the cache", item, name))
+		case *s != *c:
+			drift = append(drift, fmt.Sprintf("%s: file %s differs", item, name))
+		}
+	}
+	return drift
+}
+
+func fileNameSet(files []*File) map[string]bool {
+	set := make(map[string]bool, len(files))
+	for _, f := range files {
+		set[f.Name] = true
+	}
+	return set
+}
+
+// diffRepos matches the RPMs of stored and cached by NVRA, so a package
+// whose version changed is reported as one NVRA only in the database and
+// another only in the cache
+func diffRepos(stored, cached []*RPM) []string {
+	storedRPMs := make(map[string]*RPM)
+	for _, r := range stored {
+		storedRPMs[r.NVRA()] = r
+	}
+	cachedRPMs := make(map[string]*RPM)
+	for _, r := range cached {
+		cachedRPMs[r.NVRA()] = r
+	}
+
+	drift := []string{}
+	for _, nvra := range sortedKeys(rpmNVRASet(stored), rpmNVRASet(cached)) {
+		s, c := storedRPMs[nvra], cachedRPMs[nvra]
+		switch {
+		case c == nil:
+			drift = append(drift, fmt.Sprintf("rpm %s is only in the database", nvra))
+		case s == nil:
+			drift = append(drift, fmt.Sprintf("rpm %s is only in the cache", nvra))
+		default:
+			drift = append(drift, diffRPMs(s, c)...)
+		}
+	}
+	return drift
+}
+
+func rpmNVRASet(rpms []*RPM) map[string]bool {
+	set := make(map[string]bool, len(rpms))
+	for _, r := range rpms {
+		set[r.NVRA()] = true
+	}
+	return set
+}
+
+// VerifyRepo re-reads the RPMs in the cache the repo was imported from, or
+// its repo metadata if it was imported from that, and returns a description
+// of every difference from the RPMs stored in the database. Repos imported
+// before their cache was recorded are re-read from the RPMCache of repo.
+func VerifyRepo(repo *Repo) ([]string, error) {
+	stored := &Repo{BaseInfo: repo.BaseInfo, Type: repo.Type, Arch: repo.Arch}
+	if err := PopulateRepo(stored); err != nil {
+		return nil, err
+	}
+
+	cached := &Repo{BaseInfo: repo.BaseInfo, Type: repo.Type, Arch: repo.Arch, RPMCache: repo.RPMCache}
+	if stored.Provenance.CachePath != "" {
+		cached.RPMCache = stored.Provenance.CachePath
+	}
+	if err := loadRepo(cached, stored.Provenance.Repodata); err != nil {
+		return nil, err
+	}
+
+	return diffRepos(stored.Packages, cached.Packages), nil
+}
+
+func diffBundles(stored, cached bundle.DefinitionsSet) []string {
+	storedNames := make(map[string]bool)
+	for name := range stored {
+		storedNames[name] = true
+	}
+	cachedNames := make(map[string]bool)
+	for name := range cached {
+		cachedNames[name] = true
+	}
+
+	drift := []string{}
+	for _, name := range sortedKeys(storedNames, cachedNames) {
+		s, c := stored[name], cached[name]
+		item := "bundle " + name
+		switch {
+		case c == nil:
+			drift = append(drift, item+" is only in the database")
+		case s == nil:
+			drift = append(drift, item+" is only in the cache")
+		default:
+			if s.Header != c.Header {
+				drift = append(drift, item+": header differs")
+			}
+			drift = append(drift, diffSets(item, "include", s.Includes, c.Includes)...)
+			drift = append(drift, diffSets(item, "package", s.AllPackages, c.AllPackages)...)
+		}
+	}
+	return drift
+}
+
+// VerifyBundles re-reads the bundle definitions in the BundleCache of
+// bundleInfo and returns a description of every difference from the bundle
+// definitions stored in the database. The BundleCache must be checked out at
+// the version of bundleInfo.
+func VerifyBundles(bundleInfo *BundleInfo) ([]string, error) {
+	cached, err := bundle.GetAll(bundleInfo.BundleCache)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &BundleInfo{BaseInfo: bundleInfo.BaseInfo, BundleDefinitions: make(bundle.DefinitionsSet)}
+	if err = PopulateBundles(stored, ""); err != nil {
+		return nil, err
+	}
+
+	return diffBundles(stored.BundleDefinitions, cached), nil
+}
+
+func diffManifestFiles(item, what string, stored, cached []*swupd.File) []string {
+	storedFiles := make(map[string]*swupd.File)
+	for _, f := range stored {
+		storedFiles[f.Name] = f
+	}
+	cachedFiles := make(map[string]*swupd.File)
+	for _, f := range cached {
+		cachedFiles[f.Name] = f
+	}
+
+	storedNames := make(map[string]bool)
+	for name := range storedFiles {
+		storedNames[name] = true
+	}
+	cachedNames := make(map[string]bool)
+	for name := range cachedFiles {
+		cachedNames[name] = true
+	}
+
+	drift := diffSets(item, what, storedNames, cachedNames)
+	// hashes are interned per process and cannot be compared, the version a
+	// file last changed in is compared instead
+	for _, name := range sortedKeys(storedNames) {
+		s, c := storedFiles[name], cachedFiles[name]
+		if c != nil && s.Version != c.Version {
+			drift = append(drift, fmt.Sprintf("%s: %s %s is version %d in the database but %d in the cache",
+				item, what, name, s.Version, c.Version))
+		}
+	}
+	return drift
+}
+
+func diffManifests(stored, cached map[string]*swupd.Manifest) []string {
+	storedNames := make(map[string]bool)
+	for name := range stored {
+		storedNames[name] = true
+	}
+	cachedNames := make(map[string]bool)
+	for name := range cached {
+		cachedNames[name] = true
+	}
+
+	drift := []string{}
+	for _, name := range sortedKeys(storedNames, cachedNames) {
+		s, c := stored[name], cached[name]
+		item := "manifest " + name
+		switch {
+		case c == nil:
+			drift = append(drift, item+" is only in the database")
+		case s == nil:
+			drift = append(drift, item+" is only in the cache")
+		default:
+			drift = append(drift, diffField(item, "version", s.Header.Version, c.Header.Version)...)
+			drift = append(drift, diffManifestFiles(item, "file", s.Files, c.Files)...)
+			drift = append(drift, diffManifestFiles(item, "deleted file", s.DeletedFiles, c.DeletedFiles)...)
+		}
+	}
+	return drift
+}
+
+// VerifyManifests re-reads the manifests of mInfo from the update directory
+// under its CacheLoc and returns a description of every difference from the
+// manifests stored in the database
+func VerifyManifests(mInfo *ManifestInfo) ([]string, error) {
+	manifests, err := getManifests(mInfo.BundleInfo)
+	if err != nil {
+		return nil, err
+	}
+	cached := make(map[string]*swupd.Manifest)
+	for _, m := range manifests {
+		cached[m.Name] = m
+	}
+
+	stored := &ManifestInfo{BundleInfo: mInfo.BundleInfo, Manifests: make(map[string]*swupd.Manifest)}
+	if err = PopulateManifests(stored); err != nil {
+		return nil, err
+	}
+	stored.Manifests[stored.MoM.Name] = stored.MoM
+
+	return diffManifests(stored.Manifests, cached), nil
+}
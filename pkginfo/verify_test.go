@@ -0,0 +1,148 @@
+// Copyright © 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkginfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clearlinux/diva/bundle"
+	"github.com/clearlinux/diva/internal/config"
+	"github.com/clearlinux/mixer-tools/swupd"
+	"github.com/go-test/deep"
+)
+
+func TestDiffRepos(t *testing.T) {
+	stored := []*RPM{
+		{Name: "same", Version: "1", Release: "1", Architecture: "x86_64", Files: []*File{{Name: "/a"}}},
+		{Name: "changed", Version: "1", Release: "1", Architecture: "x86_64",
+			Requires: []Dependency{{Name: "lib", Flags: DepFlagGreater | DepFlagEqual, EVR: "1"}},
+			Files:    []*File{{Name: "/b", Hash: "1"}}},
+		{Name: "updated", Version: "1", Release: "1", Architecture: "noarch"},
+	}
+	cached := []*RPM{
+		{Name: "same", Version: "1", Release: "1", Architecture: "x86_64", Files: []*File{{Name: "/a"}}},
+		{Name: "changed", Version: "1", Release: "1", Architecture: "x86_64",
+			Requires: []Dependency{{Name: "lib", Flags: DepFlagGreater | DepFlagEqual, EVR: "2"}},
+			Files:    []*File{{Name: "/b", Hash: "2"}}},
+		{Name: "updated", Version: "2", Release: "1", Architecture: "noarch"},
+	}
+
+	expected := []string{
+		"rpm changed-1-1.x86_64: requirement lib >= 1 is only in the database",
+		"rpm changed-1-1.x86_64: requirement lib >= 2 is only in the cache",
+		"rpm changed-1-1.x86_64: file /b differs",
+		"rpm updated-1-1.noarch is only in the database",
+		"rpm updated-2-1.noarch is only in the cache",
+	}
+
+	if diff := deep.Equal(diffRepos(stored, cached), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestVerifyRepoCachePath(t *testing.T) {
+	dir := writeTestRepodata(t, testPrimary, testFilelists)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	repo := &Repo{
+		BaseInfo: BaseInfo{
+			Name:     "testrepo",
+			Version:  "100",
+			CacheLoc: dir,
+			Database: config.DatabaseConfig{Backend: BackendFile},
+			Provenance: Provenance{
+				CachePath: filepath.Join(dir, "packages"),
+				Repodata:  true,
+			},
+		},
+		Type: "B",
+		Arch: "x86_64",
+	}
+	if err := loadRepoFromRepodata(repo, dir); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openStore(&repo.BaseInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.StoreRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+
+	// the repo is re-read from the cache it was imported from, not from the
+	// RPMCache it would be imported from now
+	drift, err := VerifyRepo(&Repo{BaseInfo: repo.BaseInfo, Type: "B", Arch: "x86_64", RPMCache: filepath.Join(dir, "other", "packages")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Errorf("expected no drift, got %v", drift)
+	}
+}
+
+func TestDiffBundles(t *testing.T) {
+	stored := bundle.DefinitionsSet{
+		"os-core": &bundle.Definition{
+			Name:        "os-core",
+			AllPackages: map[string]bool{"bash": true},
+		},
+	}
+	cached := bundle.DefinitionsSet{
+		"os-core": &bundle.Definition{
+			Name:        "os-core",
+			Header:      bundle.Header{Title: "os-core"},
+			AllPackages: map[string]bool{"bash": true, "zsh": true},
+		},
+	}
+
+	expected := []string{
+		"bundle os-core: header differs",
+		"bundle os-core: package zsh is only in the cache",
+	}
+
+	if diff := deep.Equal(diffBundles(stored, cached), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	stored := map[string]*swupd.Manifest{
+		"os-core": {
+			Name:   "os-core",
+			Header: swupd.ManifestHeader{Version: 100},
+			Files:  []*swupd.File{{Name: "/usr/bin/bash", Version: 90}},
+		},
+	}
+	cached := map[string]*swupd.Manifest{
+		"os-core": {
+			Name:   "os-core",
+			Header: swupd.ManifestHeader{Version: 100},
+			Files:  []*swupd.File{{Name: "/usr/bin/bash", Version: 100}},
+		},
+	}
+
+	expected := []string{
+		"manifest os-core: file /usr/bin/bash is version 90 in the database but 100 in the cache",
+	}
+
+	if diff := deep.Equal(diffManifests(stored, cached), expected); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -183,7 +183,7 @@ func CheckFileHashes(r *diva.Results, c *config.Config, mInfo *pkginfo.ManifestI
 
 func checkBundleFileHashesPack(filesLoc string, m *swupd.Manifest, minVer uint) []error {
 	var wg sync.WaitGroup
-	workers := 4 // have to deal with "too many open files"
+	workers := helpers.DownloadJobs(4) // have to deal with "too many open files"
 	wg.Add(workers)
 	fCh := make(chan *swupd.File)
 	eCh := make(chan error, workers)
@@ -418,16 +418,18 @@ func CheckDeltaPacks(c *config.Config, m *swupd.Manifest) ([]string, error) {
 func CheckPacks(r *diva.Results, c *config.Config, mInfo *pkginfo.ManifestInfo, delta bool) error {
 	var err error
 	var wg sync.WaitGroup
-	workers := 4
+	workers := helpers.DownloadJobs(4)
 	wg.Add(workers)
 	bCh := make(chan *swupd.File)
 	eCh := make(chan error, workers)
+	progress := helpers.NewProgress("bundle packs", len(mInfo.MoM.Files))
 
 	for i := 0; i < workers; i++ {
 		go func() {
 			defer wg.Done()
 			for man := range bCh {
 				if uint(man.Version) < mInfo.MinVer {
+					progress.Done(1)
 					continue
 				}
 				mPath := filepath.Join(c.Paths.CacheLocation, "update", fmt.Sprint(man.Version), "Manifest."+man.Name)
@@ -454,6 +456,7 @@ func CheckPacks(r *diva.Results, c *config.Config, mInfo *pkginfo.ManifestInfo,
 				if len(failures) > 0 {
 					r.Diagnostic("pack issues:\n" + strings.Join(failures, "\n"))
 				}
+				progress.Done(1)
 			}
 		}()
 	}
@@ -468,6 +471,7 @@ func CheckPacks(r *diva.Results, c *config.Config, mInfo *pkginfo.ManifestInfo,
 	}
 	close(bCh)
 	wg.Wait()
+	progress.Finish()
 
 	if err == nil && len(eCh) > 0 {
 		err = <-eCh